@@ -5,8 +5,10 @@ import "time"
 type EvictionPolicy uint8
 
 const (
-	Random EvictionPolicy = 0
-	LRU    EvictionPolicy = 1
+	Random  EvictionPolicy = 0
+	LRU     EvictionPolicy = 1
+	LFU     EvictionPolicy = 2
+	TinyLFU EvictionPolicy = 3
 )
 
 type Config struct {
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/colindith/kash/store"
+)
+
+// errReplies map the store errors to the reply sent to the client. The replies are part of the protocol,
+// so they must not change when the error messages of the store package do.
+var errReplies = []struct {
+	err   error
+	reply string
+}{
+	{store.ErrKeyNotFound, "NOT OK: key not found"},
+	{store.ErrNotInteger, "NOT OK: value is not an integer"},
+	{store.ErrNotFloat, "NOT OK: value is not a float"},
+	{store.ErrOverflow, "NOT OK: increment or decrement would overflow"},
+	{store.ErrExceedMaxMemory, "NOT OK: value exceeds max memory"},
+	{store.ErrCodec, "NOT OK: invalid value"},
+	{store.ErrWrongType, "NOT OK: WRONGTYPE operation against a key holding the wrong kind of value"},
+	{context.Canceled, "NOT OK: canceled"},
+	{context.DeadlineExceeded, "NOT OK: timeout"},
+}
+
+const errReplyInternal = "NOT OK: internal error"
+
+// errReply return the reply for an error returned by the store
+func errReply(err error) string {
+	for _, r := range errReplies {
+		if errors.Is(err, r.err) {
+			return r.reply
+		}
+	}
+	return errReplyInternal
+}
@@ -3,10 +3,14 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -22,10 +26,26 @@ const (
 
 var (
 	respOK = []byte("OK\n")
+	// respNil is the reply of a SET which didn't set, or of a SET GET of a key which didn't exist
+	respNil = []byte("(nil)")
+	// respEmptyList is the reply of a LRANGE which found no element
+	respEmptyList = []byte("(empty list)")
 )
 
 var shardedMapStore store.Store
 
+type storeKey struct{}
+
+// withStore return a context holding the store the cmds are served from
+func withStore(ctx context.Context, s store.Store) context.Context {
+	return context.WithValue(ctx, storeKey{}, s)
+}
+
+func storeOf(ctx context.Context) store.Store {
+	s, _ := ctx.Value(storeKey{}).(store.Store)
+	return s
+}
+
 func main() {
 	StartKashServer(connPort)
 }
@@ -60,7 +80,13 @@ func runTCPServer(port string) {
 }
 
 func initStore() {
-	shardedMapStore = store.GetShardedMapStore()
+	shardedMapStore = store.GetShardedMapStore(
+		// The SET params are sub slices of the line read from the connection, so they are copied before being stored
+		store.SetCloneMode(store.CloneOnSet),
+		// The keys come from the clients, a seeded hash keeps them from piling up in one shard on purpose
+		store.SetHasher(store.MapHasher),
+		store.SetShardCount(4*runtime.NumCPU()),
+	)
 }
 
 func closeStore() {
@@ -70,43 +96,83 @@ func closeStore() {
 func handleConnection(c net.Conn) {
 	log.Printf("serving_connection | addr=%v", c.RemoteAddr().String())
 	defer c.Close()
-	for {
-		netData, err := bufio.NewReader(c).ReadBytes('\n')
-		if err == io.EOF {
-			return
-		}
-		if err != nil {
-			log.Printf("bufio_read_bytes_error | err=%v", err.Error())    // TODO: This is client input problem. Should not be error
-			return
-		}
 
+	// The context of the connection is canceled as soon as the client goes away, so the store abandons the
+	// command being served instead of finishing work nobody waits for
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = withStore(withSession(ctx), shardedMapStore)
+
+	for netData := range readCmdLines(ctx, cancel, c) {
 		args := bytes.Split(bytes.Trim(netData[:len(netData)-1], " "), []byte{' '})
 		if string(args[0]) == "STOP" {
 			break
 		}
 
-		result := respOK
-		var errMsg string
-		handler, ok := cmdHandlerRouter[strings.ToUpper(string(args[0]))]
-		if !ok {
-			result = []byte("cmd not recognized")
-		} else {
-			result, errMsg, ok = handler(args[1:]...)
-			if !ok {
-				result = []byte(errMsg)
-			}
+		result := serveCmd(ctx, args)
+		if ctx.Err() != nil {
+			// nobody to reply to
+			return
 		}
 		result = append(result, byte('\n'))
 
-		_, err = c.Write(result)
+		_, err := c.Write(result)
 		if err != nil {
 			log.Fatalf("net_connection_write_error | err=%v", err.Error())
 		}
 	}
+}
+
+// readCmdLines read the cmd lines sent by the client in the background, so that a client closing the connection
+// is noticed while a cmd is still being served. cancel is called and the channel is closed once the connection
+// can't be read anymore.
+func readCmdLines(ctx context.Context, cancel context.CancelFunc, c net.Conn) <-chan []byte {
+	lines := make(chan []byte)
+	go func() {
+		defer close(lines)
+		defer cancel()
+
+		reader := bufio.NewReader(c)
+		for {
+			netData, err := reader.ReadBytes('\n')
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("bufio_read_bytes_error | err=%v", err.Error())    // TODO: This is client input problem. Should not be error
+				}
+				return
+			}
+
+			select {
+			case lines <- netData:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return lines
+}
 
+// serveCmd run a cmd line and return the reply. In a MULTI the cmds are queued until EXEC instead.
+func serveCmd(ctx context.Context, args [][]byte) []byte {
+	name := strings.ToUpper(string(args[0]))
+	if sess := sessionOf(ctx); sess != nil && sess.inMulti && !txnControlCmds[name] {
+		return sess.enqueue(name, args[1:])
+	}
+	handler, ok := cmdHandlerRouter[name]
+	if !ok {
+		return []byte("cmd not recognized")
+	}
+	result, errMsg, ok := handler(ctx, args[1:]...)
+	if !ok {
+		return []byte(errMsg)
+	}
+	return result
 }
 
-type handlerFunc func(params... []byte) (resp []byte, errMsg string, ok bool)
+type handlerFunc func(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool)
 
 var cmdHandlerRouter map[string]handlerFunc
 
@@ -114,107 +180,725 @@ func initRouter() {
 	cmdHandlerRouter = map[string]handlerFunc{
 		"GET":  handleGETCmd,
 		"SET":  handleSETCmd,
+		"CAS":  handleCASCmd,
 		"DEL":  handleDELCmd,
+		"MGET": handleMGETCmd,
+		"MSET": handleMSETCmd,
 		"INCR": handleINCRCmd,
+		"DECR": handleDECRCmd,
 		"DUMP": handleDUMPALLCmd,
 		"TTL":  handleTTLCmd,
+		"PTTL": handlePTTLCmd,
+
+		"INCRBY":      handleINCRBYCmd,
+		"DECRBY":      handleDECRBYCmd,
+		"INCRBYFLOAT": handleINCRBYFLOATCmd,
+
+		"EXPIRE":   handleEXPIRECmd,
+		"EXPIREAT": handleEXPIREATCmd,
+		"PERSIST":  handlePERSISTCmd,
+		"TOUCH":    handleTOUCHCmd,
+
+		"LPUSH":  handleLPUSHCmd,
+		"RPUSH":  handleRPUSHCmd,
+		"LPOP":   handleLPOPCmd,
+		"RPOP":   handleRPOPCmd,
+		"LRANGE": handleLRANGECmd,
+		"LLEN":   handleLLENCmd,
+		"LINDEX": handleLINDEXCmd,
+		"LTRIM":  handleLTRIMCmd,
+
+		"HSET":    handleHSETCmd,
+		"HSETEX":  handleHSETEXCmd,
+		"HGET":    handleHGETCmd,
+		"HDEL":    handleHDELCmd,
+		"HGETALL": handleHGETALLCmd,
+		"HINCRBY": handleHINCRBYCmd,
+		"HEXISTS": handleHEXISTSCmd,
+		"HLEN":    handleHLENCmd,
+
+		"MULTI":   handleMULTICmd,
+		"EXEC":    handleEXECCmd,
+		"DISCARD": handleDISCARDCmd,
+		"WATCH":   handleWATCHCmd,
+		"UNWATCH": handleUNWATCHCmd,
 	}
 }
 
-func handleGETCmd(params... []byte) (resp []byte, errMsg string, ok bool) {
+func handleGETCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
 	if len(params) < 1 {
 		return nil, "not enough parameters", false
 	}
 	key := string(params[0])
 	// TODO: handle other params
-	value, code := shardedMapStore.Get(key)
-	if code != store.Success {
-		log.Printf("handler_get_cmd_failed | code=%v", code)
-		return nil, fmt.Sprintf("NOT OK: %v", code), false
+	value, err := storeOf(ctx).GetCtx(ctx, key)
+	if err != nil {
+		log.Printf("handler_get_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
+	}
+	resp = formatValue(value)
+	return append(resp, byte('\n')), "", true
+}
+
+// formatValue return a copy of a value of the store to reply. Never append to the cached slice itself, its spare
+// capacity is shared by the concurrent GETs.
+func formatValue(value interface{}) []byte {
+	data, isBytes := value.([]byte)
+	if !isBytes {
+		// the numbers INCR and INCRBYFLOAT create
+		data = []byte(fmt.Sprint(value))
 	}
-	resp = append(value.([]byte), byte('\n'))
-	return resp, "", true
+	resp := make([]byte, 0, len(data)+1)
+	return append(resp, data...)
 }
 
-func handleSETCmd(params... []byte) (resp []byte, errMsg string, ok bool) {
+// handleSETCmd serve SET key value [timeout] [NX|XX] [GET]. With NX the key is only set if it doesn't exist, with
+// XX only if it does, and the reply is (nil) when it isn't. With GET the reply is the previous value, or (nil),
+// whether the key was set or not.
+func handleSETCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
 	if len(params) < 2 {
 		return nil, "not enough parameters", false
 	}
 	key := string(params[0])
-	if len(params) == 2 {
-		code := shardedMapStore.Set(key, params[1])
-		if code != store.Success {
-			log.Printf("set_cmd_failed | code=%v", code)
-			return nil, fmt.Sprintf("NOT OK: %v", code), false
-		}
-	} else if len(params) == 3 {
-		timeout, err := strconv.Atoi(string(params[2]))
+	var timeout time.Duration
+	var hasTimeout, nx, xx, get bool
+	for _, param := range params[2:] {
+		switch strings.ToUpper(string(param)) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GET":
+			get = true
+		default:
+			seconds, err := strconv.Atoi(string(param))
+			if err != nil || hasTimeout {
+				log.Printf("parse_timeout_failed | param=%s", param)
+				return nil, "NOT OK: invalid timeout", false
+			}
+			timeout, hasTimeout = time.Duration(seconds)*time.Second, true
+		}
+	}
+	if nx && xx {
+		return nil, "NOT OK: syntax error", false
+	}
+	if get && (nx || xx) {
+		return setGetIf(ctx, key, params[1], timeout, nx)
+	}
+
+	var err error
+	set := true
+	switch {
+	case nx:
+		set, err = storeOf(ctx).SetNX(ctx, key, params[1], timeout)
+	case xx:
+		set, err = storeOf(ctx).SetXX(ctx, key, params[1], timeout)
+	case get:
+		var old interface{}
+		var existed bool
+		old, existed, err = storeOf(ctx).GetSet(ctx, key, params[1], timeout)
+		if err == nil {
+			if !existed {
+				return respNil, "", true
+			}
+			return formatValue(old), "", true
+		}
+	case hasTimeout:
+		err = storeOf(ctx).SetWithTimeoutCtx(ctx, key, params[1], timeout)
+	default:
+		err = storeOf(ctx).SetCtx(ctx, key, params[1])
+	}
+	if err != nil {
+		log.Printf("set_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
+	}
+	if !set {
+		return respNil, "", true
+	}
+	return respOK, "", true
+}
+
+// setGetIf serve SET with GET and NX or XX. The store has no such operation, so the key is read and set separately
+// until no write comes in between.
+func setGetIf(ctx context.Context, key string, value []byte, timeout time.Duration, nx bool) (resp []byte, errMsg string, ok bool) {
+	for {
+		if nx {
+			set, err := storeOf(ctx).SetNX(ctx, key, value, timeout)
+			if err != nil {
+				log.Printf("set_cmd_failed | err=%v", err)
+				return nil, errReply(err), false
+			}
+			if set {
+				return respNil, "", true
+			}
+		}
+		old, version, err := storeOf(ctx).GetWithVersion(ctx, key)
+		if errors.Is(err, store.ErrKeyNotFound) {
+			if nx {
+				// deleted since SetNX
+				continue
+			}
+			return respNil, "", true
+		}
+		if err != nil {
+			log.Printf("set_cmd_failed | err=%v", err)
+			return nil, errReply(err), false
+		}
+		if nx {
+			return formatValue(old), "", true
+		}
+		swapped, err := storeOf(ctx).CompareAndSwapVersion(ctx, key, version, value, timeout)
+		if err != nil {
+			log.Printf("set_cmd_failed | err=%v", err)
+			return nil, errReply(err), false
+		}
+		if swapped {
+			return formatValue(old), "", true
+		}
+	}
+}
+
+// handleCASCmd serve CAS key expected value [timeout]: key is set to value only if it holds expected, compared the
+// way GET replies it, so a number INCR wrote matches its digits. Reply 1 if it was set, 0 otherwise.
+func handleCASCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 3 {
+		return nil, "not enough parameters", false
+	}
+	key := string(params[0])
+	var timeout time.Duration
+	if len(params) > 3 {
+		seconds, err := strconv.Atoi(string(params[3]))
 		if err != nil {
 			log.Printf("parse_timeout_failed | msg=%v", err.Error())
 			return nil, "NOT OK: invalid timeout", false
 		}
-		code := shardedMapStore.SetWithTimeout(key, params[1], time.Duration(timeout)*time.Second)
-		if code != store.Success {
-			log.Printf("set_with_timeout_cmd_failed | code=%v", code)
-			return nil, fmt.Sprintf("NOT OK: %v", code), false
-		}
+		timeout = time.Duration(seconds) * time.Second
 	}
-	// TODO: handle other params
 
+	for {
+		current, version, err := storeOf(ctx).GetWithVersion(ctx, key)
+		if errors.Is(err, store.ErrKeyNotFound) {
+			return []byte("0"), "", true
+		}
+		if err != nil {
+			log.Printf("handler_cas_cmd_failed | err=%v", err)
+			return nil, errReply(err), false
+		}
+		if !bytes.Equal(formatValue(current), params[1]) {
+			return []byte("0"), "", true
+		}
+		swapped, err := storeOf(ctx).CompareAndSwapVersion(ctx, key, version, params[2], timeout)
+		if err != nil {
+			log.Printf("handler_cas_cmd_failed | err=%v", err)
+			return nil, errReply(err), false
+		}
+		if swapped {
+			return []byte("1"), "", true
+		}
+	}
+}
 
-	return respOK, "", true
+// handleDELCmd serve DEL key [key...] and reply the number of keys which existed
+func handleDELCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 1 {
+		return nil, "not enough parameters", false
+	}
+	keys := make([]string, len(params))
+	for i, key := range params {
+		keys[i] = string(key)
+	}
+	deleted, err := storeOf(ctx).MDelete(ctx, keys)
+	if err != nil {
+		log.Printf("handler_del_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
+	}
+	return []byte(strconv.Itoa(deleted)), "", true
 }
 
-func handleDELCmd(params... []byte) (resp []byte, errMsg string, ok bool) {
+// handleMGETCmd serve MGET key [key...] and reply the values one per line, (nil) for the missing keys
+func handleMGETCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
 	if len(params) < 1 {
 		return nil, "not enough parameters", false
 	}
-	key := string(params[0])
-	// TODO: handle other params
-	code := shardedMapStore.Delete(key)
-	if code != store.Success {
-		log.Printf("handler_del_cmd_failed | code=%v", code)
-		return nil, fmt.Sprintf("NOT OK: %v", code), false
+	keys := make([]string, len(params))
+	for i, key := range params {
+		keys[i] = string(key)
+	}
+	values, err := storeOf(ctx).MGet(ctx, keys)
+	if err != nil {
+		log.Printf("handler_mget_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
+	}
+	lines := make([][]byte, len(keys))
+	for i, key := range keys {
+		lines[i] = respNil
+		if value, found := values[key]; found {
+			lines[i] = formatValue(value)
+		}
+	}
+	return bytes.Join(lines, []byte{'\n'}), "", true
+}
+
+// handleMSETCmd serve MSET key value [key value...]. The values are set all at once, like redis.
+func handleMSETCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 2 || len(params)%2 != 0 {
+		return nil, "not enough parameters", false
+	}
+	values := make(map[string]interface{}, len(params)/2)
+	for i := 0; i < len(params); i += 2 {
+		values[string(params[i])] = params[i+1]
+	}
+	if err := storeOf(ctx).MSetAtomic(ctx, values, 0); err != nil {
+		log.Printf("handler_mset_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
 	}
 	return respOK, "", true
 }
 
-func handleINCRCmd(params... []byte) (resp []byte, errMsg string, ok bool) {
+// handleINCRCmd add one to the integer at key and reply the new value. A missing key starts from 0.
+func handleINCRCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
 	if len(params) < 1 {
 		return nil, "not enough parameters", false
 	}
-	key := string(params[0])
+	return replyIncrBy(ctx, string(params[0]), 1)
+}
 
-	code := shardedMapStore.Increase(key)
-	if code != store.Success {
-		log.Printf("handler_increase_cmd_failed | code=%v", code)
-		return nil, fmt.Sprintf("NOT OK: %v", code), false      // TODO: The returned err msg should be unified
+func handleDECRCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 1 {
+		return nil, "not enough parameters", false
 	}
-	return respOK, "", true
+	return replyIncrBy(ctx, string(params[0]), -1)
 }
 
-func handleDUMPALLCmd(params... []byte) (resp []byte, errMsg string, ok bool) {
+func handleINCRBYCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 2 {
+		return nil, "not enough parameters", false
+	}
+	delta, err := strconv.ParseInt(string(params[1]), 10, 64)
+	if err != nil {
+		log.Printf("parse_delta_failed | msg=%v", err.Error())
+		return nil, "NOT OK: invalid increment", false
+	}
+	return replyIncrBy(ctx, string(params[0]), delta)
+}
+
+func handleDECRBYCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 2 {
+		return nil, "not enough parameters", false
+	}
+	delta, err := strconv.ParseInt(string(params[1]), 10, 64)
+	if err != nil {
+		log.Printf("parse_delta_failed | msg=%v", err.Error())
+		return nil, "NOT OK: invalid decrement", false
+	}
+	n, err := storeOf(ctx).DecrBy(ctx, string(params[0]), delta)
+	if err != nil {
+		log.Printf("handler_decr_by_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
+	}
+	return []byte(strconv.FormatInt(n, 10)), "", true
+}
+
+func replyIncrBy(ctx context.Context, key string, delta int64) (resp []byte, errMsg string, ok bool) {
+	n, err := storeOf(ctx).IncrBy(ctx, key, delta)
+	if err != nil {
+		log.Printf("handler_incr_by_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
+	}
+	return []byte(strconv.FormatInt(n, 10)), "", true
+}
+
+func handleINCRBYFLOATCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 2 {
+		return nil, "not enough parameters", false
+	}
+	delta, err := strconv.ParseFloat(string(params[1]), 64)
+	if err != nil {
+		log.Printf("parse_delta_failed | msg=%v", err.Error())
+		return nil, "NOT OK: invalid increment", false
+	}
+	f, err := storeOf(ctx).IncrByFloat(ctx, string(params[0]), delta)
+	if err != nil {
+		log.Printf("handler_incr_by_float_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
+	}
+	return []byte(strconv.FormatFloat(f, 'f', -1, 64)), "", true
+}
+
+func handleDUMPALLCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
 	// ignore param?
 	// TODO: this method should limit the number of keys?
-	jsonStr, code := shardedMapStore.DumpAllJSON()
-	if code != code {
-		log.Printf("handler_dump_all_cmd_failed | code=%v", code)
-		return nil, fmt.Sprintf("NOT OK: %v", code), false
+	jsonStr, err := storeOf(ctx).DumpAllJSON()
+	if err != nil {
+		log.Printf("handler_dump_all_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
 	}
 	return []byte(jsonStr), "", true
 }
 
 
-func handleTTLCmd(params... []byte) (resp []byte, errMsg string, ok bool) {
+// handleTTLCmd reply the seconds key has left to live like redis: -1 if it has no timeout, -2 if it doesn't exist
+func handleTTLCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	return replyRemainingTTL(ctx, time.Second, params...)
+}
+
+// handlePTTLCmd is handleTTLCmd in milliseconds
+func handlePTTLCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	return replyRemainingTTL(ctx, time.Millisecond, params...)
+}
+
+func replyRemainingTTL(ctx context.Context, unit time.Duration, params... []byte) (resp []byte, errMsg string, ok bool) {
 	if len(params) < 1 {
 		return nil, "not enough parameters", false
 	}
 	key := string(params[0])
 
-	ttl, code := shardedMapStore.GetTTL(key)
-	if code != store.Success {
-		log.Printf("handler_get_ttl_cmd_failed | code=%v", code)
-		return nil, fmt.Sprintf("NOT OK: %v", code), false      // TODO: The returned err msg should be unified
+	ttl, err := storeOf(ctx).GetRemainingTTL(ctx, key)
+	if errors.Is(err, store.ErrKeyNotFound) {
+		return []byte("-2"), "", true
 	}
-	return []byte(strconv.Itoa(int(ttl - time.Now().UnixNano()))), "", true
-}
\ No newline at end of file
+	if err != nil {
+		log.Printf("handler_get_ttl_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
+	}
+	if ttl == store.NoExpiry {
+		return []byte("-1"), "", true
+	}
+	// rounded to the nearest unit, like redis
+	return []byte(strconv.FormatInt(int64((ttl+unit/2)/unit), 10)), "", true
+}
+
+// handleEXPIRECmd set the timeout of key in seconds. Reply 1 if it was set, 0 if the key doesn't exist.
+func handleEXPIRECmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 2 {
+		return nil, "not enough parameters", false
+	}
+	key := string(params[0])
+	timeout, err := strconv.Atoi(string(params[1]))
+	if err != nil {
+		log.Printf("parse_timeout_failed | msg=%v", err.Error())
+		return nil, "NOT OK: invalid timeout", false
+	}
+
+	return replyExpire(storeOf(ctx).Expire(ctx, key, time.Duration(timeout)*time.Second))
+}
+
+// handleEXPIREATCmd make key expire at a unix timestamp in seconds. Reply 1 if it was set, 0 if the key doesn't exist.
+func handleEXPIREATCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 2 {
+		return nil, "not enough parameters", false
+	}
+	key := string(params[0])
+	timestamp, err := strconv.ParseInt(string(params[1]), 10, 64)
+	if err != nil {
+		log.Printf("parse_timestamp_failed | msg=%v", err.Error())
+		return nil, "NOT OK: invalid timestamp", false
+	}
+
+	return replyExpire(storeOf(ctx).ExpireAt(ctx, key, time.Unix(timestamp, 0)))
+}
+
+func replyExpire(err error) (resp []byte, errMsg string, ok bool) {
+	if errors.Is(err, store.ErrKeyNotFound) {
+		return []byte("0"), "", true
+	}
+	if err != nil {
+		log.Printf("handler_expire_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
+	}
+	return []byte("1"), "", true
+}
+
+// handlePERSISTCmd remove the timeout of key. Reply 1 if it was removed, 0 if the key doesn't exist or has no timeout.
+func handlePERSISTCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 1 {
+		return nil, "not enough parameters", false
+	}
+	key := string(params[0])
+
+	persisted, err := storeOf(ctx).Persist(ctx, key)
+	if err == nil && !persisted {
+		return []byte("0"), "", true
+	}
+	return replyExpire(err)
+}
+
+// handleTOUCHCmd restart the timeout of the keys. Reply the number of keys which exist.
+func handleTOUCHCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 1 {
+		return nil, "not enough parameters", false
+	}
+
+	touched := 0
+	for _, key := range params {
+		err := storeOf(ctx).Touch(ctx, string(key))
+		if errors.Is(err, store.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			log.Printf("handler_touch_cmd_failed | err=%v", err)
+			return nil, errReply(err), false
+		}
+		touched++
+	}
+	return []byte(strconv.Itoa(touched)), "", true
+}
+
+// handleLPUSHCmd serve LPUSH key value [value...] and reply the length of the list
+func handleLPUSHCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	return replyPush(ctx, storeOf(ctx).LPush, params...)
+}
+
+func handleRPUSHCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	return replyPush(ctx, storeOf(ctx).RPush, params...)
+}
+
+func replyPush(ctx context.Context, push func(ctx context.Context, key string, values ...interface{}) (int, error), params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 2 {
+		return nil, "not enough parameters", false
+	}
+	values := make([]interface{}, len(params)-1)
+	for i, value := range params[1:] {
+		values[i] = value
+	}
+	n, err := push(ctx, string(params[0]), values...)
+	if err != nil {
+		log.Printf("handler_push_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
+	}
+	return []byte(strconv.Itoa(n)), "", true
+}
+
+// handleLPOPCmd serve LPOP key and reply the element removed, (nil) if the list is empty
+func handleLPOPCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 1 {
+		return nil, "not enough parameters", false
+	}
+	return replyElement(storeOf(ctx).LPop(ctx, string(params[0])))
+}
+
+func handleRPOPCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 1 {
+		return nil, "not enough parameters", false
+	}
+	return replyElement(storeOf(ctx).RPop(ctx, string(params[0])))
+}
+
+// handleLINDEXCmd serve LINDEX key index and reply the element, (nil) if the index is out of range
+func handleLINDEXCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 2 {
+		return nil, "not enough parameters", false
+	}
+	index, err := strconv.Atoi(string(params[1]))
+	if err != nil {
+		log.Printf("parse_index_failed | msg=%v", err.Error())
+		return nil, "NOT OK: invalid index", false
+	}
+	return replyElement(storeOf(ctx).LIndex(ctx, string(params[0]), index))
+}
+
+func replyElement(value interface{}, err error) (resp []byte, errMsg string, ok bool) {
+	if errors.Is(err, store.ErrKeyNotFound) {
+		return respNil, "", true
+	}
+	if err != nil {
+		log.Printf("handler_element_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
+	}
+	return formatValue(value), "", true
+}
+
+// handleLRANGECmd serve LRANGE key start stop and reply the elements one per line. Negative indexes count from
+// the tail, -1 being the last element.
+func handleLRANGECmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 3 {
+		return nil, "not enough parameters", false
+	}
+	start, stop, errMsg, ok := parseListRange(params[1], params[2])
+	if !ok {
+		return nil, errMsg, false
+	}
+	values, err := storeOf(ctx).LRange(ctx, string(params[0]), start, stop)
+	if err != nil {
+		log.Printf("handler_lrange_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
+	}
+	if len(values) == 0 {
+		return respEmptyList, "", true
+	}
+	lines := make([][]byte, len(values))
+	for i, value := range values {
+		lines[i] = formatValue(value)
+	}
+	return bytes.Join(lines, []byte{'\n'}), "", true
+}
+
+func handleLLENCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 1 {
+		return nil, "not enough parameters", false
+	}
+	n, err := storeOf(ctx).LLen(ctx, string(params[0]))
+	if err != nil {
+		log.Printf("handler_llen_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
+	}
+	return []byte(strconv.Itoa(n)), "", true
+}
+
+// handleLTRIMCmd serve LTRIM key start stop, which keep only the elements LRANGE would reply
+func handleLTRIMCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 3 {
+		return nil, "not enough parameters", false
+	}
+	start, stop, errMsg, ok := parseListRange(params[1], params[2])
+	if !ok {
+		return nil, errMsg, false
+	}
+	if err := storeOf(ctx).LTrim(ctx, string(params[0]), start, stop); err != nil {
+		log.Printf("handler_ltrim_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
+	}
+	return respOK, "", true
+}
+
+func parseListRange(startParam, stopParam []byte) (start, stop int, errMsg string, ok bool) {
+	start, err := strconv.Atoi(string(startParam))
+	if err == nil {
+		stop, err = strconv.Atoi(string(stopParam))
+	}
+	if err != nil {
+		log.Printf("parse_index_failed | msg=%v", err.Error())
+		return 0, 0, "NOT OK: invalid index", false
+	}
+	return start, stop, "", true
+}
+
+// handleHSETCmd serve HSET key field value [field value...] and reply the number of fields created
+func handleHSETCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 3 || len(params)%2 != 1 {
+		return nil, "not enough parameters", false
+	}
+	return replyHSet(ctx, string(params[0]), 0, params[1:]...)
+}
+
+// handleHSETEXCmd serve HSETEX key seconds field value [field value...], the fields set expire after the seconds
+func handleHSETEXCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 4 || len(params)%2 != 0 {
+		return nil, "not enough parameters", false
+	}
+	seconds, err := strconv.Atoi(string(params[1]))
+	if err != nil || seconds <= 0 {
+		log.Printf("parse_timeout_failed | param=%s", params[1])
+		return nil, "NOT OK: invalid timeout", false
+	}
+	return replyHSet(ctx, string(params[0]), time.Duration(seconds)*time.Second, params[2:]...)
+}
+
+func replyHSet(ctx context.Context, key string, timeout time.Duration, pairs... []byte) (resp []byte, errMsg string, ok bool) {
+	values := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		values[string(pairs[i])] = pairs[i+1]
+	}
+	n, err := storeOf(ctx).HSetWithTimeout(ctx, key, values, timeout)
+	if err != nil {
+		log.Printf("handler_hset_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
+	}
+	return []byte(strconv.Itoa(n)), "", true
+}
+
+// handleHGETCmd serve HGET key field and reply the value of the field, (nil) if it is missing
+func handleHGETCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 2 {
+		return nil, "not enough parameters", false
+	}
+	return replyElement(storeOf(ctx).HGet(ctx, string(params[0]), string(params[1])))
+}
+
+// handleHDELCmd serve HDEL key field [field...] and reply the number of fields which existed
+func handleHDELCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 2 {
+		return nil, "not enough parameters", false
+	}
+	fields := make([]string, len(params)-1)
+	for i, field := range params[1:] {
+		fields[i] = string(field)
+	}
+	n, err := storeOf(ctx).HDel(ctx, string(params[0]), fields...)
+	if err != nil {
+		log.Printf("handler_hdel_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
+	}
+	return []byte(strconv.Itoa(n)), "", true
+}
+
+// handleHGETALLCmd serve HGETALL key and reply each field and its value on their own line, sorted by field
+func handleHGETALLCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 1 {
+		return nil, "not enough parameters", false
+	}
+	values, err := storeOf(ctx).HGetAll(ctx, string(params[0]))
+	if err != nil {
+		log.Printf("handler_hgetall_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
+	}
+	if len(values) == 0 {
+		return respEmptyList, "", true
+	}
+	fields := make([]string, 0, len(values))
+	for field := range values {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	lines := make([][]byte, 0, 2*len(fields))
+	for _, field := range fields {
+		lines = append(lines, []byte(field), formatValue(values[field]))
+	}
+	return bytes.Join(lines, []byte{'\n'}), "", true
+}
+
+// handleHINCRBYCmd serve HINCRBY key field delta and reply the new value of the field
+func handleHINCRBYCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 3 {
+		return nil, "not enough parameters", false
+	}
+	delta, err := strconv.ParseInt(string(params[2]), 10, 64)
+	if err != nil {
+		log.Printf("parse_delta_failed | msg=%v", err.Error())
+		return nil, "NOT OK: invalid increment", false
+	}
+	n, err := storeOf(ctx).HIncrBy(ctx, string(params[0]), string(params[1]), delta)
+	if err != nil {
+		log.Printf("handler_hincr_by_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
+	}
+	return []byte(strconv.FormatInt(n, 10)), "", true
+}
+
+// handleHEXISTSCmd serve HEXISTS key field and reply 1 if the field exists, 0 otherwise
+func handleHEXISTSCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 2 {
+		return nil, "not enough parameters", false
+	}
+	found, err := storeOf(ctx).HExists(ctx, string(params[0]), string(params[1]))
+	if err != nil {
+		log.Printf("handler_hexists_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
+	}
+	if !found {
+		return []byte("0"), "", true
+	}
+	return []byte("1"), "", true
+}
+
+func handleHLENCmd(ctx context.Context, params... []byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 1 {
+		return nil, "not enough parameters", false
+	}
+	n, err := storeOf(ctx).HLen(ctx, string(params[0]))
+	if err != nil {
+		log.Printf("handler_hlen_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
+	}
+	return []byte(strconv.Itoa(n)), "", true
+}
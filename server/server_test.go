@@ -2,10 +2,15 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"testing"
 	"time"
+
+	"github.com/colindith/kash/store"
 )
 
 func Test_runTCPServer(t *testing.T) {
@@ -36,4 +41,270 @@ func Test_runTCPServer(t *testing.T) {
 	if resp != "123456\n" {         // TODO: the \n is used as delimiter. This is should be handled by tcp client
 		t.Errorf("get_incorrect_cached_data | data=%v, want=%v", resp, "123456")
 	}
-}
\ No newline at end of file
+}
+func Test_errReply(t *testing.T) {
+	s := store.GetShardedMapStore()
+	_, err := s.Get("missing")
+	if reply := errReply(err); reply != "NOT OK: key not found" {
+		t.Errorf("get_incorrect_err_reply | reply=%v", reply)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.GetCtx(ctx, "missing")
+	if reply := errReply(err); reply != "NOT OK: canceled" {
+		t.Errorf("get_incorrect_err_reply | reply=%v", reply)
+	}
+	if reply := errReply(errors.New("boom")); reply != errReplyInternal {
+		t.Errorf("get_incorrect_err_reply | reply=%v", reply)
+	}
+}
+
+func Test_expiryCmds(t *testing.T) {
+	s := store.GetShardedMapStore()
+	_ = s.Set("key", []byte("v"))
+	conn := newConn(s)
+	runSteps(t, []step{
+		{conn, "TTL key", "-1"},
+		{conn, "PTTL missing", "-2"},
+		{conn, "EXPIRE key 100", "1"},
+		{conn, "TTL key", "100"},
+		{conn, "EXPIRE missing 100", "0"},
+		{conn, "EXPIRE key soon", "NOT OK: invalid timeout"},
+		{conn, "PERSIST key", "1"},
+		{conn, "PERSIST key", "0"},
+		{conn, "PTTL key", "-1"},
+		{conn, "EXPIREAT key " + fmt.Sprint(time.Now().Add(time.Hour).Unix()), "1"},
+		{conn, "TOUCH key missing", "1"},
+		{conn, "EXPIREAT key 1", "1"},
+		{conn, "TTL key", "-2"},
+	})
+}
+
+func Test_numericCmds(t *testing.T) {
+	s := store.GetShardedMapStore()
+	_ = s.Set("bytes", []byte("10"))
+	conn := newConn(s)
+	runSteps(t, []step{
+		{conn, "INCR bytes", "11"},
+		{conn, "INCRBY bytes -20", "-9"},
+		{conn, "DECR bytes", "-10"},
+		{conn, "DECRBY bytes 5", "-15"},
+		{conn, "INCRBYFLOAT bytes 0.5", "-14.5"},
+		{conn, "GET bytes", "-14.5\n"},
+		{conn, "INCR bytes", "NOT OK: value is not an integer"},
+		{conn, "INCRBY new 9223372036854775807", "9223372036854775807"},
+		{conn, "INCR new", "NOT OK: increment or decrement would overflow"},
+		{conn, "INCRBY new many", "NOT OK: invalid increment"},
+		{conn, "GET new", "9223372036854775807\n"},
+	})
+}
+
+// step is a cmd line served on the connection of ctx, and the reply it should get
+type step struct {
+	ctx  context.Context
+	line string
+	want string
+}
+
+// newConn return the context of a new connection served from s
+func newConn(s store.Store) context.Context {
+	return withSession(withStore(context.Background(), s))
+}
+
+// runSteps serve the cmd lines of steps in order like the connection loop and check their replies
+func runSteps(t *testing.T, steps []step) {
+	t.Helper()
+	initRouter()
+	for i, step := range steps {
+		if got := string(serveCmd(step.ctx, bytes.Split([]byte(step.line), []byte{' '}))); got != step.want {
+			t.Errorf("get_incorrect_resp | step=%v, resp=%v, want=%v", i, got, step.want)
+		}
+	}
+}
+
+func Test_conditionalSetCmds(t *testing.T) {
+	s := store.GetShardedMapStore(store.SetCloneMode(store.CloneOnSet))
+	conn := newConn(s)
+	runSteps(t, []step{
+		{conn, "SET lock a XX", "(nil)"},
+		{conn, "SET lock a 10 NX", "OK\n"},
+		{conn, "SET lock b nx", "(nil)"},
+		{conn, "TTL lock", "10"},
+		{conn, "SET lock c XX", "OK\n"},
+		{conn, "SET lock d GET", "c"},
+		{conn, "SET fresh e GET", "(nil)"},
+		{conn, "SET lock e NX XX", "NOT OK: syntax error"},
+		{conn, "SET lock e NX GET", "d"},
+		{conn, "SET nx e NX GET", "(nil)"},
+		{conn, "GET nx", "e\n"},
+		{conn, "SET xx e XX GET", "(nil)"},
+		{conn, "GET xx", "NOT OK: key not found"},
+		{conn, "SET nx g XX GET", "e"},
+		{conn, "GET nx", "g\n"},
+		{conn, "SET lock e soon", "NOT OK: invalid timeout"},
+		{conn, "CAS lock x f", "0"},
+		{conn, "CAS lock d f", "1"},
+		{conn, "CAS missing d f", "0"},
+		{conn, "GET lock", "f\n"},
+		{conn, "INCR counter", "1"},
+		{conn, "CAS counter 1 h", "1"},
+		{conn, "GET counter", "h\n"},
+	})
+}
+
+func Test_batchCmds(t *testing.T) {
+	s := store.GetShardedMapStore(store.SetCloneMode(store.CloneOnSet))
+	conn := newConn(s)
+	runSteps(t, []step{
+		{conn, "MSET a 1 b 2 c 3", "OK\n"},
+		{conn, "MSET a 1 b", "not enough parameters"},
+		{conn, "MGET a missing c", "1\n(nil)\n3"},
+		{conn, "DEL a b missing", "2"},
+		{conn, "MGET a c", "(nil)\n3"},
+	})
+}
+
+func Test_txnCmds(t *testing.T) {
+	s := store.GetShardedMapStore(store.SetCloneMode(store.CloneOnSet))
+	conn, other := newConn(s), newConn(s)
+	runSteps(t, []step{
+		{conn, "SET a 10", "OK\n"},
+		{conn, "EXEC", "NOT OK: EXEC without MULTI"},
+		{conn, "MULTI", "OK\n"},
+		{conn, "INCRBY a 5", "QUEUED"},
+		{conn, "SET b x", "QUEUED"},
+		{conn, "GET b", "QUEUED"},
+		{conn, "INCR b", "QUEUED"},
+		{other, "GET b", "NOT OK: key not found"},
+		{conn, "EXEC", "15\nOK\nx\nNOT OK: value is not an integer"},
+		{conn, "MGET a b", "15\nx"},
+		// a watched key changed by another connection aborts the EXEC
+		{conn, "WATCH a missing", "OK\n"},
+		{other, "SET a 1", "OK\n"},
+		{conn, "MULTI", "OK\n"},
+		{conn, "DEL a b", "QUEUED"},
+		{conn, "EXEC", "(nil)"},
+		{conn, "MGET a b", "1\nx"},
+		// the WATCH is over after the EXEC
+		{conn, "MULTI", "OK\n"},
+		{conn, "DEL a b missing", "QUEUED"},
+		{conn, "EXEC", "2"},
+		{conn, "WATCH missing", "OK\n"},
+		{other, "SET missing 1", "OK\n"},
+		{conn, "MULTI", "OK\n"},
+		{conn, "DECR a", "QUEUED"},
+		{conn, "EXEC", "(nil)"},
+		{conn, "WATCH missing", "OK\n"},
+		{conn, "UNWATCH", "OK\n"},
+		{other, "DEL missing", "1"},
+		{conn, "MULTI", "OK\n"},
+		{conn, "MULTI", "NOT OK: MULTI calls can not be nested"},
+		{conn, "WATCH a", "NOT OK: WATCH inside MULTI is not allowed"},
+		{conn, "DECR a", "QUEUED"},
+		{conn, "EXEC", "-1"},
+		// a cmd which can't be queued discards the transaction
+		{conn, "MULTI", "OK\n"},
+		{conn, "SET a", "not enough parameters"},
+		{conn, "INCR a", "QUEUED"},
+		{conn, "EXEC", "NOT OK: transaction discarded because of previous errors"},
+		{conn, "MULTI", "OK\n"},
+		{conn, "TTL a", "NOT OK: cmd not allowed in MULTI"},
+		{conn, "DISCARD", "OK\n"},
+		{conn, "GET a", "-1\n"},
+	})
+}
+
+func Test_txnDefaultTimeout(t *testing.T) {
+	s := store.GetShardedMapStore(store.SetDefaultTimeout(time.Minute))
+	conn := newConn(s)
+	runSteps(t, []step{
+		{conn, "MULTI", "OK\n"},
+		{conn, "SET a 1", "QUEUED"},
+		{conn, "SET b 1 10", "QUEUED"},
+		{conn, "EXEC", "OK\nOK"},
+		{conn, "TTL a", "60"},
+		{conn, "TTL b", "10"},
+	})
+}
+
+func Test_txnCollectionCmds(t *testing.T) {
+	s := store.GetShardedMapStore(store.SetCloneMode(store.CloneOnSet))
+	conn, other := newConn(s), newConn(s)
+	runSteps(t, []step{
+		{conn, "RPUSH jobs a", "1"},
+		{conn, "HSET user name ann", "1"},
+		// the lists and the hashes can be watched
+		{conn, "WATCH jobs user", "OK\n"},
+		{conn, "MULTI", "OK\n"},
+		{conn, "SET done 1", "QUEUED"},
+		{conn, "EXEC", "OK"},
+		{conn, "WATCH jobs", "OK\n"},
+		{other, "RPUSH jobs b", "2"},
+		{conn, "MULTI", "OK\n"},
+		{conn, "SET done 2", "QUEUED"},
+		{conn, "EXEC", "(nil)"},
+		{conn, "WATCH user", "OK\n"},
+		{other, "HSET user city oslo", "1"},
+		{conn, "MULTI", "OK\n"},
+		{conn, "SET done 3", "QUEUED"},
+		{conn, "EXEC", "(nil)"},
+		{conn, "GET done", "1\n"},
+		// DEL deletes them like outside of a MULTI, GET still refuses them
+		{conn, "MULTI", "OK\n"},
+		{conn, "GET jobs", "QUEUED"},
+		{conn, "DEL jobs user missing", "QUEUED"},
+		{conn, "DEL jobs", "QUEUED"},
+		{conn, "EXEC", "NOT OK: WRONGTYPE operation against a key holding the wrong kind of value\n2\n0"},
+		{conn, "LLEN jobs", "0"},
+		{conn, "HLEN user", "0"},
+	})
+}
+
+func Test_listCmds(t *testing.T) {
+	s := store.GetShardedMapStore(store.SetCloneMode(store.CloneOnSet))
+	conn := newConn(s)
+	runSteps(t, []step{
+		{conn, "RPUSH jobs b c", "2"},
+		{conn, "LPUSH jobs a", "3"},
+		{conn, "LPUSH jobs", "not enough parameters"},
+		{conn, "LRANGE jobs 0 -1", "a\nb\nc"},
+		{conn, "LRANGE jobs 5 10", "(empty list)"},
+		{conn, "LRANGE jobs 0 end", "NOT OK: invalid index"},
+		{conn, "LLEN jobs", "3"},
+		{conn, "LINDEX jobs -1", "c"},
+		{conn, "LINDEX jobs 3", "(nil)"},
+		{conn, "LPOP jobs", "a"},
+		{conn, "RPOP jobs", "c"},
+		{conn, "LTRIM jobs 1 -1", "OK\n"},
+		{conn, "LPOP jobs", "(nil)"},
+		{conn, "LLEN jobs", "0"},
+		{conn, "SET string value", "OK\n"},
+		{conn, "LPUSH string a", "NOT OK: WRONGTYPE operation against a key holding the wrong kind of value"},
+		{conn, "RPUSH list a", "1"},
+		{conn, "GET list", "NOT OK: WRONGTYPE operation against a key holding the wrong kind of value"},
+	})
+}
+
+func Test_hashCmds(t *testing.T) {
+	s := store.GetShardedMapStore(store.SetCloneMode(store.CloneOnSet))
+	conn := newConn(s)
+	runSteps(t, []step{
+		{conn, "HSET user name ann city oslo", "2"},
+		{conn, "HSET user name bob", "0"},
+		{conn, "HSET user name", "not enough parameters"},
+		{conn, "HSETEX user 60 token t1", "1"},
+		{conn, "HSETEX user soon token t1", "NOT OK: invalid timeout"},
+		{conn, "HGET user name", "bob"},
+		{conn, "HGET user missing", "(nil)"},
+		{conn, "HINCRBY user visits 5", "5"},
+		{conn, "HINCRBY user name 5", "NOT OK: value is not an integer"},
+		{conn, "HGETALL user", "city\noslo\nname\nbob\ntoken\nt1\nvisits\n5"},
+		{conn, "HEXISTS user city", "1"},
+		{conn, "HDEL user city missing", "1"},
+		{conn, "HEXISTS user city", "0"},
+		{conn, "HLEN user", "3"},
+		{conn, "HGETALL missing", "(empty list)"},
+		{conn, "RPUSH list a", "1"},
+		{conn, "HGET list a", "NOT OK: WRONGTYPE operation against a key holding the wrong kind of value"},
+	})
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/colindith/kash/store"
+)
+
+var respQueued = []byte("QUEUED")
+
+// errWatchedKeyChanged abort the transaction of an EXEC
+var errWatchedKeyChanged = errors.New("watched key changed")
+
+// session is the transaction state of a connection
+type session struct {
+	inMulti bool
+	// aborted is set when a cmd can't be queued, EXEC then discards the transaction
+	aborted bool
+	queue   [][][]byte
+	// watched are the versions of the keys given to WATCH, 0 for the keys which didn't exist
+	watched map[string]uint64
+}
+
+type sessionKey struct{}
+
+// withSession return a context holding a new session, for the cmds of one connection
+func withSession(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, &session{})
+}
+
+func sessionOf(ctx context.Context) *session {
+	sess, _ := ctx.Value(sessionKey{}).(*session)
+	return sess
+}
+
+// txnControlCmds are run right away in a MULTI, instead of being queued
+var txnControlCmds = map[string]bool{
+	"MULTI":   true,
+	"EXEC":    true,
+	"DISCARD": true,
+	"WATCH":   true,
+	"UNWATCH": true,
+}
+
+// txnCmd is a cmd which can be queued in a MULTI
+type txnCmd struct {
+	// keys return the keys the cmd uses, false if its params are invalid
+	keys func(params ...[]byte) ([]string, bool)
+	exec func(tx store.Tx, params ...[]byte) (resp []byte, errMsg string, ok bool)
+}
+
+var txnCmds = map[string]txnCmd{
+	"GET":    {keys: firstKey(1), exec: execGET},
+	"SET":    {keys: firstKey(2), exec: execSET},
+	"DEL":    {keys: allKeys, exec: execDEL},
+	"INCR":   {keys: firstKey(1), exec: execIncrBy(1)},
+	"DECR":   {keys: firstKey(1), exec: execIncrBy(-1)},
+	"INCRBY": {keys: firstKey(2), exec: execIncrBy(1)},
+	"DECRBY": {keys: firstKey(2), exec: execIncrBy(-1)},
+}
+
+// firstKey is for the cmds whose first param is the key, and which take at least n params
+func firstKey(n int) func(params ...[]byte) ([]string, bool) {
+	return func(params ...[]byte) ([]string, bool) {
+		if len(params) < n {
+			return nil, false
+		}
+		return []string{string(params[0])}, true
+	}
+}
+
+func allKeys(params ...[]byte) ([]string, bool) {
+	if len(params) < 1 {
+		return nil, false
+	}
+	keys := make([]string, len(params))
+	for i, key := range params {
+		keys[i] = string(key)
+	}
+	return keys, true
+}
+
+// enqueue queue a cmd of the MULTI, and reply QUEUED
+func (sess *session) enqueue(name string, params [][]byte) []byte {
+	cmd, ok := txnCmds[name]
+	if !ok {
+		sess.aborted = true
+		return []byte("NOT OK: cmd not allowed in MULTI")
+	}
+	if _, ok := cmd.keys(params...); !ok {
+		sess.aborted = true
+		return []byte("not enough parameters")
+	}
+	sess.queue = append(sess.queue, append([][]byte{[]byte(name)}, params...))
+	return respQueued
+}
+
+func (sess *session) reset() {
+	*sess = session{}
+}
+
+func handleMULTICmd(ctx context.Context, params ...[]byte) (resp []byte, errMsg string, ok bool) {
+	sess := sessionOf(ctx)
+	if sess == nil {
+		return nil, "NOT OK: no session", false
+	}
+	if sess.inMulti {
+		return nil, "NOT OK: MULTI calls can not be nested", false
+	}
+	sess.inMulti = true
+	return respOK, "", true
+}
+
+func handleDISCARDCmd(ctx context.Context, params ...[]byte) (resp []byte, errMsg string, ok bool) {
+	sess := sessionOf(ctx)
+	if sess == nil || !sess.inMulti {
+		return nil, "NOT OK: DISCARD without MULTI", false
+	}
+	sess.reset()
+	return respOK, "", true
+}
+
+// handleWATCHCmd serve WATCH key [key...]. The next EXEC fails if one of the keys is changed before it.
+func handleWATCHCmd(ctx context.Context, params ...[]byte) (resp []byte, errMsg string, ok bool) {
+	if len(params) < 1 {
+		return nil, "not enough parameters", false
+	}
+	sess := sessionOf(ctx)
+	if sess == nil {
+		return nil, "NOT OK: no session", false
+	}
+	if sess.inMulti {
+		return nil, "NOT OK: WATCH inside MULTI is not allowed", false
+	}
+	if sess.watched == nil {
+		sess.watched = make(map[string]uint64, len(params))
+	}
+	for _, param := range params {
+		key := string(param)
+		if _, watched := sess.watched[key]; watched {
+			continue
+		}
+		// Version doesn't touch the key, watching it doesn't keep it from being evicted or expiring
+		version, err := storeOf(ctx).Version(ctx, key)
+		if err != nil {
+			log.Printf("handler_watch_cmd_failed | err=%v", err)
+			return nil, errReply(err), false
+		}
+		sess.watched[key] = version
+	}
+	return respOK, "", true
+}
+
+func handleUNWATCHCmd(ctx context.Context, params ...[]byte) (resp []byte, errMsg string, ok bool) {
+	if sess := sessionOf(ctx); sess != nil {
+		sess.watched = nil
+	}
+	return respOK, "", true
+}
+
+// handleEXECCmd run the cmds queued since MULTI in one transaction and reply their replies, one per line. The
+// reply is (nil) and nothing is run if a watched key was changed.
+func handleEXECCmd(ctx context.Context, params ...[]byte) (resp []byte, errMsg string, ok bool) {
+	sess := sessionOf(ctx)
+	if sess == nil || !sess.inMulti {
+		return nil, "NOT OK: EXEC without MULTI", false
+	}
+	queue, watched, aborted := sess.queue, sess.watched, sess.aborted
+	sess.reset()
+	if aborted {
+		return nil, "NOT OK: transaction discarded because of previous errors", false
+	}
+
+	var keys []string
+	for key := range watched {
+		keys = append(keys, key)
+	}
+	for _, args := range queue {
+		cmdKeys, _ := txnCmds[string(args[0])].keys(args[1:]...)
+		keys = append(keys, cmdKeys...)
+	}
+
+	lines := make([][]byte, len(queue))
+	err := storeOf(ctx).Txn(ctx, keys, func(tx store.Tx) error {
+		for key, version := range watched {
+			current, err := tx.Version(key)
+			if err != nil {
+				return err
+			}
+			if current != version {
+				return errWatchedKeyChanged
+			}
+		}
+		for i, args := range queue {
+			resp, errMsg, ok := txnCmds[string(args[0])].exec(tx, args[1:]...)
+			if !ok {
+				resp = []byte(errMsg)
+			}
+			lines[i] = resp
+		}
+		return nil
+	})
+	if errors.Is(err, errWatchedKeyChanged) {
+		return respNil, "", true
+	}
+	if err != nil {
+		log.Printf("handler_exec_cmd_failed | err=%v", err)
+		return nil, errReply(err), false
+	}
+	return bytes.Join(lines, []byte{'\n'}), "", true
+}
+
+func execGET(tx store.Tx, params ...[]byte) (resp []byte, errMsg string, ok bool) {
+	value, err := tx.Get(string(params[0]))
+	if err != nil {
+		return nil, errReply(err), false
+	}
+	return formatValue(value), "", true
+}
+
+// execSET serve SET key value [timeout], the NX, XX and GET flags aren't supported in a MULTI
+func execSET(tx store.Tx, params ...[]byte) (resp []byte, errMsg string, ok bool) {
+	// the params are sub slices of the line read from the connection
+	value := append([]byte(nil), params[1]...)
+	var err error
+	if len(params) > 2 {
+		seconds, parseErr := strconv.Atoi(string(params[2]))
+		if parseErr != nil || len(params) > 3 {
+			return nil, "NOT OK: invalid timeout", false
+		}
+		err = tx.Set(string(params[0]), value, time.Duration(seconds)*time.Second)
+	} else {
+		err = tx.SetWithDefaultTimeout(string(params[0]), value)
+	}
+	if err != nil {
+		return nil, errReply(err), false
+	}
+	return []byte("OK"), "", true
+}
+
+func execDEL(tx store.Tx, params ...[]byte) (resp []byte, errMsg string, ok bool) {
+	deleted := 0
+	for _, param := range params {
+		key := string(param)
+		_, err := tx.Get(key)
+		if errors.Is(err, store.ErrKeyNotFound) {
+			continue
+		}
+		// a list or a hash can't be read by the transaction, but is deleted all the same
+		if err != nil && !errors.Is(err, store.ErrWrongType) {
+			return nil, errReply(err), false
+		}
+		if err := tx.Delete(key); err != nil {
+			return nil, errReply(err), false
+		}
+		deleted++
+	}
+	return []byte(strconv.Itoa(deleted)), "", true
+}
+
+// execIncrBy serve INCR, DECR, INCRBY and DECRBY, sign is -1 for the decrements
+func execIncrBy(sign int64) func(tx store.Tx, params ...[]byte) (resp []byte, errMsg string, ok bool) {
+	return func(tx store.Tx, params ...[]byte) (resp []byte, errMsg string, ok bool) {
+		delta := int64(1)
+		if len(params) > 1 {
+			var err error
+			if delta, err = strconv.ParseInt(string(params[1]), 10, 64); err != nil {
+				return nil, "NOT OK: invalid increment", false
+			}
+		}
+		if sign < 0 && delta == math.MinInt64 {
+			return nil, errReply(store.ErrOverflow), false
+		}
+		n, err := tx.IncrBy(string(params[0]), sign*delta)
+		if err != nil {
+			return nil, errReply(err), false
+		}
+		return []byte(strconv.FormatInt(n, 10)), "", true
+	}
+}
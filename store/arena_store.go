@@ -0,0 +1,1502 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultArenaSize = 32 << 20 // the total size of the slabs when no max memory is set
+
+	// The header of an arena entry: deadline, soft deadline, key hash, value length, key length, flags, timeout
+	// and version
+	arenaHeaderSize = 48
+	arenaMaxKeyLen  = 1<<16 - 1
+
+	arenaDeleted = 1 << 0 // the entry was deleted or overwritten, its bytes wait to be reclaimed by the ring
+	arenaRaw     = 1 << 1 // the value is a []byte stored as it is, not encoded with the codec
+	arenaSliding = 1 << 2 // every read restarts the timeout
+	arenaList    = 1 << 3 // the value is a list encoded with encodeList
+	arenaHash    = 1 << 4 // the value is a hash encoded with encodeHash
+
+	arenaCollection = arenaList | arenaHash
+)
+
+var errKeyTooLong = errors.New("key too long")
+
+// arenaStore keep the keys and the values in one preallocated byte slab per shard, indexed by a pointer free
+// map[uint64]uint32, so the garbage collector doesn't scan the entries however many there are.
+//
+// Each slab is a ring buffer: the entries are appended at its end, and the oldest entries are evicted to make room,
+// whether they are still used or not. The values other than []byte are encoded with the codec, BinaryCodec by
+// default, so the store always hands out copies. Two keys with the same 64 bits hash can't be stored together,
+// the last one set evicts the other.
+type arenaStore struct {
+	shards     []arenaShard
+	shardCount int    // A power of two
+	shardMask  uint64 // shardCount - 1, picks the shard from the low bits of the hash
+	hash       Hasher
+
+	clock          Clock
+	defaultTimeout time.Duration
+	maxMemory      int64 // unit: bytes. The total size of the slabs.
+	capacity       int
+	codec          Codec
+
+	// Read-through
+	loads       loadGroup
+	loadTTL     time.Duration
+	negativeTTL time.Duration
+	stats       Stats // Access with atomic
+
+	// Stale-while-revalidate
+	staleAfter    time.Duration
+	refreshAhead  time.Duration
+	refreshLoader Loader
+
+	slidingExpiration bool
+	versions          uint64 // the last version given to a value written. Access with atomic
+
+	closeOnce sync.Once
+}
+
+type arenaShard struct {
+	mu    shardMutex
+	index map[uint64]uint32 // key hash to the position of its entry in buf
+	buf   []byte
+	// The virtual positions of the oldest entry and of the next write. They only grow, the position in buf is
+	// the virtual one modulo len(buf).
+	begin uint64
+	end   uint64
+
+	capacity int    // max live entries, 0 means no limit
+	length   int64  // live entries. Access with atomic
+	used     int64  // bytes of the live entries. Access with atomic
+	deleted  uint64 // the version given when a key of this shard was last deleted by a caller
+}
+
+type arenaHeader struct {
+	deadline     int64 // timestamp nanosecond
+	softDeadline int64 // timestamp nanosecond
+	hash         uint64
+	valueLen     uint32
+	keyLen       uint16
+	flags        uint8
+	timeout      int64 // nanosecond, what Touch restarts the deadline with. 0 means no timeout.
+	version      uint64
+}
+
+func (h *arenaHeader) size() uint64 {
+	return arenaHeaderSize + uint64(h.keyLen) + uint64(h.valueLen)
+}
+
+// GetArenaStore return a Store keeping its data in preallocated slabs, see arenaStore. SetMaxMemory sets the total
+// size of the slabs, 32MB by default, and SetCapacity limits the number of keys. The options about the eviction
+// policy, the expiry janitor, the compression and the backend are not supported and are ignored with a log.
+// The values are always isolated, so the clone options have no effect.
+func GetArenaStore(opts ...Option) Store {
+	s := &arenaStore{
+		shardCount: defaultShardCount,
+		hash:       FNVHasher,
+		clock:      realClock{},
+		codec:      BinaryCodec{},
+		loads:      newLoadGroup(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	size := s.maxMemory
+	if size == 0 {
+		size = defaultArenaSize
+	}
+	shardSize := size / int64(s.shardCount)
+	if shardSize < arenaHeaderSize {
+		shardSize = arenaHeaderSize
+	}
+	if shardSize > 1<<32-1 {
+		shardSize = 1<<32 - 1
+	}
+	s.shards = make([]arenaShard, s.shardCount)
+	s.shardMask = uint64(s.shardCount - 1)
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu = newShardMutex()
+		sh.index = make(map[uint64]uint32)
+		sh.buf = make([]byte, shardSize)
+		sh.capacity = (s.capacity + s.shardCount - 1) / s.shardCount
+	}
+
+	s.loads.clock = s.clock
+	s.loads.stats = &s.stats
+	s.loads.negativeTTL = s.negativeTTL
+	s.loads.store = func(key string, value interface{}, version, since uint64) {
+		// A value too large to be cached is still served to the callers
+		_, _ = s.setIf(context.Background(), key, value, s.staleAfter, s.loaderTTL(), s.slidingExpiration, func(sh *arenaShard, pos uint64, h *arenaHeader) bool {
+			if h == nil {
+				return sh.deleted <= since
+			}
+			return h.version == version
+		})
+	}
+	return s
+}
+
+func (s *arenaStore) selectShard(hash uint64) *arenaShard {
+	return &s.shards[hash&s.shardMask]
+}
+
+// copyOut fill dst with the bytes of buf from the virtual position pos, wrapping around its end
+func (sh *arenaShard) copyOut(dst []byte, pos uint64) {
+	p := pos % uint64(len(sh.buf))
+	n := copy(dst, sh.buf[p:])
+	copy(dst[n:], sh.buf)
+}
+
+// copyIn write src to buf from the virtual position pos, wrapping around its end
+func (sh *arenaShard) copyIn(pos uint64, src []byte) {
+	p := pos % uint64(len(sh.buf))
+	n := copy(sh.buf[p:], src)
+	copy(sh.buf, src[n:])
+}
+
+func (sh *arenaShard) readHeader(pos uint64) arenaHeader {
+	var b [arenaHeaderSize]byte
+	sh.copyOut(b[:], pos)
+	return arenaHeader{
+		deadline:     int64(binary.LittleEndian.Uint64(b[0:])),
+		softDeadline: int64(binary.LittleEndian.Uint64(b[8:])),
+		hash:         binary.LittleEndian.Uint64(b[16:]),
+		valueLen:     binary.LittleEndian.Uint32(b[24:]),
+		keyLen:       binary.LittleEndian.Uint16(b[28:]),
+		flags:        b[30],
+		timeout:      int64(binary.LittleEndian.Uint64(b[32:])),
+		version:      binary.LittleEndian.Uint64(b[40:]),
+	}
+}
+
+func (sh *arenaShard) writeHeader(pos uint64, h *arenaHeader) {
+	var b [arenaHeaderSize]byte
+	binary.LittleEndian.PutUint64(b[0:], uint64(h.deadline))
+	binary.LittleEndian.PutUint64(b[8:], uint64(h.softDeadline))
+	binary.LittleEndian.PutUint64(b[16:], h.hash)
+	binary.LittleEndian.PutUint32(b[24:], h.valueLen)
+	binary.LittleEndian.PutUint16(b[28:], h.keyLen)
+	b[30] = h.flags
+	binary.LittleEndian.PutUint64(b[32:], uint64(h.timeout))
+	binary.LittleEndian.PutUint64(b[40:], h.version)
+	sh.copyIn(pos, b[:])
+}
+
+// virtual turn a position of the index back into the virtual position of a live entry
+func (sh *arenaShard) virtual(p uint32) uint64 {
+	size := uint64(len(sh.buf))
+	pos := sh.begin - sh.begin%size + uint64(p)
+	if pos < sh.begin {
+		pos += size
+	}
+	return pos
+}
+
+// lookup return the virtual position and the header of the entry of key. The caller must hold the lock.
+func (sh *arenaShard) lookup(key string, hash uint64) (uint64, arenaHeader, bool) {
+	p, ok := sh.index[hash]
+	if !ok {
+		return 0, arenaHeader{}, false
+	}
+	pos := sh.virtual(p)
+	h := sh.readHeader(pos)
+	if int(h.keyLen) != len(key) {
+		return 0, arenaHeader{}, false
+	}
+	k := make([]byte, h.keyLen)
+	sh.copyOut(k, pos+arenaHeaderSize)
+	if string(k) != key {
+		return 0, arenaHeader{}, false
+	}
+	return pos, h, true
+}
+
+// value return a copy of the value of the entry at pos
+func (sh *arenaShard) value(pos uint64, h *arenaHeader) []byte {
+	data := make([]byte, h.valueLen)
+	sh.copyOut(data, pos+arenaHeaderSize+uint64(h.keyLen))
+	return data
+}
+
+// remove mark the entry at pos deleted and drop it from the index. The caller must hold the lock.
+func (sh *arenaShard) remove(pos uint64, h *arenaHeader) {
+	h.flags |= arenaDeleted
+	sh.copyIn(pos+30, []byte{h.flags})
+	delete(sh.index, h.hash)
+	atomic.AddInt64(&sh.length, -1)
+	atomic.AddInt64(&sh.used, -int64(h.size()))
+}
+
+// markDeleted record that a caller just deleted a key of sh, see shardedMapStore.markDeleted. The caller must hold
+// the lock.
+func (s *arenaStore) markDeleted(sh *arenaShard) {
+	sh.deleted = atomic.AddUint64(&s.versions, 1)
+}
+
+// restart push the deadline of the entry at pos its timeout past now. The caller must hold the lock.
+func (sh *arenaShard) restart(pos uint64, h *arenaHeader, now int64) {
+	if h.timeout == 0 {
+		return
+	}
+	h.deadline = now + h.timeout
+	sh.writeHeader(pos, h)
+}
+
+// evictOldest reclaim the bytes of the oldest entry of the ring, evicting it if it is still live
+func (sh *arenaShard) evictOldest() {
+	h := sh.readHeader(sh.begin)
+	if h.flags&arenaDeleted == 0 && sh.index[h.hash] == uint32(sh.begin%uint64(len(sh.buf))) {
+		sh.remove(sh.begin, &h)
+	}
+	sh.begin += h.size()
+}
+
+// append write a new entry at the end of the ring, evicting the oldest entries to make room for it.
+// The caller must hold the lock and check that the entry fits in the buffer.
+func (sh *arenaShard) append(h *arenaHeader, key string, value []byte) {
+	size := h.size()
+	for sh.end+size-sh.begin > uint64(len(sh.buf)) {
+		sh.evictOldest()
+	}
+	for sh.capacity > 0 && atomic.LoadInt64(&sh.length) >= int64(sh.capacity) {
+		sh.evictOldest()
+	}
+	pos := sh.end
+	sh.writeHeader(pos, h)
+	sh.copyIn(pos+arenaHeaderSize, []byte(key))
+	sh.copyIn(pos+arenaHeaderSize+uint64(h.keyLen), value)
+	sh.end += size
+
+	sh.index[h.hash] = uint32(pos % uint64(len(sh.buf)))
+	atomic.AddInt64(&sh.length, 1)
+	atomic.AddInt64(&sh.used, int64(size))
+}
+
+// encode return the bytes stored for value, and the flags telling how to decode them
+func (s *arenaStore) encode(value interface{}) ([]byte, uint8, error) {
+	switch v := value.(type) {
+	case []byte:
+		return v, arenaRaw, nil
+	case *listValue:
+		data, err := encodeList(v, s.encode)
+		return data, arenaList, err
+	case *hashValue:
+		data, err := encodeHash(v, s.encode)
+		return data, arenaHash, err
+	}
+	data, err := s.codec.Marshal(value)
+	if err != nil {
+		return nil, 0, &codecError{err}
+	}
+	return data, 0, nil
+}
+
+func (s *arenaStore) decode(data []byte, flags uint8) (interface{}, error) {
+	if flags&arenaCollection != 0 {
+		return nil, ErrWrongType
+	}
+	if flags&arenaRaw != 0 {
+		return data, nil
+	}
+	var value interface{}
+	if err := s.codec.Unmarshal(data, &value); err != nil {
+		return nil, &codecError{err}
+	}
+	return value, nil
+}
+
+func (s *arenaStore) Set(key string, value interface{}) error {
+	return s.SetWithTimeoutCtx(context.Background(), key, value, s.defaultTimeout)
+}
+
+func (s *arenaStore) SetCtx(ctx context.Context, key string, value interface{}) error {
+	return s.SetWithTimeoutCtx(ctx, key, value, s.defaultTimeout)
+}
+
+func (s *arenaStore) SetWithTimeout(key string, value interface{}, timeout time.Duration) error {
+	return s.SetWithTimeoutCtx(context.Background(), key, value, timeout)
+}
+
+func (s *arenaStore) SetWithTimeoutCtx(ctx context.Context, key string, value interface{}, timeout time.Duration) error {
+	return s.set(ctx, key, value, s.staleAfter, timeout, s.slidingExpiration)
+}
+
+func (s *arenaStore) SetWithSlidingTimeout(key string, value interface{}, timeout time.Duration) error {
+	return s.set(context.Background(), key, value, s.staleAfter, timeout, true)
+}
+
+func (s *arenaStore) SetWithSoftTimeout(key string, value interface{}, softTimeout, timeout time.Duration) error {
+	return s.set(context.Background(), key, value, softTimeout, timeout, s.slidingExpiration)
+}
+
+func (s *arenaStore) set(ctx context.Context, key string, value interface{}, softTimeout, timeout time.Duration, sliding bool) error {
+	_, err := s.setIf(ctx, key, value, softTimeout, timeout, sliding, nil)
+	return err
+}
+
+// setIf is set writing only if cond returns true. cond is called under the shard lock with the position and the
+// header of the live entry of key, or a nil header if there is none.
+func (s *arenaStore) setIf(ctx context.Context, key string, value interface{}, softTimeout, timeout time.Duration, sliding bool, cond func(sh *arenaShard, pos uint64, h *arenaHeader) bool) (bool, error) {
+	w, err := s.prepare(key, value, softTimeout, timeout, sliding)
+	if err != nil {
+		return false, keyError("set", key, err)
+	}
+	sh := s.selectShard(w.h.hash)
+	if err := lockCtx(ctx, &sh.mu); err != nil {
+		return false, keyError("set", key, err)
+	}
+	defer sh.mu.Unlock()
+	if cond != nil {
+		pos, old, ok := sh.lookup(key, w.h.hash)
+		live := &old
+		if !ok || w.now > old.deadline {
+			live = nil
+		}
+		if !cond(sh, pos, live) {
+			return false, nil
+		}
+	}
+	s.write(sh, &w)
+	return true, nil
+}
+
+// arenaWrite is an entry ready to be appended by write
+type arenaWrite struct {
+	key  string
+	data []byte
+	h    arenaHeader
+	now  int64
+}
+
+// prepare encode value and build the header of its entry, without locking anything
+func (s *arenaStore) prepare(key string, value interface{}, softTimeout, timeout time.Duration, sliding bool) (arenaWrite, error) {
+	if len(key) > arenaMaxKeyLen {
+		return arenaWrite{}, errKeyTooLong
+	}
+	data, flags, err := s.encode(value)
+	if err != nil {
+		return arenaWrite{}, err
+	}
+
+	now := s.clock.Now()
+	deadline := now.Add(timeout).UnixNano()
+	if timeout == 0 {
+		deadline = maxInt64
+	}
+	softDeadline := now.Add(softTimeout).UnixNano()
+	if softTimeout == 0 || softDeadline > deadline {
+		softDeadline = maxInt64
+	}
+
+	w := arenaWrite{
+		key:  key,
+		data: data,
+		h: arenaHeader{
+			deadline:     deadline,
+			softDeadline: softDeadline,
+			hash:         s.hash(key),
+			valueLen:     uint32(len(data)),
+			keyLen:       uint16(len(key)),
+			flags:        flags,
+			timeout:      int64(timeout),
+		},
+		now: now.UnixNano(),
+	}
+	if sliding && timeout != 0 {
+		w.h.flags |= arenaSliding
+	}
+	// the slabs all have the same size
+	if w.h.size() > uint64(len(s.shards[0].buf)) {
+		return arenaWrite{}, ErrExceedMaxMemory
+	}
+	return w, nil
+}
+
+// write append a prepared entry to its shard, replacing the previous one. The caller must hold the lock of sh.
+func (s *arenaStore) write(sh *arenaShard, w *arenaWrite) {
+	if p, ok := sh.index[w.h.hash]; ok {
+		// the previous value of the key, or of a key with the same hash
+		pos := sh.virtual(p)
+		old := sh.readHeader(pos)
+		sh.remove(pos, &old)
+	}
+	w.h.version = atomic.AddUint64(&s.versions, 1)
+	sh.append(&w.h, w.key, w.data)
+}
+
+func (s *arenaStore) SetNX(ctx context.Context, key string, value interface{}, timeout time.Duration) (bool, error) {
+	return s.setIf(ctx, key, value, s.staleAfter, timeout, s.slidingExpiration, func(sh *arenaShard, pos uint64, h *arenaHeader) bool {
+		return h == nil
+	})
+}
+
+func (s *arenaStore) SetXX(ctx context.Context, key string, value interface{}, timeout time.Duration) (bool, error) {
+	return s.setIf(ctx, key, value, s.staleAfter, timeout, s.slidingExpiration, func(sh *arenaShard, pos uint64, h *arenaHeader) bool {
+		return h != nil
+	})
+}
+
+func (s *arenaStore) GetSet(ctx context.Context, key string, value interface{}, timeout time.Duration) (old interface{}, existed bool, err error) {
+	var data []byte
+	var flags uint8
+	var wrongType bool
+	_, err = s.setIf(ctx, key, value, s.staleAfter, timeout, s.slidingExpiration, func(sh *arenaShard, pos uint64, h *arenaHeader) bool {
+		if h != nil {
+			if wrongType = h.flags&arenaCollection != 0; wrongType {
+				return false
+			}
+			data, flags, existed = sh.value(pos, h), h.flags, true
+		}
+		return true
+	})
+	if wrongType {
+		return nil, false, keyError("get set", key, ErrWrongType)
+	}
+	if err != nil || !existed {
+		return nil, false, err
+	}
+	if old, err = s.decode(data, flags); err != nil {
+		return nil, false, keyError("get set", key, err)
+	}
+	return old, true, nil
+}
+
+func (s *arenaStore) GetWithVersion(ctx context.Context, key string) (value interface{}, version uint64, err error) {
+	hash := s.hash(key)
+	sh := s.selectShard(hash)
+	if err := lockCtx(ctx, &sh.mu); err != nil {
+		return nil, 0, keyError("get", key, err)
+	}
+	pos, h, ok := sh.lookup(key, hash)
+	if !ok {
+		sh.mu.Unlock()
+		return nil, 0, keyError("get", key, ErrKeyNotFound)
+	}
+	now := s.now()
+	if now > h.deadline {
+		sh.remove(pos, &h)
+		sh.mu.Unlock()
+		return nil, 0, keyError("get", key, ErrKeyNotFound)
+	}
+	if h.flags&arenaSliding != 0 {
+		sh.restart(pos, &h, now)
+	}
+	data := sh.value(pos, &h)
+	sh.mu.Unlock()
+
+	if value, err = s.decode(data, h.flags); err != nil {
+		return nil, 0, keyError("get", key, err)
+	}
+	return value, h.version, nil
+}
+
+func (s *arenaStore) Version(ctx context.Context, key string) (uint64, error) {
+	hash := s.hash(key)
+	sh := s.selectShard(hash)
+	if err := lockCtx(ctx, &sh.mu); err != nil {
+		return 0, keyError("version", key, err)
+	}
+	defer sh.mu.Unlock()
+	_, h, ok := sh.lookup(key, hash)
+	if !ok || s.now() > h.deadline {
+		return 0, nil
+	}
+	return h.version, nil
+}
+
+func (s *arenaStore) CompareAndSwap(ctx context.Context, key string, old, value interface{}, timeout time.Duration) (bool, error) {
+	var err error
+	swapped, setErr := s.setIf(ctx, key, value, s.staleAfter, timeout, s.slidingExpiration, func(sh *arenaShard, pos uint64, h *arenaHeader) bool {
+		if h == nil {
+			return false
+		}
+		var current interface{}
+		if current, err = s.decode(sh.value(pos, h), h.flags); err != nil {
+			return false
+		}
+		return reflect.DeepEqual(current, old)
+	})
+	if err != nil {
+		return false, keyError("compare and swap", key, err)
+	}
+	return swapped, setErr
+}
+
+func (s *arenaStore) CompareAndSwapVersion(ctx context.Context, key string, version uint64, value interface{}, timeout time.Duration) (bool, error) {
+	return s.setIf(ctx, key, value, s.staleAfter, timeout, s.slidingExpiration, func(sh *arenaShard, pos uint64, h *arenaHeader) bool {
+		return h != nil && h.version == version
+	})
+}
+
+func (s *arenaStore) Get(key string) (interface{}, error) {
+	value, _, err := s.get(context.Background(), key, s.refreshLoader)
+	return value, err
+}
+
+func (s *arenaStore) GetCtx(ctx context.Context, key string) (interface{}, error) {
+	value, _, err := s.get(ctx, key, s.refreshLoader)
+	return value, err
+}
+
+func (s *arenaStore) GetWithStale(ctx context.Context, key string) (value interface{}, stale bool, err error) {
+	return s.get(ctx, key, s.refreshLoader)
+}
+
+func (s *arenaStore) GetInto(ctx context.Context, key string, dst interface{}) error {
+	data, flags, _, err := s.getData(ctx, key, s.refreshLoader)
+	if err != nil {
+		return err
+	}
+	if flags&arenaCollection != 0 {
+		err = ErrWrongType
+	} else if flags&arenaRaw != 0 {
+		err = assignDecoded(dst, data)
+	} else if err = s.codec.Unmarshal(data, dst); err != nil {
+		err = &codecError{err}
+	}
+	if err != nil {
+		return keyError("get", key, err)
+	}
+	return nil
+}
+
+func (s *arenaStore) get(ctx context.Context, key string, loader Loader) (value interface{}, stale bool, err error) {
+	data, flags, stale, err := s.getData(ctx, key, loader)
+	if err != nil {
+		return nil, false, err
+	}
+	value, err = s.decode(data, flags)
+	if err != nil {
+		return nil, false, keyError("get", key, err)
+	}
+	return value, stale, nil
+}
+
+// getData return a copy of the stored bytes of key
+func (s *arenaStore) getData(ctx context.Context, key string, loader Loader) (data []byte, flags uint8, stale bool, err error) {
+	hash := s.hash(key)
+	sh := s.selectShard(hash)
+	if err := lockCtx(ctx, &sh.mu); err != nil {
+		return nil, 0, false, keyError("get", key, err)
+	}
+	defer sh.mu.Unlock()
+	data, flags, stale, ok := s.read(sh, key, hash, s.now(), loader)
+	if !ok {
+		return nil, 0, false, keyError("get", key, ErrKeyNotFound)
+	}
+	return data, flags, stale, nil
+}
+
+// read return a copy of the stored bytes of the live entry of key. The caller must hold the lock of sh.
+func (s *arenaStore) read(sh *arenaShard, key string, hash uint64, now int64, loader Loader) (data []byte, flags uint8, stale bool, ok bool) {
+	pos, h, ok := sh.lookup(key, hash)
+	if !ok {
+		return nil, 0, false, false
+	}
+	if now > h.deadline {
+		sh.remove(pos, &h)
+		return nil, 0, false, false
+	}
+	if h.flags&arenaSliding != 0 {
+		sh.restart(pos, &h, now)
+	}
+
+	stale = now > h.softDeadline
+	if stale {
+		atomic.AddInt64(&s.stats.StaleHits, 1)
+	}
+	refreshAhead := s.refreshAhead != 0 && h.deadline != maxInt64 && now > h.deadline-int64(s.refreshAhead)
+	if (stale || refreshAhead) && loader != nil {
+		s.loads.refresh(key, loader, h.version, atomic.LoadUint64(&s.versions))
+	}
+	return sh.value(pos, &h), h.flags, stale, true
+}
+
+func (s *arenaStore) shardIndex(key string) int {
+	return int(s.hash(key) & s.shardMask)
+}
+
+func (s *arenaStore) MGet(ctx context.Context, keys []string) (map[string]interface{}, error) {
+	type raw struct {
+		data  []byte
+		flags uint8
+		found bool
+	}
+	raws := make([]raw, len(keys))
+	for _, b := range groupByShard(keys, s.shardIndex) {
+		sh := &s.shards[b.shard]
+		if err := lockCtx(ctx, &sh.mu); err != nil {
+			return nil, keyError("mget", keys[b.items[0]], err)
+		}
+		now := s.now()
+		for _, i := range b.items {
+			r := &raws[i]
+			r.data, r.flags, _, r.found = s.read(sh, keys[i], s.hash(keys[i]), now, s.refreshLoader)
+		}
+		sh.mu.Unlock()
+	}
+
+	values := make(map[string]interface{}, len(keys))
+	for i, key := range keys {
+		if !raws[i].found {
+			continue
+		}
+		value, err := s.decode(raws[i].data, raws[i].flags)
+		if err != nil {
+			return nil, keyError("mget", key, err)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+func (s *arenaStore) MSet(ctx context.Context, values map[string]interface{}, timeout time.Duration) error {
+	return s.mset(ctx, values, timeout, false)
+}
+
+func (s *arenaStore) MSetAtomic(ctx context.Context, values map[string]interface{}, timeout time.Duration) error {
+	return s.mset(ctx, values, timeout, true)
+}
+
+func (s *arenaStore) mset(ctx context.Context, values map[string]interface{}, timeout time.Duration, all bool) error {
+	keys := make([]string, 0, len(values))
+	writes := make([]arenaWrite, 0, len(values))
+	for key, value := range values {
+		w, err := s.prepare(key, value, s.staleAfter, timeout, s.slidingExpiration)
+		if err != nil {
+			return keyError("mset", key, err)
+		}
+		keys = append(keys, key)
+		writes = append(writes, w)
+	}
+	batches := groupByShard(keys, s.shardIndex)
+
+	if all {
+		for n, b := range batches {
+			if err := lockCtx(ctx, &s.shards[b.shard].mu); err != nil {
+				s.unlockBatches(batches[:n])
+				return keyError("mset", keys[b.items[0]], err)
+			}
+		}
+		for _, b := range batches {
+			for _, i := range b.items {
+				s.write(&s.shards[b.shard], &writes[i])
+			}
+		}
+		s.unlockBatches(batches)
+		return nil
+	}
+	for _, b := range batches {
+		sh := &s.shards[b.shard]
+		if err := lockCtx(ctx, &sh.mu); err != nil {
+			return keyError("mset", keys[b.items[0]], err)
+		}
+		for _, i := range b.items {
+			s.write(sh, &writes[i])
+		}
+		sh.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *arenaStore) unlockBatches(batches []shardBatch) {
+	for _, b := range batches {
+		s.shards[b.shard].mu.Unlock()
+	}
+}
+
+func (s *arenaStore) MDelete(ctx context.Context, keys []string) (int, error) {
+	deleted := 0
+	for _, b := range groupByShard(keys, s.shardIndex) {
+		sh := &s.shards[b.shard]
+		if err := lockCtx(ctx, &sh.mu); err != nil {
+			return deleted, keyError("mdelete", keys[b.items[0]], err)
+		}
+		now := s.now()
+		for _, i := range b.items {
+			if pos, h, ok := sh.lookup(keys[i], s.hash(keys[i])); ok {
+				if now <= h.deadline {
+					deleted++
+				}
+				sh.remove(pos, &h)
+			}
+			s.markDeleted(sh)
+		}
+		sh.mu.Unlock()
+	}
+	return deleted, nil
+}
+
+func (s *arenaStore) Txn(ctx context.Context, keys []string, fn func(tx Tx) error) error {
+	batches := groupByShard(keys, s.shardIndex)
+	for n, b := range batches {
+		if err := lockCtx(ctx, &s.shards[b.shard].mu); err != nil {
+			s.unlockBatches(batches[:n])
+			return keyError("txn", keys[b.items[0]], err)
+		}
+	}
+	defer s.unlockBatches(batches)
+
+	now := s.now()
+	tx := newTxn(keys, func(key string) (uint64, bool, func() (interface{}, error)) {
+		hash := s.hash(key)
+		sh := s.selectShard(hash)
+		data, flags, _, ok := s.read(sh, key, hash, now, nil)
+		if !ok {
+			return 0, false, nil
+		}
+		_, h, _ := sh.lookup(key, hash)
+		return h.version, true, func() (interface{}, error) {
+			return s.decode(data, flags)
+		}
+	})
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	// prepare every write before applying any
+	writes := make([]arenaWrite, len(tx.order))
+	for i, key := range tx.order {
+		w := tx.writes[key]
+		if w.deleted {
+			continue
+		}
+		timeout := w.timeout
+		if w.keepTTL || w.defaultTimeout {
+			timeout = s.defaultTimeout
+		}
+		aw, err := s.prepare(key, w.value, s.staleAfter, timeout, s.slidingExpiration)
+		if err != nil {
+			return keyError("txn", key, err)
+		}
+		if _, old, ok := s.selectShard(aw.h.hash).lookup(key, aw.h.hash); w.keepTTL && ok && now <= old.deadline {
+			aw.h.deadline, aw.h.softDeadline, aw.h.timeout = old.deadline, old.softDeadline, old.timeout
+			aw.h.flags = aw.h.flags&^arenaSliding | old.flags&arenaSliding
+			if old.flags&arenaSliding != 0 {
+				aw.h.deadline = now + old.timeout
+			}
+		}
+		writes[i] = aw
+	}
+	for i, key := range tx.order {
+		hash := s.hash(key)
+		sh := s.selectShard(hash)
+		if !tx.writes[key].deleted {
+			s.write(sh, &writes[i])
+		} else {
+			if pos, h, ok := sh.lookup(key, hash); ok {
+				sh.remove(pos, &h)
+			}
+			s.markDeleted(sh)
+		}
+	}
+	return nil
+}
+
+// LPush insert values at the head of the list at key, see shardedMapStore.LPush. The list is encoded as a whole,
+// so each operation decodes and copies all of it.
+func (s *arenaStore) LPush(ctx context.Context, key string, values ...interface{}) (int, error) {
+	return s.push(ctx, "lpush", key, values, (*listValue).pushFront)
+}
+
+func (s *arenaStore) RPush(ctx context.Context, key string, values ...interface{}) (int, error) {
+	return s.push(ctx, "rpush", key, values, (*listValue).pushBack)
+}
+
+func (s *arenaStore) push(ctx context.Context, op, key string, values []interface{}, push func(l *listValue, value interface{})) (int, error) {
+	var n int
+	err := s.updateCollection(ctx, op, key, listKind, true, func(c collection, now int64) error {
+		l := c.(*listValue)
+		for _, value := range values {
+			push(l, value)
+		}
+		n = l.len()
+		return nil
+	})
+	return n, err
+}
+
+func (s *arenaStore) LPop(ctx context.Context, key string) (interface{}, error) {
+	return s.pop(ctx, "lpop", key, (*listValue).popFront)
+}
+
+func (s *arenaStore) RPop(ctx context.Context, key string) (interface{}, error) {
+	return s.pop(ctx, "rpop", key, (*listValue).popBack)
+}
+
+func (s *arenaStore) pop(ctx context.Context, op, key string, pop func(l *listValue) interface{}) (interface{}, error) {
+	var value interface{}
+	err := s.updateCollection(ctx, op, key, listKind, false, func(c collection, now int64) error {
+		value = pop(c.(*listValue))
+		return nil
+	})
+	return value, err
+}
+
+func (s *arenaStore) LRange(ctx context.Context, key string, start, stop int) ([]interface{}, error) {
+	data, ok, err := s.collectionData(ctx, "lrange", key, arenaList)
+	if err != nil || !ok {
+		return []interface{}{}, err
+	}
+	l, err := decodeList(data, s.decode)
+	if err != nil {
+		return nil, keyError("lrange", key, err)
+	}
+	return l.slice(start, stop), nil
+}
+
+func (s *arenaStore) LLen(ctx context.Context, key string) (int, error) {
+	data, ok, err := s.collectionData(ctx, "llen", key, arenaList)
+	if err != nil || !ok {
+		return 0, err
+	}
+	n, err := listLen(data)
+	if err != nil {
+		return 0, keyError("llen", key, err)
+	}
+	return n, nil
+}
+
+func (s *arenaStore) LIndex(ctx context.Context, key string, index int) (interface{}, error) {
+	data, ok, err := s.collectionData(ctx, "lindex", key, arenaList)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, keyError("lindex", key, ErrKeyNotFound)
+	}
+	l, err := decodeList(data, s.decode)
+	if err != nil {
+		return nil, keyError("lindex", key, err)
+	}
+	i, found := listIndex(index, l.len())
+	if !found {
+		return nil, keyError("lindex", key, ErrKeyNotFound)
+	}
+	return l.at(i), nil
+}
+
+func (s *arenaStore) LTrim(ctx context.Context, key string, start, stop int) error {
+	err := s.updateCollection(ctx, "ltrim", key, listKind, false, func(c collection, now int64) error {
+		c.(*listValue).trim(start, stop)
+		return nil
+	})
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil
+	}
+	return err
+}
+
+// HSet set the fields of the hash at key, see shardedMapStore.HSet. The hash is encoded as a whole, so each
+// operation decodes and copies all of it.
+func (s *arenaStore) HSet(ctx context.Context, key string, values map[string]interface{}) (int, error) {
+	return s.hset(ctx, "hset", key, values, 0)
+}
+
+func (s *arenaStore) HSetWithTimeout(ctx context.Context, key string, values map[string]interface{}, timeout time.Duration) (int, error) {
+	return s.hset(ctx, "hset", key, values, timeout)
+}
+
+func (s *arenaStore) hset(ctx context.Context, op, key string, values map[string]interface{}, timeout time.Duration) (int, error) {
+	var created int
+	err := s.updateHash(ctx, op, key, true, func(h *hashValue, now int64) error {
+		deadline := fieldDeadline(now, timeout)
+		for field, value := range values {
+			if _, live := h.get(field, now); !live {
+				created++
+			}
+			h.set(field, value, deadline)
+		}
+		return nil
+	})
+	return created, err
+}
+
+func (s *arenaStore) HGet(ctx context.Context, key, field string) (interface{}, error) {
+	h, now, err := s.hashAt(ctx, "hget", key)
+	if err != nil {
+		return nil, err
+	}
+	value, found := h.get(field, now)
+	if !found {
+		return nil, keyError("hget", key, ErrKeyNotFound)
+	}
+	return value, nil
+}
+
+func (s *arenaStore) HDel(ctx context.Context, key string, fields ...string) (int, error) {
+	var deleted int
+	err := s.updateHash(ctx, "hdel", key, false, func(h *hashValue, now int64) error {
+		for _, field := range fields {
+			if _, live := h.get(field, now); live {
+				deleted++
+			}
+			h.del(field)
+		}
+		return nil
+	})
+	if errors.Is(err, ErrKeyNotFound) {
+		return 0, nil
+	}
+	return deleted, err
+}
+
+func (s *arenaStore) HGetAll(ctx context.Context, key string) (map[string]interface{}, error) {
+	h, now, err := s.hashAt(ctx, "hgetall", key)
+	if err != nil {
+		return nil, err
+	}
+	return h.all(now), nil
+}
+
+func (s *arenaStore) HIncrBy(ctx context.Context, key, field string, delta int64) (int64, error) {
+	var n int64
+	err := s.updateHash(ctx, "hincr by", key, true, func(h *hashValue, now int64) error {
+		current, ok := h.get(field, now)
+		if !ok {
+			n = delta
+			h.set(field, int(delta), maxInt64)
+			return nil
+		}
+		value, sum, err := incrementBy(current, delta)
+		if err != nil {
+			return err
+		}
+		n = sum
+		h.set(field, value, h.fields[field].deadline)
+		return nil
+	})
+	return n, err
+}
+
+func (s *arenaStore) HExists(ctx context.Context, key, field string) (bool, error) {
+	h, now, err := s.hashAt(ctx, "hexists", key)
+	if err != nil {
+		return false, err
+	}
+	_, found := h.get(field, now)
+	return found, nil
+}
+
+func (s *arenaStore) HLen(ctx context.Context, key string) (int, error) {
+	h, now, err := s.hashAt(ctx, "hlen", key)
+	if err != nil {
+		return 0, err
+	}
+	return h.count(now), nil
+}
+
+// hashAt return the decoded hash at key, an empty one if the key is missing
+func (s *arenaStore) hashAt(ctx context.Context, op, key string) (*hashValue, int64, error) {
+	data, ok, err := s.collectionData(ctx, op, key, arenaHash)
+	now := s.now()
+	if err != nil || !ok {
+		return newHashValue(), now, err
+	}
+	h, err := decodeHash(data, s.decode)
+	if err != nil {
+		return nil, 0, keyError(op, key, err)
+	}
+	if h.expired(now) {
+		// the last field expired, updating the hash deletes the key
+		_ = s.updateHash(ctx, op, key, false, func(h *hashValue, now int64) error { return nil })
+	}
+	return h, now, nil
+}
+
+func (s *arenaStore) updateHash(ctx context.Context, op, key string, create bool, fn func(h *hashValue, now int64) error) error {
+	return s.updateCollection(ctx, op, key, hashKind, create, func(c collection, now int64) error {
+		h := c.(*hashValue)
+		if err := fn(h, now); err != nil {
+			return err
+		}
+		h.purge(now)
+		return nil
+	})
+}
+
+// collectionData return a copy of the encoded collection at key, false if the key is missing. flag is the flag of
+// the kind of collection expected.
+func (s *arenaStore) collectionData(ctx context.Context, op, key string, flag uint8) ([]byte, bool, error) {
+	hash := s.hash(key)
+	sh := s.selectShard(hash)
+	if err := lockCtx(ctx, &sh.mu); err != nil {
+		return nil, false, keyError(op, key, err)
+	}
+	data, flags, _, ok := s.read(sh, key, hash, s.now(), nil)
+	sh.mu.Unlock()
+	if ok && flags&flag == 0 {
+		return nil, false, keyError(op, key, ErrWrongType)
+	}
+	return data, ok, nil
+}
+
+func (s *arenaStore) decodeCollection(data []byte, flags uint8) (collection, error) {
+	if flags&arenaHash != 0 {
+		return decodeHash(data, s.decode)
+	}
+	return decodeList(data, s.decode)
+}
+
+// updateCollection replace the collection at key with its copy modified by fn, see
+// shardedMapStore.updateCollection
+func (s *arenaStore) updateCollection(ctx context.Context, op, key string, kind collectionKind, create bool, fn func(c collection, now int64) error) error {
+	var flag uint8 = arenaList
+	if kind == hashKind {
+		flag = arenaHash
+	}
+	decode := func(data []byte, flags uint8) (interface{}, error) {
+		if flags&flag == 0 {
+			return nil, ErrWrongType
+		}
+		return s.decodeCollection(data, flags)
+	}
+	now := s.now()
+	return s.modifyWith(ctx, op, key, decode, func(current interface{}, ok bool) (interface{}, error) {
+		if !ok && !create {
+			return nil, ErrKeyNotFound
+		}
+		c := newCollection(kind)
+		if ok {
+			c = current.(collection)
+		}
+		if err := fn(c, now); err != nil {
+			return nil, err
+		}
+		if c.len() == 0 {
+			return nil, nil
+		}
+		return c, nil
+	})
+}
+
+// GetOrLoad is the read-through Get, see shardedMapStore.GetOrLoad. There is no backend, so loader is required.
+func (s *arenaStore) GetOrLoad(ctx context.Context, key string, loader Loader) (interface{}, error) {
+	since := atomic.LoadUint64(&s.versions)
+	value, _, err := s.get(ctx, key, loader)
+	if !errors.Is(err, ErrKeyNotFound) || loader == nil {
+		return value, err
+	}
+	return s.loads.load(ctx, key, loader, since)
+}
+
+func (s *arenaStore) loaderTTL() time.Duration {
+	if s.loadTTL != 0 {
+		return s.loadTTL
+	}
+	return s.defaultTimeout
+}
+
+func (s *arenaStore) GetStats() Stats {
+	return s.stats.snapshot()
+}
+
+func (s *arenaStore) Delete(key string) error {
+	return s.DeleteCtx(context.Background(), key)
+}
+
+func (s *arenaStore) DeleteCtx(ctx context.Context, key string) error {
+	hash := s.hash(key)
+	sh := s.selectShard(hash)
+	if err := lockCtx(ctx, &sh.mu); err != nil {
+		return keyError("delete", key, err)
+	}
+	defer sh.mu.Unlock()
+	if pos, h, ok := sh.lookup(key, hash); ok {
+		sh.remove(pos, &h)
+	}
+	s.markDeleted(sh)
+	return nil
+}
+
+func (s *arenaStore) Increase(key string) error {
+	return s.IncreaseCtx(context.Background(), key)
+}
+
+func (s *arenaStore) IncreaseCtx(ctx context.Context, key string) error {
+	_, err := s.incrBy(ctx, "increase", key, 1)
+	return err
+}
+
+func (s *arenaStore) IncrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	return s.incrBy(ctx, "incr by", key, delta)
+}
+
+func (s *arenaStore) DecrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	if delta == math.MinInt64 {
+		return 0, keyError("decr by", key, ErrOverflow)
+	}
+	return s.incrBy(ctx, "decr by", key, -delta)
+}
+
+func (s *arenaStore) incrBy(ctx context.Context, op, key string, delta int64) (int64, error) {
+	var n int64
+	err := s.modify(ctx, op, key, func(current interface{}, ok bool) (value interface{}, err error) {
+		if !ok {
+			n = delta
+			return int(delta), nil
+		}
+		value, n, err = incrementBy(current, delta)
+		return value, err
+	})
+	return n, err
+}
+
+func (s *arenaStore) IncrByFloat(ctx context.Context, key string, delta float64) (float64, error) {
+	var f float64
+	err := s.modify(ctx, "incr by float", key, func(current interface{}, ok bool) (value interface{}, err error) {
+		if !ok {
+			current = float64(0)
+		}
+		value, f, err = incrementByFloat(current, delta)
+		return value, err
+	})
+	return f, err
+}
+
+// modify replace the value of key with what apply returns for the current one, under the shard lock. The entry is
+// rewritten at the end of the ring, keeping its deadlines. A missing key gets the default timeout.
+func (s *arenaStore) modify(ctx context.Context, op, key string, apply func(current interface{}, ok bool) (interface{}, error)) error {
+	return s.modifyWith(ctx, op, key, s.decode, apply)
+}
+
+// modifyWith is modify decoding the current value with decode. The key is deleted if apply returns nil.
+func (s *arenaStore) modifyWith(ctx context.Context, op, key string, decode func(data []byte, flags uint8) (interface{}, error), apply func(current interface{}, ok bool) (interface{}, error)) error {
+	hash := s.hash(key)
+	sh := s.selectShard(hash)
+	if err := lockCtx(ctx, &sh.mu); err != nil {
+		return keyError(op, key, err)
+	}
+	defer sh.mu.Unlock()
+
+	now := s.now()
+	h := arenaHeader{deadline: maxInt64, softDeadline: maxInt64, hash: hash, keyLen: uint16(len(key))}
+	if s.defaultTimeout != 0 {
+		h.deadline, h.timeout = now+int64(s.defaultTimeout), int64(s.defaultTimeout)
+		if s.slidingExpiration {
+			h.flags = arenaSliding
+		}
+	}
+	var current interface{}
+	pos, old, ok := sh.lookup(key, hash)
+	if ok && now > old.deadline {
+		sh.remove(pos, &old)
+		ok = false
+	}
+	if ok {
+		var err error
+		if current, err = decode(sh.value(pos, &old), old.flags); err != nil {
+			return keyError(op, key, err)
+		}
+		h.deadline, h.softDeadline, h.timeout = old.deadline, old.softDeadline, old.timeout
+		h.flags = old.flags & arenaSliding
+		if h.flags != 0 {
+			h.deadline = now + old.timeout
+		}
+	}
+	value, err := apply(current, ok)
+	if err != nil {
+		return keyError(op, key, err)
+	}
+	if value == nil {
+		if ok {
+			sh.remove(pos, &old)
+		}
+		s.markDeleted(sh)
+		return nil
+	}
+
+	data, flags, err := s.encode(value)
+	if err != nil {
+		return keyError(op, key, err)
+	}
+	h.valueLen, h.flags = uint32(len(data)), h.flags|flags
+	h.version = atomic.AddUint64(&s.versions, 1)
+	if h.size() > uint64(len(sh.buf)) {
+		return keyError(op, key, ErrExceedMaxMemory)
+	}
+	if ok {
+		sh.remove(pos, &old)
+	} else if p, collision := sh.index[hash]; collision {
+		other := sh.readHeader(sh.virtual(p))
+		sh.remove(sh.virtual(p), &other)
+	}
+	sh.append(&h, key, data)
+	return nil
+}
+
+func (s *arenaStore) GetTTL(key string) (int64, error) {
+	return s.GetTTLCtx(context.Background(), key)
+}
+
+func (s *arenaStore) GetTTLCtx(ctx context.Context, key string) (int64, error) {
+	hash := s.hash(key)
+	sh := s.selectShard(hash)
+	if err := lockCtx(ctx, &sh.mu); err != nil {
+		return 0, keyError("get ttl", key, err)
+	}
+	defer sh.mu.Unlock()
+	pos, h, ok := sh.lookup(key, hash)
+	if !ok {
+		return 0, keyError("get ttl", key, ErrKeyNotFound)
+	}
+	if s.now() > h.deadline {
+		sh.remove(pos, &h)
+		return 0, keyError("get ttl", key, ErrKeyNotFound)
+	}
+	return h.deadline, nil
+}
+
+func (s *arenaStore) GetRemainingTTL(ctx context.Context, key string) (time.Duration, error) {
+	var ttl time.Duration
+	err := s.updateDeadline(ctx, "get ttl", key, func(h *arenaHeader, now int64) bool {
+		ttl = NoExpiry
+		if h.deadline != maxInt64 {
+			ttl = time.Duration(h.deadline - now)
+		}
+		return true
+	})
+	return ttl, err
+}
+
+func (s *arenaStore) Expire(ctx context.Context, key string, timeout time.Duration) error {
+	return s.updateDeadline(ctx, "expire", key, func(h *arenaHeader, now int64) bool {
+		if timeout <= 0 {
+			return false
+		}
+		h.timeout = int64(timeout)
+		h.deadline = now + h.timeout
+		return true
+	})
+}
+
+func (s *arenaStore) ExpireAt(ctx context.Context, key string, deadline time.Time) error {
+	return s.updateDeadline(ctx, "expire at", key, func(h *arenaHeader, now int64) bool {
+		h.deadline = deadline.UnixNano()
+		h.timeout = h.deadline - now
+		return h.timeout > 0
+	})
+}
+
+func (s *arenaStore) Persist(ctx context.Context, key string) (bool, error) {
+	persisted := false
+	err := s.updateDeadline(ctx, "persist", key, func(h *arenaHeader, now int64) bool {
+		persisted = h.deadline != maxInt64
+		h.deadline, h.timeout = maxInt64, 0
+		h.flags &^= arenaSliding
+		return true
+	})
+	return persisted, err
+}
+
+// Touch restart the timeout of key. The ring buffer evicts by age of write, so it doesn't protect key from eviction.
+func (s *arenaStore) Touch(ctx context.Context, key string) error {
+	return s.updateDeadline(ctx, "touch", key, func(h *arenaHeader, now int64) bool {
+		if h.timeout != 0 {
+			h.deadline = now + h.timeout
+		}
+		return true
+	})
+}
+
+// updateDeadline call update with the header of the live entry of key under the shard lock, and write it back.
+// The entry is deleted if update returns false.
+func (s *arenaStore) updateDeadline(ctx context.Context, op, key string, update func(h *arenaHeader, now int64) bool) error {
+	hash := s.hash(key)
+	sh := s.selectShard(hash)
+	if err := lockCtx(ctx, &sh.mu); err != nil {
+		return keyError(op, key, err)
+	}
+	defer sh.mu.Unlock()
+	pos, h, ok := sh.lookup(key, hash)
+	if !ok {
+		return keyError(op, key, ErrKeyNotFound)
+	}
+	now := s.now()
+	if now > h.deadline {
+		sh.remove(pos, &h)
+		return keyError(op, key, ErrKeyNotFound)
+	}
+	if !update(&h, now) {
+		sh.remove(pos, &h)
+		s.markDeleted(sh)
+		return nil
+	}
+	sh.writeHeader(pos, &h)
+	return nil
+}
+
+// GetMemoryUsage return the bytes of the slabs used by the live entries. The expired entries count until they are
+// accessed or reclaimed by the ring.
+func (s *arenaStore) GetMemoryUsage() int64 {
+	var total int64
+	for i := range s.shards {
+		total += atomic.LoadInt64(&s.shards[i].used)
+	}
+	return total
+}
+
+func (s *arenaStore) DumpAllJSON() (string, error) {
+	res := make(map[string]interface{})
+	_, isJSON := s.codec.(JSONCodec)
+	now := s.now()
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for _, p := range sh.index {
+			pos := sh.virtual(p)
+			h := sh.readHeader(pos)
+			if now > h.deadline {
+				continue
+			}
+			k := make([]byte, h.keyLen)
+			sh.copyOut(k, pos+arenaHeaderSize)
+			data := sh.value(pos, &h)
+			if h.flags&arenaCollection != 0 {
+				c, err := s.decodeCollection(data, h.flags)
+				if err != nil {
+					sh.mu.Unlock()
+					return "", fmt.Errorf("dump all json: %w", keyError("decode", string(k), err))
+				}
+				res[string(k)] = c.snapshot(now)
+				continue
+			}
+			if isJSON && h.flags&arenaRaw == 0 {
+				res[string(k)] = json.RawMessage(data)
+				continue
+			}
+			value, err := s.decode(data, h.flags)
+			if err != nil {
+				sh.mu.Unlock()
+				return "", fmt.Errorf("dump all json: %w", keyError("decode", string(k), err))
+			}
+			res[string(k)] = value
+		}
+		sh.mu.Unlock()
+	}
+
+	resBytes, err := json.Marshal(res)
+	if err != nil {
+		return "", fmt.Errorf("dump all json: %w", err)
+	}
+	return string(resBytes), nil
+}
+
+// Close release the slabs
+func (s *arenaStore) Close() error {
+	s.closeOnce.Do(func() {
+		s.shards = nil
+	})
+	return nil
+}
+
+func (s *arenaStore) now() int64 {
+	return s.clock.Now().UnixNano()
+}
+
+func (s *arenaStore) setDefaultTimeout(timeout time.Duration) {
+	s.defaultTimeout = timeout
+}
+
+func (s *arenaStore) setClock(clock Clock) {
+	// s method can only be called at init stage of cache
+	s.clock = clock
+}
+
+func (s *arenaStore) setMaxMemory(size int64) {
+	// s method can only be called at init stage of cache
+	s.maxMemory = size
+}
+
+func (s *arenaStore) setCapacity(cap int) {
+	// s method can only be called at init stage of cache
+	s.capacity = cap
+}
+
+func (s *arenaStore) setShardCount(n int) {
+	// s method can only be called at init stage of cache
+	s.shardCount = roundShardCount(n)
+}
+
+func (s *arenaStore) setHasher(hasher Hasher) {
+	// s method can only be called at init stage of cache
+	s.hash = hasher
+}
+
+func (s *arenaStore) setCodec(codec Codec) {
+	// s method can only be called at init stage of cache
+	s.codec = codec
+}
+
+func (s *arenaStore) setLoaderTTL(ttl time.Duration) {
+	// s method can only be called at init stage of cache
+	s.loadTTL = ttl
+}
+
+func (s *arenaStore) setNegativeTTL(ttl time.Duration) {
+	// s method can only be called at init stage of cache
+	s.negativeTTL = ttl
+}
+
+func (s *arenaStore) setSlidingExpiration(enabled bool) {
+	// s method can only be called at init stage of cache
+	s.slidingExpiration = enabled
+}
+
+func (s *arenaStore) setStaleAfter(d time.Duration) {
+	// s method can only be called at init stage of cache
+	s.staleAfter = d
+}
+
+func (s *arenaStore) setRefreshAhead(d time.Duration) {
+	// s method can only be called at init stage of cache
+	s.refreshAhead = d
+}
+
+func (s *arenaStore) setRefreshLoader(loader Loader) {
+	// s method can only be called at init stage of cache
+	s.refreshLoader = loader
+}
+
+// The values are copied in and out of the slabs anyway
+func (s *arenaStore) setCloneMode(mode CloneMode) {}
+func (s *arenaStore) setCloner(cloner Cloner)     {}
+
+// The ring buffer decides what is evicted, and the expired entries are reclaimed by it too
+func (s *arenaStore) setEvictionPolicy(policy EvictionPolicy) { arenaIgnored("eviction_policy") }
+func (s *arenaStore) setEvictionSamples(n int)                { arenaIgnored("eviction_samples") }
+func (s *arenaStore) setLRUMode(mode LRUMode)                 { arenaIgnored("lru_mode") }
+func (s *arenaStore) setLFULogFactor(factor int)              { arenaIgnored("lfu_log_factor") }
+func (s *arenaStore) setLFUDecayTime(d time.Duration)         { arenaIgnored("lfu_decay_time") }
+func (s *arenaStore) setExpiryInterval(interval time.Duration) {
+	if interval != 0 {
+		arenaIgnored("expiry_interval")
+	}
+}
+
+func (s *arenaStore) setCompression(compressor Compressor, threshold int) {
+	arenaIgnored("compression")
+}
+func (s *arenaStore) setBackend(backend Backend, mode WriteMode) { arenaIgnored("backend") }
+func (s *arenaStore) setWriteBehindFlush(interval time.Duration, maxRetries int) {
+	arenaIgnored("write_behind_flush")
+}
+
+func arenaIgnored(option string) {
+	log.Printf("arena_store_option_ignored | option=%v", option)
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func Test_ArenaStore_RingEviction(t *testing.T) {
+	// 1KB slabs
+	s := GetArenaStore(SetMaxMemory(fmt.Sprint(defaultShardCount * 1024)))
+	keys := sameShardKeys(64)
+	value := make([]byte, 100)
+	for _, key := range keys {
+		if err := s.Set(key, value); err != nil {
+			t.Errorf("set_error, err: %v", err)
+		}
+	}
+	// the oldest keys made room for the newest ones
+	if _, err := s.Get(keys[0]); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("oldest_key_should_be_evicted, err: %v", err)
+	}
+	for _, key := range keys[len(keys)-5:] {
+		if v, err := s.Get(key); err != nil || len(v.([]byte)) != 100 {
+			t.Errorf("newest_key_should_be_kept, key: %v, err: %v", key, err)
+		}
+	}
+	if usage := s.GetMemoryUsage(); usage > 1024 {
+		t.Errorf("memory_usage_should_fit_the_slab, got: %v", usage)
+	}
+
+	if err := s.Set("huge", make([]byte, 2048)); !errors.Is(err, ErrExceedMaxMemory) {
+		t.Errorf("huge_value_error, got: %v, want: %v", err, ErrExceedMaxMemory)
+	}
+}
+
+func Test_ArenaStore_Wraparound(t *testing.T) {
+	s := GetArenaStore(SetMaxMemory(fmt.Sprint(defaultShardCount * 1000)))
+	keys := sameShardKeys(8)
+	// entries of uneven sizes end up split across the end of the slab
+	for round := 0; round < 50; round++ {
+		for i, key := range keys {
+			value := fmt.Sprintf("%d-%d-%s", round, i, make([]byte, (round*7+i*13)%90))
+			if err := s.Set(key, value); err != nil {
+				t.Fatalf("set_error, err: %v", err)
+			}
+			if v, err := s.Get(key); err != nil || v != value {
+				t.Fatalf("get_incorrect, round: %v, key: %v, err: %v", round, key, err)
+			}
+		}
+	}
+}
+
+func Test_ArenaStore_Capacity(t *testing.T) {
+	s := GetArenaStore(SetCapacity(defaultShardCount))
+	keys := sameShardKeys(3)
+	for _, key := range keys {
+		_ = s.Set(key, 1)
+	}
+	if _, err := s.Get(keys[0]); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("oldest_key_should_be_evicted, err: %v", err)
+	}
+	if _, err := s.Get(keys[2]); err != nil {
+		t.Errorf("newest_key_should_be_kept, err: %v", err)
+	}
+}
+
+func Test_ArenaStore_Concurrent(t *testing.T) {
+	s := GetArenaStore(SetMaxMemory("64KB"))
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				key := fmt.Sprintf("k%d", (g*31+i)%200)
+				switch i % 3 {
+				case 0:
+					_ = s.Set(key, fmt.Sprintf("%s-%d", key, i))
+				case 1:
+					if v, err := s.Get(key); err == nil && v.(string)[:len(key)] != key {
+						t.Errorf("value_of_another_key, key: %v, got: %v", key, v)
+					}
+				default:
+					_ = s.Delete(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func Benchmark_ArenaStore_Set(b *testing.B) {
+	s := GetArenaStore(SetMaxMemory("256MB"))
+	value := make([]byte, 64)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = s.Set(fmt.Sprint(i%100000), value)
+	}
+}
@@ -0,0 +1,226 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Backend is the durable system of record a store can front. Load return an error wrapping ErrKeyNotFound
+// when the key doesn't exist.
+type Backend interface {
+	Load(ctx context.Context, key string) (interface{}, error)
+	Store(ctx context.Context, key string, value interface{}) error
+	Delete(ctx context.Context, key string) error
+}
+
+// WriteMode decide when the writes reach the Backend
+type WriteMode uint32
+
+const (
+	// WriteThrough write to the backend before the store, under the shard lock, so a failed backend write
+	// fails the store write too
+	WriteThrough WriteMode = 0
+	// WriteBehind queue the writes and flush them in the background. Repeated writes of a key are coalesced,
+	// failed writes are retried with backoff, and Close flushes what is left. GetOrLoad reads the queued writes
+	// before the backend.
+	WriteBehind WriteMode = 1
+
+	defaultWriteBehindInterval = time.Second
+	defaultWriteBehindRetries  = 3
+)
+
+// writeBehindQueue hold the latest pending write of every key
+type writeBehindQueue struct {
+	mu       sync.Mutex
+	pending  map[string]*pendingWrite
+	flushing map[string]*pendingWrite // the writes taken by the flush running, if any
+	stop     func()
+	flushMu  sync.Mutex // only one flush at a time
+}
+
+type pendingWrite struct {
+	value     interface{}
+	deleted   bool
+	attempts  int
+	notBefore int64 // timestamp nanosecond before which a failed write isn't retried
+}
+
+// persist send a write to the backend according to the write mode. The caller must hold the lock of the key's
+// shard, which keeps the writes of a key in order.
+func (s *shardedMapStore) persist(ctx context.Context, key string, value interface{}, deleted bool) error {
+	if s.backend == nil {
+		return nil
+	}
+	if s.writeMode == WriteBehind {
+		s.writeBehind.enqueue(key, &pendingWrite{value: value, deleted: deleted}, &s.stats)
+		return nil
+	}
+	if deleted {
+		return s.backend.Delete(ctx, key)
+	}
+	return s.backend.Store(ctx, key, value)
+}
+
+// backendWrite is a write of persistAll
+type backendWrite struct {
+	key     string
+	value   interface{}
+	deleted bool
+}
+
+// persistAll persist the writes of a batch, in order. With WriteThrough, every key is loaded from the backend before
+// it is written, and if a write fails the keys already written are restored, so the backend keeps either all the
+// writes or none of them as long as nothing else writes it. Writing back is best effort: its failures are only
+// logged. The caller must hold the locks of the shards of the keys.
+func (s *shardedMapStore) persistAll(ctx context.Context, op string, writes []backendWrite) error {
+	if s.backend == nil || s.writeMode == WriteBehind {
+		for _, w := range writes {
+			_ = s.persist(ctx, w.key, w.value, w.deleted)
+		}
+		return nil
+	}
+	previous := make([]backendWrite, 0, len(writes))
+	for _, w := range writes {
+		old, err := s.backend.Load(ctx, w.key)
+		missing := errors.Is(err, ErrKeyNotFound)
+		if err == nil || missing {
+			err = s.persist(ctx, w.key, w.value, w.deleted)
+		}
+		if err != nil {
+			s.restore(previous)
+			return keyError(op, w.key, err)
+		}
+		previous = append(previous, backendWrite{key: w.key, value: old, deleted: missing})
+	}
+	return nil
+}
+
+// restore write back the previous values of the keys persistAll wrote, the last one first. It doesn't use the
+// context of the batch, which may be what made it fail.
+func (s *shardedMapStore) restore(previous []backendWrite) {
+	for i := len(previous) - 1; i >= 0; i-- {
+		w := previous[i]
+		if err := s.persist(context.Background(), w.key, w.value, w.deleted); err != nil {
+			log.Printf("backend_restore_failed | key=%v | err=%v", w.key, err)
+		}
+	}
+}
+
+func (q *writeBehindQueue) enqueue(key string, w *pendingWrite, stats *Stats) {
+	q.mu.Lock()
+	if _, ok := q.pending[key]; ok {
+		atomic.AddInt64(&stats.WritesCoalesced, 1)
+	}
+	q.pending[key] = w
+	q.mu.Unlock()
+}
+
+// lookup return the latest write of key which may not have reached the backend yet
+func (q *writeBehindQueue) lookup(key string) (*pendingWrite, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if w, ok := q.pending[key]; ok {
+		return w, true
+	}
+	w, ok := q.flushing[key]
+	return w, ok
+}
+
+// loadBackend is the Loader of the backend. With WriteBehind, a write still queued is returned rather than the
+// older value of the backend.
+func (s *shardedMapStore) loadBackend(ctx context.Context, key string) (interface{}, error) {
+	if s.writeBehind != nil {
+		if w, ok := s.writeBehind.lookup(key); ok {
+			if w.deleted {
+				return nil, keyError("load", key, ErrKeyNotFound)
+			}
+			return w.value, nil
+		}
+	}
+	return s.backend.Load(ctx, key)
+}
+
+func (s *shardedMapStore) startWriteBehind() {
+	s.writeBehind = &writeBehindQueue{
+		pending: make(map[string]*pendingWrite),
+	}
+	s.writeBehind.stop = s.clock.Schedule(s.writeBehindInterval, func() {
+		s.flushWriteBehind(false)
+	})
+}
+
+// stopWriteBehind stop the background flush and flush everything left, retrying right away
+func (s *shardedMapStore) stopWriteBehind() error {
+	s.writeBehind.stop()
+	for {
+		failed, given, lastErr := s.flushWriteBehind(true)
+		if failed == 0 {
+			if given > 0 {
+				return fmt.Errorf("flush write-behind: %d writes given up: %w", given, lastErr)
+			}
+			return nil
+		}
+	}
+}
+
+// flushWriteBehind write the pending writes to the backend. A failed write is queued again with a backoff unless
+// a newer write of the key was queued meanwhile, or it has failed too many times. force ignores the backoff.
+// It return how many writes are queued again and how many were given up.
+func (s *shardedMapStore) flushWriteBehind(force bool) (failed int, givenUp int, lastErr error) {
+	q := s.writeBehind
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+
+	now := s.now()
+	q.mu.Lock()
+	batch := make(map[string]*pendingWrite, len(q.pending))
+	for key, w := range q.pending {
+		if force || w.notBefore <= now {
+			batch[key] = w
+			delete(q.pending, key)
+		}
+	}
+	q.flushing = batch
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		q.flushing = nil
+		q.mu.Unlock()
+	}()
+
+	for key, w := range batch {
+		var err error
+		if w.deleted {
+			err = s.backend.Delete(context.Background(), key)
+		} else {
+			err = s.backend.Store(context.Background(), key, w.value)
+		}
+		if err == nil {
+			atomic.AddInt64(&s.stats.WritesFlushed, 1)
+			continue
+		}
+
+		lastErr = err
+		w.attempts++
+		if w.attempts > s.writeBehindRetries {
+			log.Printf("write_behind_given_up | key=%v | err=%v", key, err)
+			atomic.AddInt64(&s.stats.WritesFailed, 1)
+			givenUp++
+			continue
+		}
+		w.notBefore = now + int64(s.writeBehindInterval)<<uint(w.attempts-1)
+
+		q.mu.Lock()
+		if _, newer := q.pending[key]; !newer {
+			q.pending[key] = w
+			failed++
+		}
+		q.mu.Unlock()
+	}
+	return failed, givenUp, lastErr
+}
@@ -0,0 +1,305 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyBackend is a MemoryBackend failing the writes while failing is set, and recording the writes it got
+type flakyBackend struct {
+	*MemoryBackend
+	mu      sync.Mutex
+	failing bool
+	failOn  string // a key whose writes fail even when failing isn't set
+	writes  []string
+}
+
+var errBackendDown = errors.New("backend down")
+
+func (b *flakyBackend) record(key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.writes = append(b.writes, key)
+	if b.failing || key == b.failOn {
+		return errBackendDown
+	}
+	return nil
+}
+
+func (b *flakyBackend) setFailing(failing bool) {
+	b.mu.Lock()
+	b.failing = failing
+	b.mu.Unlock()
+}
+
+func (b *flakyBackend) writeCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.writes)
+}
+
+func (b *flakyBackend) Store(ctx context.Context, key string, value interface{}) error {
+	if err := b.record(key); err != nil {
+		return err
+	}
+	return b.MemoryBackend.Store(ctx, key, value)
+}
+
+func (b *flakyBackend) Delete(ctx context.Context, key string) error {
+	if err := b.record(key); err != nil {
+		return err
+	}
+	return b.MemoryBackend.Delete(ctx, key)
+}
+
+func Test_WriteThrough(t *testing.T) {
+	backend := &flakyBackend{MemoryBackend: NewMemoryBackend()}
+	s := GetShardedMapStore(SetBackend(backend, WriteThrough))
+
+	_ = s.Set("a", 1)
+	if v, err := backend.Load(context.Background(), "a"); err != nil || v != 1 {
+		t.Errorf("value_should_be_written_through, v: %v, err: %v", v, err)
+	}
+	_ = s.Increase("a")
+	if v, _ := backend.Load(context.Background(), "a"); v != 2 {
+		t.Errorf("increase_should_be_written_through, got: %v, want: %v", v, 2)
+	}
+	_ = s.Delete("a")
+	if _, err := backend.Load(context.Background(), "a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("delete_should_be_written_through, err: %v", err)
+	}
+
+	// a failed backend write fails the store write
+	backend.setFailing(true)
+	if err := s.Set("b", 1); !errors.Is(err, errBackendDown) {
+		t.Errorf("set_should_fail, got: %v, want: %v", err, errBackendDown)
+	}
+	if _, err := s.Get("b"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("failed_set_should_not_be_cached, err: %v", err)
+	}
+}
+
+func Test_WriteBehind_Coalesce(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	backend := &flakyBackend{MemoryBackend: NewMemoryBackend()}
+	s := GetShardedMapStore(SetClock(clock), SetBackend(backend, WriteBehind),
+		SetWriteBehindFlush(time.Second, 3))
+
+	for i := 0; i < 10; i++ {
+		_ = s.Set("counter", i)
+	}
+	_ = s.Set("other", "x")
+	if backend.writeCount() != 0 {
+		t.Errorf("writes_should_be_deferred, got: %v, want: %v", backend.writeCount(), 0)
+	}
+
+	clock.Advance(time.Second)
+	if backend.writeCount() != 2 {
+		t.Errorf("writes_should_be_coalesced, got: %v, want: %v", backend.writeCount(), 2)
+	}
+	if v, _ := backend.Load(context.Background(), "counter"); v != 9 {
+		t.Errorf("latest_value_should_be_flushed, got: %v, want: %v", v, 9)
+	}
+	if stats := s.GetStats(); stats.WritesFlushed != 2 || stats.WritesCoalesced != 9 {
+		t.Errorf("stats_incorrect, stats: %+v", stats)
+	}
+}
+
+func Test_WriteBehind_Retry(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	backend := &flakyBackend{MemoryBackend: NewMemoryBackend(), failing: true}
+	s := GetShardedMapStore(SetClock(clock), SetBackend(backend, WriteBehind),
+		SetWriteBehindFlush(time.Second, 2))
+
+	_ = s.Set("a", 1)
+	clock.Advance(time.Second) // first attempt fails, retried in 1s
+	clock.Advance(time.Second) // second attempt fails, retried in 2s
+	clock.Advance(time.Second) // backing off
+	if backend.writeCount() != 2 {
+		t.Errorf("retry_should_back_off, got: %v, want: %v", backend.writeCount(), 2)
+	}
+
+	backend.setFailing(false)
+	clock.Advance(time.Second)
+	if v, err := backend.Load(context.Background(), "a"); err != nil || v != 1 {
+		t.Errorf("write_should_be_retried, v: %v, err: %v", v, err)
+	}
+
+	// given up after the retries
+	backend.setFailing(true)
+	_ = s.Set("b", 1)
+	for i := 0; i < 10; i++ {
+		clock.Advance(4 * time.Second)
+	}
+	if stats := s.GetStats(); stats.WritesFailed != 1 {
+		t.Errorf("write_should_be_given_up, stats: %+v", stats)
+	}
+}
+
+func Test_WriteBehind_RetryKeepNewerWrite(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	backend := &flakyBackend{MemoryBackend: NewMemoryBackend(), failing: true}
+	s := GetShardedMapStore(SetClock(clock), SetBackend(backend, WriteBehind),
+		SetWriteBehindFlush(time.Second, 3))
+
+	_ = s.Set("a", 1)
+	clock.Advance(time.Second)
+	// a newer write replaces the failed one instead of being overwritten by its retry
+	_ = s.Set("a", 2)
+	backend.setFailing(false)
+	clock.Advance(time.Second)
+	clock.Advance(4 * time.Second)
+	if v, _ := backend.Load(context.Background(), "a"); v != 2 {
+		t.Errorf("newer_write_should_win, got: %v, want: %v", v, 2)
+	}
+}
+
+func Test_WriteBehind_FlushOnClose(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	backend := &flakyBackend{MemoryBackend: NewMemoryBackend()}
+	s := GetShardedMapStore(SetClock(clock), SetBackend(backend, WriteBehind))
+
+	_ = s.Set("a", 1)
+	_ = s.Set("b", 2)
+	_ = s.Delete("b")
+	if err := s.Close(); err != nil {
+		t.Errorf("close_error, err: %v", err)
+	}
+	if v, _ := backend.Load(context.Background(), "a"); v != 1 {
+		t.Errorf("pending_write_should_be_flushed, got: %v, want: %v", v, 1)
+	}
+	if backend.Len() != 1 {
+		t.Errorf("pending_delete_should_be_flushed, got: %v, want: %v", backend.Len(), 1)
+	}
+
+	// the writes still failing on close are reported
+	backend = &flakyBackend{MemoryBackend: NewMemoryBackend(), failing: true}
+	s = GetShardedMapStore(SetClock(clock), SetBackend(backend, WriteBehind))
+	_ = s.Set("a", 1)
+	if err := s.Close(); !errors.Is(err, errBackendDown) {
+		t.Errorf("close_should_report_lost_writes, got: %v, want: %v", err, errBackendDown)
+	}
+}
+
+func Test_GetOrLoad_Backend(t *testing.T) {
+	backend := NewMemoryBackend()
+	_ = backend.Store(context.Background(), "a", "from-backend")
+	s := GetShardedMapStore(SetBackend(backend, WriteThrough))
+
+	if v, err := s.GetOrLoad(context.Background(), "a", nil); err != nil || v != "from-backend" {
+		t.Errorf("get_or_load_should_load_from_backend, v: %v, err: %v", v, err)
+	}
+	if v, err := s.Get("a"); err != nil || v != "from-backend" {
+		t.Errorf("loaded_value_should_be_cached, v: %v, err: %v", v, err)
+	}
+}
+
+func Test_GetOrLoad_WriteBehindPending(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	backend := NewMemoryBackend()
+	_ = backend.Store(context.Background(), "a", "old")
+	_ = backend.Store(context.Background(), "b", "old")
+	s := GetShardedMapStore(SetClock(clock), SetBackend(backend, WriteBehind),
+		SetWriteBehindFlush(time.Hour, 3), SetExpiryInterval(0))
+
+	_ = s.SetWithTimeout("a", "new", time.Second)
+	_ = s.Delete("b")
+	clock.Advance(2 * time.Second)
+	// the writes are still queued, the backend has the old values
+	if v, err := s.GetOrLoad(context.Background(), "a", nil); err != nil || v != "new" {
+		t.Errorf("get_or_load_should_see_pending_write, v: %v, err: %v", v, err)
+	}
+	if _, err := s.GetOrLoad(context.Background(), "b", nil); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("get_or_load_should_see_pending_delete, got: %v, want: %v", err, ErrKeyNotFound)
+	}
+}
+
+func Test_MSetAtomic_BackendRollback(t *testing.T) {
+	backend := &flakyBackend{MemoryBackend: NewMemoryBackend(), failOn: "b"}
+	_ = backend.Store(context.Background(), "a", "old")
+	s := GetShardedMapStore(SetBackend(backend, WriteThrough))
+
+	err := s.MSetAtomic(context.Background(), map[string]interface{}{"a": "new", "b": "new", "c": "new"}, 0)
+	if !errors.Is(err, errBackendDown) {
+		t.Errorf("mset_atomic_should_fail, got: %v, want: %v", err, errBackendDown)
+	}
+	// whichever keys were written before b are restored
+	if v, _ := backend.Load(context.Background(), "a"); v != "old" {
+		t.Errorf("written_key_should_be_restored, got: %v, want: %v", v, "old")
+	}
+	if _, err := backend.Load(context.Background(), "c"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("new_key_should_be_deleted, got: %v, want: %v", err, ErrKeyNotFound)
+	}
+	if _, err := s.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("cache_should_be_untouched, got: %v, want: %v", err, ErrKeyNotFound)
+	}
+}
+
+func Test_Txn_BackendRollback(t *testing.T) {
+	backend := &flakyBackend{MemoryBackend: NewMemoryBackend(), failOn: "b"}
+	_ = backend.Store(context.Background(), "a", "old")
+	_ = backend.Store(context.Background(), "c", "old")
+	s := GetShardedMapStore(SetBackend(backend, WriteThrough))
+
+	err := s.Txn(context.Background(), []string{"a", "b", "c"}, func(tx Tx) error {
+		_ = tx.Set("a", "new", 0)
+		_ = tx.Delete("c")
+		return tx.Set("b", "new", 0)
+	})
+	if !errors.Is(err, errBackendDown) {
+		t.Errorf("txn_should_fail, got: %v, want: %v", err, errBackendDown)
+	}
+	for _, key := range []string{"a", "c"} {
+		if v, _ := backend.Load(context.Background(), key); v != "old" {
+			t.Errorf("written_key_should_be_restored, key: %v, got: %v, want: %v", key, v, "old")
+		}
+	}
+}
+
+func Test_FileBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kash-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend, err := NewFileBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := backend.Load(ctx, "user/1"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("missing_key_error, got: %v, want: %v", err, ErrKeyNotFound)
+	}
+	if err := backend.Store(ctx, "user/1", map[string]interface{}{"name": "kash"}); err != nil {
+		t.Errorf("store_error, err: %v", err)
+	}
+	v, err := backend.Load(ctx, "user/1")
+	if m, ok := v.(map[string]interface{}); err != nil || !ok || m["name"] != "kash" {
+		t.Errorf("load_error, v: %v, err: %v", v, err)
+	}
+	if err := backend.Delete(ctx, "user/1"); err != nil {
+		t.Errorf("delete_error, err: %v", err)
+	}
+	if _, err := backend.Load(ctx, "user/1"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("deleted_key_error, got: %v, want: %v", err, ErrKeyNotFound)
+	}
+
+	// too long to be a file name, even hex encoded
+	long := strings.Repeat("k", 1000)
+	if err := backend.Store(ctx, long, "v"); err != nil {
+		t.Errorf("store_long_key_error, err: %v", err)
+	}
+	if v, err := backend.Load(ctx, long); err != nil || v != "v" {
+		t.Errorf("load_long_key_error, v: %v, err: %v", v, err)
+	}
+	if _, err := backend.Load(ctx, long+"x"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("missing_long_key_error, got: %v, want: %v", err, ErrKeyNotFound)
+	}
+}
@@ -0,0 +1,170 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// shardBatch is the positions in a batch of the keys falling in one shard
+type shardBatch struct {
+	shard int
+	items []int
+}
+
+// groupByShard split the keys of a batch by shard, in the order of the shards, so the callers locking several
+// shards at once always take the locks in the same order and can't deadlock each other
+func groupByShard(keys []string, shardOf func(key string) int) []shardBatch {
+	var batches []shardBatch
+	index := make(map[int]int) // shard to its position in batches
+	for i, key := range keys {
+		shard := shardOf(key)
+		b, ok := index[shard]
+		if !ok {
+			b = len(batches)
+			index[shard] = b
+			batches = append(batches, shardBatch{shard: shard})
+		}
+		batches[b].items = append(batches[b].items, i)
+	}
+	sort.Slice(batches, func(i, j int) bool { return batches[i].shard < batches[j].shard })
+	return batches
+}
+
+func (s *shardedMapStore) shardIndex(key string) int {
+	return int(s.hash(key) & s.shardMask)
+}
+
+// MGet return the values of the keys found, taking the lock of each shard once
+func (s *shardedMapStore) MGet(ctx context.Context, keys []string) (map[string]interface{}, error) {
+	data := make([]interface{}, len(keys))
+	found := make([]bool, len(keys))
+	for _, b := range groupByShard(keys, s.shardIndex) {
+		sm := &s.shardedMaps[b.shard]
+		if err := sm.lock(ctx); err != nil {
+			return nil, keyError("mget", keys[b.items[0]], err)
+		}
+		now := s.now()
+		for _, i := range b.items {
+			data[i], _, found[i] = s.read(sm, keys[i], now, s.refreshLoader)
+		}
+		sm.mu.Unlock()
+	}
+
+	values := make(map[string]interface{}, len(keys))
+	for i, key := range keys {
+		if !found[i] {
+			continue
+		}
+		value, err := s.valueOf(data[i])
+		if err != nil {
+			return nil, keyError("mget", key, err)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// MSet set the values, taking the lock of each shard once. The shards are written one after the other, so a
+// concurrent reader may see some of the values before the others. See MSetAtomic.
+func (s *shardedMapStore) MSet(ctx context.Context, values map[string]interface{}, timeout time.Duration) error {
+	return s.mset(ctx, values, timeout, false)
+}
+
+// MSetAtomic is MSet holding the locks of all the shards involved while writing, so a concurrent reader sees
+// either none or all of the values. The locks are taken in the order of the shards to avoid deadlocks. With a
+// backend, the values are written to it first, and a failure there leaves the cache untouched and restores the
+// values already written to the backend, see persistAll.
+func (s *shardedMapStore) MSetAtomic(ctx context.Context, values map[string]interface{}, timeout time.Duration) error {
+	return s.mset(ctx, values, timeout, true)
+}
+
+func (s *shardedMapStore) mset(ctx context.Context, values map[string]interface{}, timeout time.Duration, all bool) error {
+	keys := make([]string, 0, len(values))
+	writes := make([]preparedValue, 0, len(values))
+	for key, value := range values {
+		w, err := s.prepare(key, value, s.staleAfter, timeout, s.slidingExpiration)
+		if err != nil {
+			return keyError("mset", key, err)
+		}
+		keys = append(keys, key)
+		writes = append(writes, w)
+	}
+	batches := groupByShard(keys, s.shardIndex)
+
+	if all {
+		for n, b := range batches {
+			if err := s.shardedMaps[b.shard].lock(ctx); err != nil {
+				s.unlockBatches(batches[:n])
+				return keyError("mset", keys[b.items[0]], err)
+			}
+		}
+		persisted := make([]backendWrite, len(writes))
+		for i, w := range writes {
+			persisted[i] = backendWrite{key: w.key, value: w.value}
+		}
+		if err := s.persistAll(ctx, "mset", persisted); err != nil {
+			s.unlockBatches(batches)
+			return err
+		}
+		for _, b := range batches {
+			for _, i := range b.items {
+				s.write(&s.shardedMaps[b.shard], &writes[i])
+			}
+		}
+		s.unlockBatches(batches)
+	} else {
+		for _, b := range batches {
+			sm := &s.shardedMaps[b.shard]
+			if err := sm.lock(ctx); err != nil {
+				return keyError("mset", keys[b.items[0]], err)
+			}
+			for _, i := range b.items {
+				if err := s.persist(ctx, keys[i], writes[i].value, false); err != nil {
+					sm.mu.Unlock()
+					return keyError("mset", keys[i], err)
+				}
+				s.write(sm, &writes[i])
+			}
+			sm.mu.Unlock()
+		}
+	}
+
+	if len(keys) > 0 {
+		s.evictIfNeeded(keys[len(keys)-1])
+	}
+	return nil
+}
+
+func (s *shardedMapStore) unlockBatches(batches []shardBatch) {
+	for _, b := range batches {
+		s.shardedMaps[b.shard].mu.Unlock()
+	}
+}
+
+// MDelete delete the keys, taking the lock of each shard once, and return how many of them existed
+func (s *shardedMapStore) MDelete(ctx context.Context, keys []string) (int, error) {
+	deleted := 0
+	for _, b := range groupByShard(keys, s.shardIndex) {
+		sm := &s.shardedMaps[b.shard]
+		if err := sm.lock(ctx); err != nil {
+			return deleted, keyError("mdelete", keys[b.items[0]], err)
+		}
+		now := s.now()
+		for _, i := range b.items {
+			if err := s.persist(ctx, keys[i], nil, true); err != nil {
+				sm.mu.Unlock()
+				return deleted, keyError("mdelete", keys[i], err)
+			}
+			if e, ok := sm.m[keys[i]]; ok {
+				if now <= e.deadline {
+					deleted++
+				}
+				s.removeEntry(sm, keys[i], e)
+			}
+			s.markDeleted(sm)
+		}
+		sm.mu.Unlock()
+	}
+	return deleted, nil
+}
@@ -0,0 +1,39 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func Test_groupByShard(t *testing.T) {
+	keys := []string{"a3", "b1", "c3", "d0", "e1"}
+	got := groupByShard(keys, func(key string) int { return int(key[1] - '0') })
+	want := []shardBatch{{0, []int{3}}, {1, []int{1, 4}}, {3, []int{0, 2}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("group_by_shard_incorrect, got: %v, want: %v", got, want)
+	}
+}
+
+func Benchmark_MGet(b *testing.B) {
+	s := GetShardedMapStore()
+	keys := make([]string, 50)
+	for i := range keys {
+		keys[i] = fmt.Sprint("key-", i)
+		_ = s.Set(keys[i], i)
+	}
+	ctx := context.Background()
+	b.Run("Get", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, key := range keys {
+				_, _ = s.GetCtx(ctx, key)
+			}
+		}
+	})
+	b.Run("MGet", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _ = s.MGet(ctx, keys)
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of time of a Store. Every TTL computation and the background expiry go through it,
+// so a FakeClock makes them deterministic.
+type Clock interface {
+	Now() time.Time
+	// Schedule call f every interval until the returned stop function is called. stop waits for a running f.
+	Schedule(interval time.Duration, f func()) (stop func())
+}
+
+// realClock is the default Clock, backed by the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Schedule(interval time.Duration, f func()) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				f()
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-finished
+	}
+}
+
+// FakeClock is a Clock which only moves when told to. The scheduled functions run synchronously inside Advance,
+// so once Advance returns the background work due by then has been done.
+type FakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	tasks map[int]*fakeTask
+	id    int
+}
+
+type fakeTask struct {
+	interval time.Duration
+	next     time.Time
+	f        func()
+	running  sync.WaitGroup // the runs of f in progress, which stop waits for
+}
+
+// NewFakeClock return a FakeClock starting at now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		now:   now,
+		tasks: make(map[int]*fakeTask),
+	}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Schedule(interval time.Duration, f func()) (stop func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.id++
+	id := c.id
+	task := &fakeTask{
+		interval: interval,
+		next:     c.now.Add(interval),
+		f:        f,
+	}
+	c.tasks[id] = task
+	return func() {
+		c.mu.Lock()
+		delete(c.tasks, id)
+		c.mu.Unlock()
+		task.running.Wait()
+	}
+}
+
+// Advance move the clock forward by d and run every scheduled function due by then, once per function
+// no matter how many intervals were skipped, like a time.Ticker dropping ticks for a slow receiver
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []*fakeTask
+	for _, task := range c.tasks {
+		if task.next.After(c.now) {
+			continue
+		}
+		task.running.Add(1)
+		due = append(due, task)
+		skipped := c.now.Sub(task.next) / task.interval
+		task.next = task.next.Add((skipped + 1) * task.interval)
+	}
+	c.mu.Unlock()
+
+	// run outside of the lock since the functions usually read the clock
+	for _, task := range due {
+		task.f()
+		task.running.Done()
+	}
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_FakeClock(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	runs := 0
+	stop := clock.Schedule(10*time.Second, func() {
+		runs++
+	})
+
+	clock.Advance(9 * time.Second)
+	if runs != 0 {
+		t.Errorf("scheduled_func_should_not_run_before_interval, runs: %v", runs)
+	}
+	clock.Advance(time.Second)
+	if runs != 1 {
+		t.Errorf("scheduled_func_should_run_once, runs: %v", runs)
+	}
+	// skipped intervals are dropped
+	clock.Advance(35 * time.Second)
+	if runs != 2 {
+		t.Errorf("scheduled_func_should_run_once_per_advance, runs: %v", runs)
+	}
+	if got, want := clock.Now(), start.Add(45*time.Second); !got.Equal(want) {
+		t.Errorf("fake_clock_now_incorrect, got: %v, want: %v", got, want)
+	}
+
+	stop()
+	clock.Advance(time.Minute)
+	if runs != 2 {
+		t.Errorf("stopped_func_should_not_run, runs: %v", runs)
+	}
+}
+
+func Test_FakeClock_StopWaitsForRunningFunc(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	started := make(chan struct{})
+	release := make(chan struct{})
+	finished := false
+	stop := clock.Schedule(time.Second, func() {
+		close(started)
+		<-release
+		finished = true
+	})
+	go clock.Advance(time.Second)
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Errorf("stop_should_wait_for_running_func")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	<-stopped
+	if !finished {
+		t.Errorf("running_func_should_finish_before_stop_returns")
+	}
+}
+
+func Test_FakeClock_TTL(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s := GetShardedMapStore(SetClock(clock))
+
+	_ = s.SetWithTimeout("salmon", "meteor", time.Minute)
+	deadline, err := s.GetTTL("salmon")
+	if err != nil {
+		t.Errorf("get_ttl_error, err: %v", err)
+	}
+	if want := clock.Now().Add(time.Minute).UnixNano(); deadline != want {
+		t.Errorf("deadline_should_follow_the_clock, got: %v, want: %v", deadline, want)
+	}
+
+	clock.Advance(time.Minute)
+	if _, err := s.Get("salmon"); err != nil {
+		t.Errorf("key_should_not_expire_at_deadline, err: %v", err)
+	}
+	clock.Advance(time.Nanosecond)
+	if _, err := s.Get("salmon"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("key_should_expire_after_deadline, err: %v", err)
+	}
+}
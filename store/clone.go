@@ -0,0 +1,141 @@
+package store
+
+import (
+	"reflect"
+)
+
+// Cloner copy a value so the copy shares no mutable memory with the original
+type Cloner func(value interface{}) interface{}
+
+// CloneMode decide when the values are copied, isolating the cached data from the callers. It is a bit set.
+type CloneMode uint32
+
+const (
+	// CloneNone share the values with the callers. It is the fastest but a caller mutating a map, a slice or a
+	// pointed struct it set or got also mutates the cached value.
+	CloneNone CloneMode = 0
+	// CloneOnSet copy the values when they are stored, so the caller can reuse what it passed to Set
+	CloneOnSet CloneMode = 1 << 0
+	// CloneOnGet copy the values when they are read, so the callers can mutate what Get returns
+	CloneOnGet CloneMode = 1 << 1
+	// CloneOnSetAndGet fully isolate the cached values
+	CloneOnSetAndGet = CloneOnSet | CloneOnGet
+)
+
+// DeepCopy is the default Cloner. The immutable and scalar values, []byte and string are handled without
+// reflection. The maps, slices, arrays, pointers and structs are copied recursively, and the shared or cyclic
+// pointers, maps and slices are preserved. The unexported struct fields, the channels and the funcs are copied shallowly.
+func DeepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, bool, string,
+		int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64, uintptr,
+		float32, float64, complex64, complex128:
+		return v
+	case []byte:
+		if v == nil {
+			return v
+		}
+		return append(make([]byte, 0, len(v)), v...)
+	}
+	return deepCopy(reflect.ValueOf(value), make(map[visit]reflect.Value)).Interface()
+}
+
+// visit identify a pointer, a map or a slice already copied. The length tell apart the slices of the same array.
+type visit struct {
+	typ reflect.Type
+	ptr uintptr
+	len int
+}
+
+// deepCopy return a copy of v. seen map the pointers, maps and slices already copied to their copies.
+func deepCopy(v reflect.Value, seen map[visit]reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		key := visit{v.Type(), v.Pointer(), 0}
+		if c, ok := seen[key]; ok {
+			return c
+		}
+		c := reflect.New(v.Type().Elem())
+		seen[key] = c
+		c.Elem().Set(deepCopy(v.Elem(), seen))
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(deepCopy(v.Elem(), seen))
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		key := visit{v.Type(), v.Pointer(), 0}
+		if c, ok := seen[key]; ok {
+			return c
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		seen[key] = c
+		iter := v.MapRange()
+		for iter.Next() {
+			c.SetMapIndex(deepCopy(iter.Key(), seen), deepCopy(iter.Value(), seen))
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			reflect.Copy(c, v)
+			return c
+		}
+		key := visit{v.Type(), v.Pointer(), v.Len()}
+		if c, ok := seen[key]; ok {
+			return c
+		}
+		seen[key] = c
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), seen))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), seen))
+		}
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v) // the unexported fields can only be copied this way
+		for i := 0; i < v.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(deepCopy(v.Field(i), seen))
+			}
+		}
+		return c
+	default:
+		// scalars, strings, channels and funcs
+		return v
+	}
+}
+
+// cloneOnSet return the value to store
+func (s *shardedMapStore) cloneOnSet(value interface{}) interface{} {
+	if s.cloneMode&CloneOnSet == 0 {
+		return value
+	}
+	return s.cloner(value)
+}
+
+// cloneOnGet return the value to hand to the caller
+func (s *shardedMapStore) cloneOnGet(value interface{}) interface{} {
+	if s.cloneMode&CloneOnGet == 0 {
+		return value
+	}
+	return s.cloner(value)
+}
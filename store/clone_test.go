@@ -0,0 +1,171 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type cloneNode struct {
+	Name     string
+	Tags     []string
+	Attrs    map[string]interface{}
+	Next     *cloneNode
+	Raw      [2][]byte
+	internal []int
+}
+
+func Test_DeepCopy(t *testing.T) {
+	n := &cloneNode{
+		Name:     "a",
+		Tags:     []string{"x"},
+		Attrs:    map[string]interface{}{"nested": []int{1}},
+		Raw:      [2][]byte{[]byte("r")},
+		internal: []int{7},
+	}
+	n.Next = n // cyclic
+
+	c := DeepCopy(n).(*cloneNode)
+	if !reflect.DeepEqual(c, n) {
+		t.Errorf("copy_should_be_equal, got: %+v, want: %+v", c, n)
+	}
+	if c.Next != c {
+		t.Errorf("cycle_should_be_preserved, got: %p, want: %p", c.Next, c)
+	}
+
+	c.Name = "b"
+	c.Tags[0] = "y"
+	c.Attrs["nested"].([]int)[0] = 2
+	c.Raw[0][0] = 'w'
+	if n.Name != "a" || n.Tags[0] != "x" || n.Attrs["nested"].([]int)[0] != 1 || n.Raw[0][0] != 'r' {
+		t.Errorf("copy_should_not_share_memory, got: %+v", n)
+	}
+	// the unexported fields are copied shallowly
+	if &c.internal[0] != &n.internal[0] {
+		t.Errorf("unexported_field_should_be_shared")
+	}
+
+	b := []byte("bytes")
+	cb := DeepCopy(b).([]byte)
+	cb[0] = 'B'
+	if string(b) != "bytes" {
+		t.Errorf("bytes_should_be_copied, got: %s, want: %s", b, "bytes")
+	}
+	if DeepCopy(nil) != nil || DeepCopy(42) != 42 || DeepCopy([]byte(nil)).([]byte) != nil {
+		t.Errorf("trivial_values_should_be_returned_as_is")
+	}
+}
+
+func Test_DeepCopy_CyclicMapAndSlice(t *testing.T) {
+	m := map[string]interface{}{}
+	m["self"] = m
+	cm := DeepCopy(m).(map[string]interface{})
+	if reflect.ValueOf(cm["self"]).Pointer() != reflect.ValueOf(cm).Pointer() {
+		t.Errorf("map_cycle_should_be_preserved")
+	}
+	if reflect.ValueOf(cm).Pointer() == reflect.ValueOf(m).Pointer() {
+		t.Errorf("map_should_be_copied")
+	}
+
+	s := make([]interface{}, 2)
+	s[0] = s
+	s[1] = s[:1]
+	cs := DeepCopy(s).([]interface{})
+	if inner := cs[0].([]interface{}); &inner[0] != &cs[0] || len(inner) != 2 {
+		t.Errorf("slice_cycle_should_be_preserved")
+	}
+	if short := cs[1].([]interface{}); len(short) != 1 {
+		t.Errorf("shorter_slice_should_keep_its_length, got: %v, want: %v", len(short), 1)
+	}
+	if &cs[0] == &s[0] {
+		t.Errorf("slice_should_be_copied")
+	}
+}
+
+func Test_CloneMode(t *testing.T) {
+	tests := []struct {
+		mode          CloneMode
+		isolatedOnSet bool
+		isolatedOnGet bool
+	}{
+		{CloneNone, false, false},
+		{CloneOnSet, true, false},
+		{CloneOnGet, false, true},
+		{CloneOnSetAndGet, true, true},
+	}
+	for _, tt := range tests {
+		s := GetShardedMapStore(SetCloneMode(tt.mode))
+
+		m := map[string]int{"a": 1}
+		_ = s.Set("m", m)
+		m["a"] = 2
+		v, _ := s.Get("m")
+		if got := v.(map[string]int)["a"] == 1; got != tt.isolatedOnSet {
+			t.Errorf("isolated_on_set_incorrect, mode: %v, got: %v, want: %v", tt.mode, got, tt.isolatedOnSet)
+		}
+
+		v.(map[string]int)["a"] = 3
+		v, _ = s.Get("m")
+		if got := v.(map[string]int)["a"] != 3; got != tt.isolatedOnGet {
+			t.Errorf("isolated_on_get_incorrect, mode: %v, got: %v, want: %v", tt.mode, got, tt.isolatedOnGet)
+		}
+	}
+}
+
+func Test_CloneMode_GetOrLoad(t *testing.T) {
+	s := GetShardedMapStore(SetCloneMode(CloneOnGet))
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		return []int{1}, nil
+	}
+	v, _ := s.GetOrLoad(context.Background(), "k", loader)
+	v.([]int)[0] = 2
+	if v, _ := s.Get("k"); v.([]int)[0] != 1 {
+		t.Errorf("loaded_value_should_be_isolated, got: %v, want: %v", v, 1)
+	}
+}
+
+func Test_CloneMode_Collections(t *testing.T) {
+	ctx := context.Background()
+	s := GetShardedMapStore(SetCloneMode(CloneOnSetAndGet))
+
+	m := map[string]int{"a": 1}
+	_, _ = s.RPush(ctx, "list", m)
+	_, _ = s.HSet(ctx, "hash", map[string]interface{}{"f": m})
+	m["a"] = 2
+	v, _ := s.LIndex(ctx, "list", 0)
+	v.(map[string]int)["a"] = 3
+	f, _ := s.HGet(ctx, "hash", "f")
+	f.(map[string]int)["a"] = 3
+
+	v, _ = s.LIndex(ctx, "list", 0)
+	f, _ = s.HGet(ctx, "hash", "f")
+	if v.(map[string]int)["a"] != 1 || f.(map[string]int)["a"] != 1 {
+		t.Errorf("elements_should_be_isolated, list: %v, hash: %v", v, f)
+	}
+	if _, err := s.Get("list"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("list_should_not_be_returned_whole, got: %v, want: %v", err, ErrWrongType)
+	}
+}
+
+func Test_SetCloner(t *testing.T) {
+	calls := 0
+	cloner := func(value interface{}) interface{} {
+		calls++
+		return value
+	}
+	s := GetShardedMapStore(SetCloneMode(CloneOnSetAndGet), SetCloner(cloner))
+	_ = s.Set("k", "v")
+	_, _ = s.Get("k")
+	if calls != 2 {
+		t.Errorf("cloner_should_be_called, got: %v, want: %v", calls, 2)
+	}
+}
+
+func Benchmark_DeepCopy(b *testing.B) {
+	v := &cloneNode{Name: "a", Tags: []string{"x", "y"}, Attrs: map[string]interface{}{"k": 1}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		DeepCopy(v)
+	}
+}
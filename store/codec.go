@@ -0,0 +1,363 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+)
+
+// Codec encode the values of a store set with SetCodec. Unmarshal must accept a *interface{}, which is how Get
+// decodes the values, and should accept a pointer to the type of the encoded value, which is how GetInto does.
+type Codec interface {
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte, dst interface{}) error
+}
+
+// JSONCodec encode the values with encoding/json. Decoded into an interface{}, the integers come back as int,
+// the other numbers as float64, the structs as map[string]interface{} and the []byte as base64 strings.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (JSONCodec) Unmarshal(data []byte, dst interface{}) error {
+	p, ok := dst.(*interface{})
+	if !ok {
+		return json.Unmarshal(data, dst)
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return err
+	}
+	*p = jsonNumbers(v)
+	return nil
+}
+
+// jsonNumbers replace the json.Number in v by an int when it is one and by a float64 otherwise
+func jsonNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil && int64(int(i)) == i {
+			return int(i)
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = jsonNumbers(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = jsonNumbers(e)
+		}
+	}
+	return v
+}
+
+// GobCodec encode the values with encoding/gob. The values are encoded as interfaces, so they decode back to
+// their own type, and their types are registered with gob.Register the first time they are encoded. gob can't
+// register both a type and a pointer to it, so the pointers are encoded as the value they point to, prefixed by
+// how many pointers to rebuild.
+type GobCodec struct{}
+
+func (GobCodec) Marshal(value interface{}) ([]byte, error) {
+	if value == nil {
+		return nil, fmt.Errorf("gob: cannot encode nil value")
+	}
+	var depth byte
+	rv := reflect.ValueOf(value)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() && depth < 255 {
+		rv = rv.Elem()
+		depth++
+	}
+	value = rv.Interface()
+
+	if err := gobRegister(value); err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer([]byte{depth})
+	if err := gob.NewEncoder(buf).Encode(&value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gobTypes map the types already given to gob.Register to the error it returned
+var gobTypes sync.Map
+
+// gobRegister register the type of value with gob once, since gob.Register mutates a process-wide registry
+func gobRegister(value interface{}) error {
+	t := reflect.TypeOf(value)
+	if err, ok := gobTypes.Load(t); ok {
+		err, _ := err.(error)
+		return err
+	}
+	err := func() (err error) {
+		// gob.Register panics on a type name already registered for another type
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("gob: %v", r)
+			}
+		}()
+		gob.Register(value)
+		return nil
+	}()
+	gobTypes.Store(t, err)
+	return err
+}
+
+func (GobCodec) Unmarshal(data []byte, dst interface{}) error {
+	if len(data) == 0 {
+		return fmt.Errorf("gob: empty data")
+	}
+	var v interface{}
+	if err := gob.NewDecoder(bytes.NewReader(data[1:])).Decode(&v); err != nil {
+		return err
+	}
+	for depth := data[0]; depth > 0 && v != nil; depth-- {
+		p := reflect.New(reflect.TypeOf(v))
+		p.Elem().Set(reflect.ValueOf(v))
+		v = p.Interface()
+	}
+	return assignDecoded(dst, v)
+}
+
+// BinaryCodec encode the scalars, the strings and the []byte in a compact tagged format: a type byte followed
+// by a varint, the IEEE bits of a float or the raw bytes. The other values fall back to GobCodec.
+type BinaryCodec struct{}
+
+const (
+	binNil byte = iota
+	binFalse
+	binTrue
+	binInt
+	binInt8
+	binInt16
+	binInt32
+	binInt64
+	binUint
+	binUint8
+	binUint16
+	binUint32
+	binUint64
+	binFloat32
+	binFloat64
+	binString
+	binBytes
+	binGob
+)
+
+func (BinaryCodec) Marshal(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return []byte{binNil}, nil
+	case bool:
+		if v {
+			return []byte{binTrue}, nil
+		}
+		return []byte{binFalse}, nil
+	case int:
+		return appendVarint(binInt, int64(v)), nil
+	case int8:
+		return appendVarint(binInt8, int64(v)), nil
+	case int16:
+		return appendVarint(binInt16, int64(v)), nil
+	case int32:
+		return appendVarint(binInt32, int64(v)), nil
+	case int64:
+		return appendVarint(binInt64, v), nil
+	case uint:
+		return appendUvarint(binUint, uint64(v)), nil
+	case uint8:
+		return appendUvarint(binUint8, uint64(v)), nil
+	case uint16:
+		return appendUvarint(binUint16, uint64(v)), nil
+	case uint32:
+		return appendUvarint(binUint32, uint64(v)), nil
+	case uint64:
+		return appendUvarint(binUint64, v), nil
+	case float32:
+		data := make([]byte, 5)
+		data[0] = binFloat32
+		binary.LittleEndian.PutUint32(data[1:], math.Float32bits(v))
+		return data, nil
+	case float64:
+		data := make([]byte, 9)
+		data[0] = binFloat64
+		binary.LittleEndian.PutUint64(data[1:], math.Float64bits(v))
+		return data, nil
+	case string:
+		return append([]byte{binString}, v...), nil
+	case []byte:
+		return append([]byte{binBytes}, v...), nil
+	}
+	data, err := GobCodec{}.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{binGob}, data...), nil
+}
+
+func appendVarint(tag byte, v int64) []byte {
+	data := make([]byte, 1+binary.MaxVarintLen64)
+	data[0] = tag
+	return data[:1+binary.PutVarint(data[1:], v)]
+}
+
+func appendUvarint(tag byte, v uint64) []byte {
+	data := make([]byte, 1+binary.MaxVarintLen64)
+	data[0] = tag
+	return data[:1+binary.PutUvarint(data[1:], v)]
+}
+
+func (BinaryCodec) Unmarshal(data []byte, dst interface{}) error {
+	if len(data) == 0 {
+		return fmt.Errorf("binary: empty data")
+	}
+	tag, payload := data[0], data[1:]
+	var v interface{}
+	switch tag {
+	case binNil:
+	case binFalse:
+		v = false
+	case binTrue:
+		v = true
+	case binInt, binInt8, binInt16, binInt32, binInt64:
+		i, n := binary.Varint(payload)
+		if n <= 0 {
+			return fmt.Errorf("binary: invalid varint")
+		}
+		v = [...]interface{}{int(i), int8(i), int16(i), int32(i), i}[tag-binInt]
+	case binUint, binUint8, binUint16, binUint32, binUint64:
+		u, n := binary.Uvarint(payload)
+		if n <= 0 {
+			return fmt.Errorf("binary: invalid uvarint")
+		}
+		v = [...]interface{}{uint(u), uint8(u), uint16(u), uint32(u), u}[tag-binUint]
+	case binFloat32:
+		if len(payload) != 4 {
+			return fmt.Errorf("binary: invalid float32")
+		}
+		v = math.Float32frombits(binary.LittleEndian.Uint32(payload))
+	case binFloat64:
+		if len(payload) != 8 {
+			return fmt.Errorf("binary: invalid float64")
+		}
+		v = math.Float64frombits(binary.LittleEndian.Uint64(payload))
+	case binString:
+		v = string(payload)
+	case binBytes:
+		v = append([]byte{}, payload...)
+	case binGob:
+		return GobCodec{}.Unmarshal(payload, dst)
+	default:
+		return fmt.Errorf("binary: unknown type tag %d", tag)
+	}
+	return assignDecoded(dst, v)
+}
+
+// assignDecoded store v in the value dst points to. The numbers are converted between the numeric types, as long
+// as the type of dst can hold the value.
+func assignDecoded(dst interface{}, v interface{}) error {
+	if p, ok := dst.(*interface{}); ok {
+		*p = v
+		return nil
+	}
+	d := reflect.ValueOf(dst)
+	if d.Kind() != reflect.Ptr || d.IsNil() {
+		return fmt.Errorf("decode into non-pointer %T", dst)
+	}
+	elem := d.Elem()
+	if v == nil {
+		elem.Set(reflect.Zero(elem.Type()))
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.Type().AssignableTo(elem.Type()):
+		elem.Set(rv)
+	case isNumber(rv.Kind()) && isNumber(elem.Kind()):
+		c, ok := convertNumber(rv, elem.Type())
+		if !ok {
+			return fmt.Errorf("cannot decode %T %v into %v without overflow or truncation", v, v, elem.Type())
+		}
+		elem.Set(c)
+	default:
+		return fmt.Errorf("cannot decode %T into %v", v, elem.Type())
+	}
+	return nil
+}
+
+func isNumber(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Float64
+}
+
+// convertNumber convert the number v to t, and report whether t holds it exactly. A float converted to another
+// float only has to stay finite.
+func convertNumber(v reflect.Value, t reflect.Type) (reflect.Value, bool) {
+	c := v.Convert(t)
+	from, to := kindClass(v.Kind()), kindClass(t.Kind())
+	switch {
+	case from == reflect.Float64 && to == reflect.Float64:
+		return c, math.IsInf(v.Float(), 0) || !math.IsInf(c.Float(), 0)
+	case from == reflect.Int && to == reflect.Uint && v.Int() < 0:
+		return c, false
+	case from == reflect.Uint && to == reflect.Int && c.Int() < 0:
+		return c, false
+	}
+	return c, c.Convert(v.Type()).Interface() == v.Interface()
+}
+
+// kindClass return reflect.Int, reflect.Uint or reflect.Float64 for the signed, unsigned and float kinds
+func kindClass(k reflect.Kind) reflect.Kind {
+	switch {
+	case k >= reflect.Int && k <= reflect.Int64:
+		return reflect.Int
+	case k >= reflect.Uint && k <= reflect.Uintptr:
+		return reflect.Uint
+	}
+	return reflect.Float64
+}
+
+// encode return the bytes stored for value
+func (s *shardedMapStore) encode(value interface{}) ([]byte, error) {
+	data, err := s.codec.Marshal(value)
+	if err != nil {
+		return nil, &codecError{err}
+	}
+	return data, nil
+}
+
+// decode return the value of the stored bytes
+func (s *shardedMapStore) decode(data interface{}) (interface{}, error) {
+	var value interface{}
+	if err := s.codec.Unmarshal(data.([]byte), &value); err != nil {
+		return nil, &codecError{err}
+	}
+	return value, nil
+}
+
+// codecError make the error of a Codec match ErrCodec while keeping the original one reachable
+type codecError struct {
+	err error
+}
+
+func (e *codecError) Error() string {
+	return ErrCodec.Error() + ": " + e.err.Error()
+}
+
+func (e *codecError) Is(target error) bool {
+	return target == ErrCodec
+}
+
+func (e *codecError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,181 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type codecUser struct {
+	Name  string
+	Age   int
+	Roles []string
+}
+
+func Test_Codecs_RoundTrip(t *testing.T) {
+	values := []interface{}{
+		true, int(-7), int8(-8), int16(300), int32(-70000), int64(1 << 40),
+		uint(7), uint8(8), uint16(300), uint32(70000), uint64(1 << 63),
+		float32(1.5), float64(-2.25), "kash", []byte("raw"),
+		codecUser{"a", 3, []string{"admin"}}, &codecUser{"b", 4, nil}, map[string]int{"a": 1},
+	}
+	for _, codec := range []Codec{GobCodec{}, BinaryCodec{}} {
+		for _, value := range values {
+			data, err := codec.Marshal(value)
+			if err != nil {
+				t.Errorf("marshal_error, codec: %T, value: %v, err: %v", codec, value, err)
+				continue
+			}
+			var got interface{}
+			if err := codec.Unmarshal(data, &got); err != nil || !reflect.DeepEqual(got, value) {
+				t.Errorf("round_trip_incorrect, codec: %T, got: %#v, want: %#v, err: %v", codec, got, value, err)
+			}
+		}
+	}
+}
+
+func Test_GobCodec_RegisterOnce(t *testing.T) {
+	type gobOnce struct{ N int }
+	for i := 0; i < 3; i++ {
+		if _, err := (GobCodec{}).Marshal(gobOnce{i}); err != nil {
+			t.Errorf("marshal_error, err: %v", err)
+		}
+	}
+	if _, ok := gobTypes.Load(reflect.TypeOf(gobOnce{})); !ok {
+		t.Errorf("type_should_be_registered_once")
+	}
+}
+
+func Test_BinaryCodec_Compact(t *testing.T) {
+	data, _ := BinaryCodec{}.Marshal(int(1))
+	if len(data) != 2 {
+		t.Errorf("small_int_should_be_compact, got: %v, want: %v", len(data), 2)
+	}
+	data, _ = BinaryCodec{}.Marshal("kash")
+	if len(data) != 5 {
+		t.Errorf("string_should_be_compact, got: %v, want: %v", len(data), 5)
+	}
+}
+
+func Test_JSONCodec_Numbers(t *testing.T) {
+	data, _ := JSONCodec{}.Marshal(map[string]interface{}{"i": 3, "f": 1.5})
+	var got interface{}
+	if err := (JSONCodec{}).Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"i": 3, "f": 1.5}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("numbers_incorrect, got: %#v, want: %#v", got, want)
+	}
+}
+
+func Test_SetCodec(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec{}, GobCodec{}, BinaryCodec{}} {
+		s := GetShardedMapStore(SetCodec(codec))
+
+		u := codecUser{"alice", 30, []string{"admin"}}
+		if err := s.Set("user", u); err != nil {
+			t.Errorf("set_error, codec: %T, err: %v", codec, err)
+		}
+		u.Roles[0] = "guest"
+
+		var got codecUser
+		if err := s.GetInto(context.Background(), "user", &got); err != nil {
+			t.Errorf("get_into_error, codec: %T, err: %v", codec, err)
+		}
+		if got.Name != "alice" || got.Roles[0] != "admin" {
+			t.Errorf("get_into_incorrect, codec: %T, got: %+v", codec, got)
+		}
+
+		// the counters keep working on the encoded values
+		_ = s.Set("counter", 41)
+		if err := s.Increase("counter"); err != nil {
+			t.Errorf("increase_error, codec: %T, err: %v", codec, err)
+		}
+		if v, _ := s.Get("counter"); v != 42 {
+			t.Errorf("increase_incorrect, codec: %T, got: %v, want: %v", codec, v, 42)
+		}
+		var n int64
+		if err := s.GetInto(context.Background(), "counter", &n); err != nil || n != 42 {
+			t.Errorf("get_into_number_incorrect, codec: %T, got: %v, err: %v", codec, n, err)
+		}
+
+		// the memory usage is the size of the encoded values
+		data, _ := codec.Marshal(42)
+		usage := s.GetMemoryUsage()
+		_ = s.Delete("user")
+		if got, want := s.GetMemoryUsage(), entrySize("counter", data); got != want || usage <= want {
+			t.Errorf("memory_usage_incorrect, codec: %T, got: %v, want: %v", codec, got, want)
+		}
+	}
+}
+
+func Test_SetCodec_Errors(t *testing.T) {
+	s := GetShardedMapStore(SetCodec(JSONCodec{}))
+	if err := s.Set("ch", make(chan int)); !errors.Is(err, ErrCodec) {
+		t.Errorf("unencodable_value_error, got: %v, want: %v", err, ErrCodec)
+	}
+	_ = s.Set("name", "kash")
+	var n int
+	if err := s.GetInto(context.Background(), "name", &n); !errors.Is(err, ErrCodec) {
+		t.Errorf("undecodable_value_error, got: %v, want: %v", err, ErrCodec)
+	}
+}
+
+func Test_SetCodec_DumpAllJSON(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec{}, BinaryCodec{}} {
+		s := GetShardedMapStore(SetCodec(codec))
+		_ = s.Set("user", map[string]interface{}{"name": "alice"})
+		want := `{"user":{"name":"alice"}}`
+		if got, err := s.DumpAllJSON(); err != nil || got != want {
+			t.Errorf("dump_incorrect, codec: %T, got: %v, want: %v, err: %v", codec, got, want, err)
+		}
+	}
+}
+
+func Test_GetInto_NoCodec(t *testing.T) {
+	s := GetShardedMapStore()
+	_ = s.Set("n", uint32(5))
+	var n int
+	if err := s.GetInto(context.Background(), "n", &n); err != nil || n != 5 {
+		t.Errorf("get_into_incorrect, got: %v, err: %v", n, err)
+	}
+	var str string
+	if err := s.GetInto(context.Background(), "n", &str); err == nil {
+		t.Errorf("get_into_should_fail_on_type_mismatch")
+	}
+	if err := s.GetInto(context.Background(), "missing", &str); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("get_into_missing_key_error, got: %v, want: %v", err, ErrKeyNotFound)
+	}
+}
+
+func Test_GetInto_NumberRange(t *testing.T) {
+	var i8 int8
+	var u uint
+	var i int
+	var f32 float32
+	tests := []struct {
+		value interface{}
+		dst   interface{}
+		ok    bool
+	}{
+		{int(127), &i8, true},
+		{int(300), &i8, false},
+		{int(-1), &u, false},
+		{uint64(1 << 63), &i, false},
+		{float64(2), &i, true},
+		{float64(2.5), &i, false},
+		{float64(1e300), &i, false},
+		{float64(0.1), &f32, true},
+		{float64(1e300), &f32, false},
+	}
+	for _, codec := range []Codec{GobCodec{}, BinaryCodec{}} {
+		for _, tt := range tests {
+			data, _ := codec.Marshal(tt.value)
+			if err := codec.Unmarshal(data, tt.dst); (err == nil) != tt.ok {
+				t.Errorf("number_range_check_incorrect, codec: %T, value: %v, dst: %T, err: %v", codec, tt.value, tt.dst, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package store
+
+import (
+	"context"
+	"sync/atomic"
+	"unsafe"
+)
+
+// collectionKind tell the lists from the hashes
+type collectionKind uint8
+
+const (
+	listKind collectionKind = iota
+	hashKind
+)
+
+var interfaceSize = int64(unsafe.Sizeof(interface{}(nil)))
+
+// collection is a value the sharded map store modifies in place under the shard lock instead of replacing it: a
+// list or a hash. The other operations report ErrWrongType for the keys holding one. The sharded map store neither
+// encodes nor compresses it, and the clone mode applies to its elements rather than to the whole value.
+type collection interface {
+	kind() collectionKind
+	len() int
+	// footprint estimate the bytes used by the collection, see sizeOf
+	footprint() int64
+	// snapshot return a copy of the content for the backend and DumpAllJSON
+	snapshot(now int64) interface{}
+	clone() collection
+}
+
+func newCollection(kind collectionKind) collection {
+	if kind == hashKind {
+		return newHashValue()
+	}
+	return &listValue{}
+}
+
+// viewCollection call fn with the collection at key under the shard lock, or a nil one if the key is missing.
+// ErrWrongType is returned if the key holds another kind of value.
+func (s *shardedMapStore) viewCollection(ctx context.Context, op, key string, kind collectionKind, fn func(c collection, now int64)) error {
+	sm := s.selectSharedMap(key)
+	if err := sm.lock(ctx); err != nil {
+		return keyError(op, key, err)
+	}
+	defer sm.mu.Unlock()
+	now := s.now()
+	data, _, ok := s.read(sm, key, now, nil)
+	if h, isHash := data.(*hashValue); ok && isHash && h.expired(now) {
+		// The last field was timeout. Evict the key.
+		s.removeEntry(sm, key, sm.m[key])
+		ok = false
+	}
+	if !ok {
+		fn(nil, now)
+		return nil
+	}
+	c, isCollection := data.(collection)
+	if !isCollection || c.kind() != kind {
+		return keyError(op, key, ErrWrongType)
+	}
+	fn(c, now)
+	return nil
+}
+
+// updateCollection call fn with the collection at key under the shard lock, and account for its new size. A missing
+// key is created with the default timeout if create is true, otherwise ErrKeyNotFound is returned. The key is
+// deleted once its collection is empty. fn must not modify the collection if it returns an error.
+func (s *shardedMapStore) updateCollection(ctx context.Context, op, key string, kind collectionKind, create bool, fn func(c collection, now int64) error) error {
+	sm := s.selectSharedMap(key)
+	if err := sm.lock(ctx); err != nil {
+		return keyError(op, key, err)
+	}
+
+	now := s.now()
+	e, ok := sm.m[key]
+	if ok && now > e.deadline {
+		// The key was timeout. Evict it.
+		s.removeEntry(sm, key, e)
+		ok = false
+	}
+	var c collection
+	if ok {
+		var isCollection bool
+		if c, isCollection = e.data.(collection); !isCollection || c.kind() != kind {
+			sm.mu.Unlock()
+			return keyError(op, key, ErrWrongType)
+		}
+	} else if !create {
+		sm.mu.Unlock()
+		return keyError(op, key, ErrKeyNotFound)
+	} else {
+		c = newCollection(kind)
+	}
+	if s.backend != nil {
+		// the collection is only modified once the backend has the new one
+		c = c.clone()
+	}
+	if err := fn(c, now); err != nil {
+		sm.mu.Unlock()
+		return keyError(op, key, err)
+	}
+
+	if c.len() == 0 {
+		err := s.persist(ctx, key, nil, true)
+		if err == nil && ok {
+			s.removeEntry(sm, key, e)
+			s.markDeleted(sm)
+		}
+		sm.mu.Unlock()
+		if err != nil {
+			return keyError(op, key, err)
+		}
+		return nil
+	}
+	if s.backend != nil {
+		if err := s.persist(ctx, key, c.snapshot(now), false); err != nil {
+			sm.mu.Unlock()
+			return keyError(op, key, err)
+		}
+	}
+
+	size := entrySize(key, c)
+	if !ok {
+		deadline := maxInt64
+		if s.defaultTimeout != 0 {
+			deadline = now + int64(s.defaultTimeout)
+		}
+		e = s.newEntry(key, c, deadline, size)
+		e.timeout = int64(s.defaultTimeout)
+		e.sliding = s.slidingExpiration && s.defaultTimeout != 0
+		s.addEntry(sm, key, e)
+	} else {
+		atomic.AddInt64(&sm.memUsage, size-e.size)
+		e.data = c
+		e.size = size
+	}
+	e.version = atomic.AddUint64(&s.versions, 1)
+	s.trackHash(sm, key, e)
+
+	s.recordAccess(sm, e, ok)
+	if ok && e.sliding {
+		s.restart(sm, e, now)
+	}
+	if s.lruMode == LRUPerShard {
+		s.shardLRUEvict(sm, e)
+	}
+	sm.mu.Unlock()
+
+	s.evictIfNeeded(key)
+	return nil
+}
@@ -0,0 +1,140 @@
+package store
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io/ioutil"
+	"sync/atomic"
+)
+
+// Compressor compress the large values of a store set with SetCompression
+type Compressor interface {
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// FlateCompressor compress with compress/flate at Level, e.g. flate.BestSpeed. The zero value uses
+// flate.NoCompression, so set a level.
+type FlateCompressor struct {
+	Level int
+}
+
+func (c FlateCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, c.Level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (FlateCompressor) Decompress(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// GzipCompressor compress with compress/gzip at Level. The zero value uses gzip.NoCompression, so set a level.
+type GzipCompressor struct {
+	Level int
+}
+
+func (c GzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, c.Level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// compressedValue is the data of an entry holding a compressed value
+type compressedValue struct {
+	data     []byte
+	isString bool // the value was a string, not a []byte
+}
+
+// compress return the data to store for value. Only the []byte and the strings, so the values encoded with a
+// codec too, of at least compressThreshold bytes are compressed, and only when it makes them smaller.
+func (s *shardedMapStore) compress(value interface{}) (interface{}, error) {
+	if s.compressor == nil {
+		return value, nil
+	}
+	var data []byte
+	var isString bool
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		if len(v) < s.compressThreshold {
+			return value, nil
+		}
+		data, isString = []byte(v), true
+	default:
+		return value, nil
+	}
+	if len(data) < s.compressThreshold {
+		return value, nil
+	}
+
+	compressed, err := s.compressor.Compress(data)
+	if err != nil {
+		return nil, &codecError{err}
+	}
+	if len(compressed) >= len(data) {
+		return value, nil
+	}
+	if cap(compressed) > len(compressed) {
+		// the compressors leave spare capacity, which would be held and accounted for nothing
+		compressed = append(make([]byte, 0, len(compressed)), compressed...)
+	}
+	atomic.AddInt64(&s.stats.CompressedBytesIn, int64(len(data)))
+	atomic.AddInt64(&s.stats.CompressedBytesOut, int64(len(compressed)))
+	return &compressedValue{data: compressed, isString: isString}, nil
+}
+
+// decompress return the value of the stored data, and whether it was compressed
+func (s *shardedMapStore) decompress(data interface{}) (value interface{}, compressed bool, err error) {
+	c, ok := data.(*compressedValue)
+	if !ok {
+		return data, false, nil
+	}
+	b, err := s.compressor.Decompress(c.data)
+	if err != nil {
+		return nil, true, &codecError{err}
+	}
+	if c.isString {
+		return string(b), true, nil
+	}
+	return b, true, nil
+}
+
+// CompressionRatio return the uncompressed bytes per compressed byte of the values compressed so far
+func (st Stats) CompressionRatio() float64 {
+	if st.CompressedBytesOut == 0 {
+		return 0
+	}
+	return float64(st.CompressedBytesIn) / float64(st.CompressedBytesOut)
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func jsonBlob(n int) string {
+	var b strings.Builder
+	b.WriteString("[")
+	for i := 0; i < n; i++ {
+		b.WriteString(`{"id":12345,"name":"kash","tags":["cache","store"],"active":true},`)
+	}
+	b.WriteString("{}]")
+	return b.String()
+}
+
+func Test_Compressors_RoundTrip(t *testing.T) {
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+	inputs := [][]byte{
+		nil,
+		[]byte("a"),
+		[]byte(jsonBlob(100)),
+		bytes.Repeat([]byte{0}, 100000),
+		random,
+	}
+	compressors := []Compressor{
+		LZCompressor{}, FlateCompressor{Level: flate.BestSpeed}, GzipCompressor{Level: gzip.DefaultCompression},
+	}
+	for _, c := range compressors {
+		for _, in := range inputs {
+			compressed, err := c.Compress(in)
+			if err != nil {
+				t.Errorf("compress_error, compressor: %T, err: %v", c, err)
+				continue
+			}
+			out, err := c.Decompress(compressed)
+			if err != nil || !bytes.Equal(out, in) {
+				t.Errorf("round_trip_incorrect, compressor: %T, len: %v, err: %v", c, len(in), err)
+			}
+		}
+	}
+}
+
+func Test_LZCompressor_Corrupt(t *testing.T) {
+	compressed, _ := LZCompressor{}.Compress([]byte(jsonBlob(10)))
+	for _, in := range [][]byte{compressed[:len(compressed)/2], {0x05, 0x03, 0x09}, {0xff}} {
+		if _, err := (LZCompressor{}).Decompress(in); err == nil {
+			t.Errorf("corrupt_input_should_fail, input: %v", in)
+		}
+	}
+}
+
+func Test_SetCompression(t *testing.T) {
+	s := GetShardedMapStore(SetCompression(LZCompressor{}, 1024))
+
+	blob := jsonBlob(100)
+	_ = s.Set("blob", blob)
+	_ = s.Set("bytes", []byte(blob))
+	_ = s.Set("small", "tiny")
+
+	if v, err := s.Get("blob"); err != nil || v != blob {
+		t.Errorf("string_should_be_decompressed, err: %v", err)
+	}
+	if v, err := s.Get("bytes"); err != nil || string(v.([]byte)) != blob {
+		t.Errorf("bytes_should_be_decompressed, err: %v", err)
+	}
+	var str string
+	if err := s.GetInto(context.Background(), "blob", &str); err != nil || str != blob {
+		t.Errorf("get_into_should_decompress, err: %v", err)
+	}
+
+	stats := s.GetStats()
+	if stats.CompressedBytesIn != int64(2*len(blob)) || stats.CompressionRatio() < 5 {
+		t.Errorf("stats_incorrect, stats: %+v, ratio: %v", stats, stats.CompressionRatio())
+	}
+	// the compressed sizes are what is accounted
+	if usage := s.GetMemoryUsage(); usage >= int64(len(blob)) {
+		t.Errorf("memory_usage_should_be_compressed, got: %v, uncompressed: %v", usage, len(blob))
+	}
+}
+
+func Test_SetCompression_MaxMemory(t *testing.T) {
+	blob := jsonBlob(1000)
+	s := GetShardedMapStore(SetMaxMemory("16KB"))
+	if err := s.Set("blob", blob); !errors.Is(err, ErrExceedMaxMemory) {
+		t.Errorf("uncompressed_should_exceed, got: %v, want: %v", err, ErrExceedMaxMemory)
+	}
+
+	s = GetShardedMapStore(SetMaxMemory("16KB"), SetCompression(FlateCompressor{Level: flate.BestSpeed}, 1024))
+	if err := s.Set("blob", blob); err != nil {
+		t.Errorf("compressed_should_fit, err: %v", err)
+	}
+}
+
+func Test_SetCompression_Codec(t *testing.T) {
+	s := GetShardedMapStore(SetCodec(JSONCodec{}), SetCompression(GzipCompressor{Level: gzip.BestSpeed}, 0))
+
+	users := make([]codecUser, 100)
+	for i := range users {
+		users[i] = codecUser{"alice", i, []string{"admin"}}
+	}
+	_ = s.Set("users", users)
+	var got []codecUser
+	if err := s.GetInto(context.Background(), "users", &got); err != nil || len(got) != 100 || got[99].Age != 99 {
+		t.Errorf("get_into_incorrect, len: %v, err: %v", len(got), err)
+	}
+
+	_ = s.Set("counter", 1)
+	if err := s.Increase("counter"); err != nil {
+		t.Errorf("increase_error, err: %v", err)
+	}
+	if v, _ := s.Get("counter"); v != 2 {
+		t.Errorf("increase_incorrect, got: %v, want: %v", v, 2)
+	}
+	if _, err := s.DumpAllJSON(); err != nil {
+		t.Errorf("dump_error, err: %v", err)
+	}
+}
+
+func Benchmark_LZCompressor(b *testing.B) {
+	blob := []byte(jsonBlob(1000))
+	b.SetBytes(int64(len(blob)))
+	for i := 0; i < b.N; i++ {
+		_, _ = LZCompressor{}.Compress(blob)
+	}
+}
+
+func Benchmark_FlateCompressor(b *testing.B) {
+	blob := []byte(jsonBlob(1000))
+	c := FlateCompressor{Level: flate.BestSpeed}
+	b.SetBytes(int64(len(blob)))
+	for i := 0; i < b.N; i++ {
+		_, _ = c.Compress(blob)
+	}
+}
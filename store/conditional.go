@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// SetNX set key only if it doesn't exist, and report whether it did. A timeout of 0 means no timeout.
+func (s *shardedMapStore) SetNX(ctx context.Context, key string, value interface{}, timeout time.Duration) (bool, error) {
+	return s.setIf(ctx, key, value, s.staleAfter, timeout, s.slidingExpiration, true, func(e *entry) bool {
+		return e == nil
+	})
+}
+
+// SetXX set key only if it already exists, and report whether it did. A timeout of 0 means no timeout.
+func (s *shardedMapStore) SetXX(ctx context.Context, key string, value interface{}, timeout time.Duration) (bool, error) {
+	return s.setIf(ctx, key, value, s.staleAfter, timeout, s.slidingExpiration, true, func(e *entry) bool {
+		return e != nil
+	})
+}
+
+// GetSet set key and return its previous value. existed is false if there was none.
+func (s *shardedMapStore) GetSet(ctx context.Context, key string, value interface{}, timeout time.Duration) (old interface{}, existed bool, err error) {
+	var data interface{}
+	var wrongType bool
+	_, err = s.setIf(ctx, key, value, s.staleAfter, timeout, s.slidingExpiration, true, func(e *entry) bool {
+		if e != nil {
+			if _, wrongType = e.data.(collection); wrongType {
+				return false
+			}
+			// the data of an entry is replaced, never modified, so it can be read once the shard is unlocked
+			data, existed = e.data, true
+		}
+		return true
+	})
+	if wrongType {
+		return nil, false, keyError("get set", key, ErrWrongType)
+	}
+	if err != nil || !existed {
+		return nil, false, err
+	}
+	if old, err = s.valueOf(data); err != nil {
+		return nil, false, keyError("get set", key, err)
+	}
+	return old, true, nil
+}
+
+// GetWithVersion return the value of key and its version, which changes whenever the value is written
+func (s *shardedMapStore) GetWithVersion(ctx context.Context, key string) (value interface{}, version uint64, err error) {
+	sm := s.selectSharedMap(key)
+	if err := sm.lock(ctx); err != nil {
+		return nil, 0, keyError("get", key, err)
+	}
+	e, ok := sm.m[key]
+	if !ok {
+		sm.mu.Unlock()
+		return nil, 0, keyError("get", key, ErrKeyNotFound)
+	}
+	now := s.now()
+	if now > e.deadline {
+		// The key was timeout. Evict it.
+		s.removeEntry(sm, key, e)
+		sm.mu.Unlock()
+		return nil, 0, keyError("get", key, ErrKeyNotFound)
+	}
+	s.recordAccess(sm, e, true)
+	if e.sliding {
+		s.restart(sm, e, now)
+	}
+	data, version := e.data, e.version
+	sm.mu.Unlock()
+
+	if value, err = s.valueOf(data); err != nil {
+		return nil, 0, keyError("get", key, err)
+	}
+	return value, version, nil
+}
+
+func (s *shardedMapStore) Version(ctx context.Context, key string) (uint64, error) {
+	sm := s.selectSharedMap(key)
+	if err := sm.lock(ctx); err != nil {
+		return 0, keyError("version", key, err)
+	}
+	defer sm.mu.Unlock()
+	e, ok := sm.m[key]
+	if !ok || s.now() > e.deadline {
+		return 0, nil
+	}
+	return e.version, nil
+}
+
+// CompareAndSwap set key to value only if its current value is old, as compared by reflect.DeepEqual to what Get
+// returns, and report whether it did. A missing key is never swapped.
+func (s *shardedMapStore) CompareAndSwap(ctx context.Context, key string, old, value interface{}, timeout time.Duration) (bool, error) {
+	var err error
+	swapped, setErr := s.setIf(ctx, key, value, s.staleAfter, timeout, s.slidingExpiration, true, func(e *entry) bool {
+		if e == nil {
+			return false
+		}
+		var current interface{}
+		if current, _, err = s.peek(e.data); err != nil {
+			return false
+		}
+		return reflect.DeepEqual(current, old)
+	})
+	if err != nil {
+		return false, keyError("compare and swap", key, err)
+	}
+	return swapped, setErr
+}
+
+// CompareAndSwapVersion set key to value only if its version is still the one GetWithVersion returned, and report
+// whether it did. A missing key is never swapped.
+func (s *shardedMapStore) CompareAndSwapVersion(ctx context.Context, key string, version uint64, value interface{}, timeout time.Duration) (bool, error) {
+	return s.setIf(ctx, key, value, s.staleAfter, timeout, s.slidingExpiration, true, func(e *entry) bool {
+		return e != nil && e.version == version
+	})
+}
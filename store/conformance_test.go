@@ -0,0 +1,28 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/colindith/kash/store"
+	"github.com/colindith/kash/store/storetest"
+)
+
+func Test_Conformance_ShardedMapStore(t *testing.T) {
+	storetest.Run(t, store.GetShardedMapStore)
+}
+
+func Test_Conformance_ShardedMapStore_LRU(t *testing.T) {
+	storetest.Run(t, func(opts ...store.Option) store.Store {
+		return store.GetShardedMapStore(append([]store.Option{store.SetEvictionPolicy(store.EvictionLRU)}, opts...)...)
+	})
+}
+
+func Test_Conformance_ShardedMapStore_Codec(t *testing.T) {
+	storetest.Run(t, func(opts ...store.Option) store.Store {
+		return store.GetShardedMapStore(append([]store.Option{store.SetCodec(store.BinaryCodec{})}, opts...)...)
+	})
+}
+
+func Test_Conformance_ArenaStore(t *testing.T) {
+	storetest.Run(t, store.GetArenaStore)
+}
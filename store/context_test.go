@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_CtxCanceled(t *testing.T) {
+	s := GetShardedMapStore()
+	_ = s.Set("key", 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := s.SetCtx(ctx, "key", 2); !errors.Is(err, context.Canceled) {
+		t.Errorf("set_should_be_canceled, err: %v", err)
+	}
+	if _, err := s.GetCtx(ctx, "key"); !errors.Is(err, context.Canceled) {
+		t.Errorf("get_should_be_canceled, err: %v", err)
+	}
+	if err := s.IncreaseCtx(ctx, "key"); !errors.Is(err, context.Canceled) {
+		t.Errorf("increase_should_be_canceled, err: %v", err)
+	}
+	if _, err := s.GetTTLCtx(ctx, "key"); !errors.Is(err, context.Canceled) {
+		t.Errorf("get_ttl_should_be_canceled, err: %v", err)
+	}
+	if err := s.DeleteCtx(ctx, "key"); !errors.Is(err, context.Canceled) {
+		t.Errorf("delete_should_be_canceled, err: %v", err)
+	}
+
+	// nothing was applied
+	if v, err := s.Get("key"); err != nil || v != 1 {
+		t.Errorf("canceled_ops_should_not_change_the_store, v: %v, err: %v", v, err)
+	}
+}
+
+func Test_CtxLockWait(t *testing.T) {
+	s := GetShardedMapStore().(*shardedMapStore)
+	_ = s.Set("key", 1)
+
+	// somebody holds the shard for long
+	sm := s.selectSharedMap("key")
+	sm.mu.Lock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.GetCtx(ctx, "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("get_should_give_up_waiting_for_lock, err: %v", err)
+	}
+	sm.mu.Unlock()
+
+	// the abandoned acquisition must not keep the lock
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if v, err := s.GetCtx(ctx, "key"); err != nil || v != 1 {
+		t.Errorf("get_ctx_error, v: %v, err: %v", v, err)
+	}
+}
+
+func Test_CtxLockWait_Acquired(t *testing.T) {
+	s := GetShardedMapStore().(*shardedMapStore)
+	_ = s.Set("key", 1)
+	sm := s.selectSharedMap("key")
+	sm.mu.Lock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		done <- s.SetCtx(ctx, "key", 2)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	sm.mu.Unlock()
+
+	// a waiter which isn't canceled gets the lock once it is released
+	if err := <-done; err != nil {
+		t.Errorf("set_ctx_error, err: %v", err)
+	}
+	if v, _ := s.Get("key"); v != 2 {
+		t.Errorf("set_ctx_should_write, got: %v, want: %v", v, 2)
+	}
+}
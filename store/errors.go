@@ -0,0 +1,37 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The sentinel errors returned by a Store. They are usually wrapped in a *KeyError, compare them with errors.Is.
+var (
+	ErrKeyNotFound     = errors.New("key not found")
+	ErrNotInteger      = errors.New("value is not an integer")
+	ErrNotFloat        = errors.New("value is not a float")
+	ErrOverflow        = errors.New("increment or decrement would overflow")
+	ErrExceedMaxMemory = errors.New("value exceeds max memory")
+	ErrCodec           = errors.New("codec failed")
+	ErrNotInTxn        = errors.New("key not locked by the transaction")
+	ErrWrongType       = errors.New("operation against a key holding the wrong kind of value")
+)
+
+// KeyError record the key an operation failed on
+type KeyError struct {
+	Op  string
+	Key string
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("%s %q: %v", e.Op, e.Key, e.Err)
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+func keyError(op string, key string, err error) error {
+	return &KeyError{Op: op, Key: key, Err: err}
+}
@@ -0,0 +1,27 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_KeyError(t *testing.T) {
+	s := GetShardedMapStore()
+
+	_, err := s.Get("missing")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("should_be_key_not_found, err: %v", err)
+	}
+	var keyErr *KeyError
+	if !errors.As(err, &keyErr) || keyErr.Key != "missing" || keyErr.Op != "get" {
+		t.Errorf("error_should_carry_the_key, err: %#v", err)
+	}
+	if want := "get \"missing\": key not found"; err.Error() != want {
+		t.Errorf("error_message_incorrect, got: %v, want: %v", err.Error(), want)
+	}
+
+	_ = s.Set("text", "abc")
+	if err := s.Increase("text"); !errors.Is(err, ErrNotInteger) {
+		t.Errorf("should_be_not_integer, err: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"container/heap"
+	"time"
+)
+
+const (
+	defaultExpiryInterval = 100 * time.Millisecond
+
+	// expiryBatchSize is the max number of keys removed from a shard before its lock is released
+	expiryBatchSize = 20
+)
+
+// expiryHeap is a min-heap of the entries of a shard ordered by deadline. Entries which never expire are not in it.
+// It is guarded by the shard lock.
+type expiryHeap []*entry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].deadline < h[j].deadline }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].expiryIndex = i + 1
+	h[j].expiryIndex = j + 1
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.expiryIndex = len(*h) + 1
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	e.expiryIndex = 0
+	return e
+}
+
+// schedule keep the position of e in the heap in step with its deadline
+func (h *expiryHeap) schedule(e *entry) {
+	switch {
+	case e.deadline == maxInt64:
+		h.unschedule(e)
+	case e.expiryIndex != 0:
+		heap.Fix(h, e.expiryIndex-1)
+	default:
+		heap.Push(h, e)
+	}
+}
+
+func (h *expiryHeap) unschedule(e *entry) {
+	if e.expiryIndex != 0 {
+		heap.Remove(h, e.expiryIndex-1)
+	}
+}
+
+// expireShard remove at most limit entries whose deadline has passed, like Get does and return how many were removed.
+// The caller must hold the lock of sm.
+func (s *shardedMapStore) expireShard(sm *shardedMap, now int64, limit int) int {
+	removed := 0
+	for removed < limit && len(sm.expiry) > 0 && sm.expiry[0].deadline < now {
+		e := sm.expiry[0]
+		s.removeEntry(sm, e.key, e)
+		removed++
+	}
+	return removed
+}
+
+// expireAll is the janitor scheduled on the store clock every expiryInterval. It walk the shards and remove their expired keys batch by batch, releasing the shard lock in between
+// so that readers and writers are only delayed by one batch at a time
+func (s *shardedMapStore) expireAll() {
+	for i := 0; i < len(s.shardedMaps); i++ {
+		sm := &s.shardedMaps[i]
+		for {
+			sm.mu.Lock()
+			removed := s.expireShard(sm, s.now(), expiryBatchSize)
+			sm.mu.Unlock()
+			if removed < expiryBatchSize {
+				break
+			}
+		}
+		sm.mu.Lock()
+		s.expireHashFields(sm, s.now())
+		sm.mu.Unlock()
+	}
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_expiryHeap(t *testing.T) {
+	var h expiryHeap
+	entries := []*entry{{deadline: 30}, {deadline: 10}, {deadline: maxInt64}, {deadline: 20}, {deadline: 40}}
+	for _, e := range entries {
+		h.schedule(e)
+	}
+	if h.Len() != 4 {
+		t.Errorf("entry_without_deadline_should_not_be_scheduled, len: %v", h.Len())
+	}
+
+	// move a deadline, then drop an entry
+	entries[4].deadline = 5
+	h.schedule(entries[4])
+	h.unschedule(entries[0])
+
+	var got []int64
+	for h.Len() > 0 {
+		got = append(got, heap.Pop(&h).(*entry).deadline)
+	}
+	want := []int64{5, 10, 20}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expiry_heap_order_incorrect, got: %v, want: %v", got, want)
+	}
+	for _, e := range entries {
+		if e.expiryIndex != 0 {
+			t.Errorf("popped_entry_should_have_no_index, deadline: %v, index: %v", e.deadline, e.expiryIndex)
+		}
+	}
+}
+
+func Test_Janitor(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s := GetShardedMapStore(SetExpiryInterval(10*time.Millisecond), SetClock(clock))
+	defer s.Close()
+
+	for i := 0; i < 1000; i++ {
+		_ = s.SetWithTimeout(fmt.Sprintf("write-once-%d", i), i, 20*time.Millisecond)
+	}
+	_ = s.Set("forever", "ever")
+
+	// not due yet
+	clock.Advance(15 * time.Millisecond)
+	if usage := s.GetMemoryUsage(); usage <= entrySize("forever", "ever") {
+		t.Errorf("keys_should_not_be_removed_before_deadline, usage: %v", usage)
+	}
+
+	clock.Advance(10 * time.Millisecond)
+
+	// never read again, yet removed
+	want := "{\"forever\":\"ever\"}"
+	if jsonStr, _ := s.DumpAllJSON(); jsonStr != want {
+		t.Errorf("expired_keys_should_be_removed_by_janitor, got: %v, want: %v", jsonStr, want)
+	}
+	if usage, want := s.GetMemoryUsage(), entrySize("forever", "ever"); usage != want {
+		t.Errorf("memory_usage_incorrect, got: %v, want: %v", usage, want)
+	}
+}
+
+func Test_Janitor_Disabled(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s := GetShardedMapStore(SetExpiryInterval(0), SetClock(clock))
+	_ = s.SetWithTimeout("write-once", 1, time.Millisecond)
+	clock.Advance(20 * time.Millisecond)
+
+	// still stored until something reads it, though the dump skips it
+	if usage, want := s.GetMemoryUsage(), entrySize("write-once", 1); usage != want {
+		t.Errorf("expired_key_should_not_be_removed_without_janitor, got: %v, want: %v", usage, want)
+	}
+	if jsonStr, _ := s.DumpAllJSON(); jsonStr != "{}" {
+		t.Errorf("dump_should_skip_expired_key, got: %v, want: %v", jsonStr, "{}")
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("close_error, err: %v", err)
+	}
+}
+
+func Test_Close_StopJanitor(t *testing.T) {
+	s := GetShardedMapStore(SetExpiryInterval(time.Millisecond))
+	_ = s.SetWithTimeout("key", 1, time.Millisecond)
+	if err := s.Close(); err != nil {
+		t.Errorf("close_error, err: %v", err)
+	}
+	// closing twice is fine
+	if err := s.Close(); err != nil {
+		t.Errorf("close_error, err: %v", err)
+	}
+}
+
+func Test_Janitor_ExpireAndPersist(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s := GetShardedMapStore(SetExpiryInterval(10*time.Millisecond), SetClock(clock))
+	defer s.Close()
+	ctx := context.Background()
+
+	_ = s.Set("shortened", 1)
+	_ = s.SetWithTimeout("persisted", 2, 20*time.Millisecond)
+	_ = s.SetWithSlidingTimeout("sliding", 3, 20*time.Millisecond)
+	_ = s.Expire(ctx, "shortened", 20*time.Millisecond)
+	_, _ = s.Persist(ctx, "persisted")
+	_ = s.Expire(ctx, "sliding", time.Hour)
+
+	clock.Advance(30 * time.Millisecond)
+	want := "{\"persisted\":2,\"sliding\":3}"
+	if jsonStr, _ := s.DumpAllJSON(); jsonStr != want {
+		t.Errorf("janitor_should_follow_new_deadlines, got: %v, want: %v", jsonStr, want)
+	}
+	if usage, want := s.GetMemoryUsage(), entrySize("persisted", 2)+entrySize("sliding", 3); usage != want {
+		t.Errorf("memory_usage_incorrect, got: %v, want: %v", usage, want)
+	}
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FileBackend is a Backend storing each key as a JSON file in a directory. The values are read back the way
+// encoding/json decodes into an interface{}, e.g. numbers as float64. It is meant for tests and small setups.
+type FileBackend struct {
+	dir string
+}
+
+// NewFileBackend return a FileBackend storing its files in dir, creating it if needed
+func NewFileBackend(dir string) (*FileBackend, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileBackend{dir: dir}, nil
+}
+
+// maxFileKeyLen is the longest key named after itself. The file names are commonly limited to 255 bytes, and hex
+// encoding doubles the length.
+const maxFileKeyLen = 100
+
+// path return the file of key. The key is hex encoded, so any key gives a valid file name. A longer key than
+// maxFileKeyLen is named after its SHA-256 instead, which the prefix keeps apart from the hex encoded keys.
+func (b *FileBackend) path(key string) string {
+	name := hex.EncodeToString([]byte(key))
+	if len(key) > maxFileKeyLen {
+		sum := sha256.Sum256([]byte(key))
+		name = "sha256-" + hex.EncodeToString(sum[:])
+	}
+	return filepath.Join(b.dir, name+".json")
+}
+
+func (b *FileBackend) Load(ctx context.Context, key string) (interface{}, error) {
+	data, err := ioutil.ReadFile(b.path(key))
+	if os.IsNotExist(err) {
+		return nil, keyError("load", key, ErrKeyNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Store write the value to a temporary file first and rename it, so a crash never leaves a partial file
+func (b *FileBackend) Store(ctx context.Context, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(b.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), b.path(key))
+}
+
+func (b *FileBackend) Delete(ctx context.Context, key string) error {
+	err := os.Remove(b.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"hash/maphash"
+	"math/bits"
+)
+
+// Hasher map a key to the 64 bits hash picking its shard. The shard is chosen by the low bits of the hash, and
+// the arena store indexes its entries by the whole hash, so every bit should depend on the whole key.
+type Hasher func(key string) uint64
+
+// FNVHasher is the default Hasher, a 64 bits FNV-1. It is fast on short keys but predictable: keys can be crafted
+// to land in the same shard.
+func FNVHasher(key string) uint64 {
+	return fnv64(key)
+}
+
+// vars rather than consts, the additions and negations of xxHash64 overflow on purpose
+var (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// XXHasher is xxHash64 with a zero seed. It mixes better than FNVHasher and is faster on long keys.
+func XXHasher(key string) uint64 {
+	n := len(key)
+	i := 0
+	var h uint64
+	if n >= 32 {
+		v1 := xxPrime1 + xxPrime2
+		v2 := xxPrime2
+		v3 := uint64(0)
+		v4 := -xxPrime1
+		for ; i+32 <= n; i += 32 {
+			v1 = xxRound(v1, le64(key[i:]))
+			v2 = xxRound(v2, le64(key[i+8:]))
+			v3 = xxRound(v3, le64(key[i+16:]))
+			v4 = xxRound(v4, le64(key[i+24:]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMerge(h, v1)
+		h = xxMerge(h, v2)
+		h = xxMerge(h, v3)
+		h = xxMerge(h, v4)
+	} else {
+		h = xxPrime5
+	}
+	h += uint64(n)
+
+	for ; i+8 <= n; i += 8 {
+		h ^= xxRound(0, le64(key[i:]))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if i+4 <= n {
+		h ^= uint64(le32(key[i:])) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		i += 4
+	}
+	for ; i < n; i++ {
+		h ^= uint64(key[i]) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	return bits.RotateLeft64(acc, 31) * xxPrime1
+}
+
+func xxMerge(acc, v uint64) uint64 {
+	acc ^= xxRound(0, v)
+	return acc*xxPrime1 + xxPrime4
+}
+
+func le64(s string) uint64 {
+	return uint64(s[0]) | uint64(s[1])<<8 | uint64(s[2])<<16 | uint64(s[3])<<24 |
+		uint64(s[4])<<32 | uint64(s[5])<<40 | uint64(s[6])<<48 | uint64(s[7])<<56
+}
+
+func le32(s string) uint32 {
+	return uint32(s[0]) | uint32(s[1])<<8 | uint32(s[2])<<16 | uint32(s[3])<<24
+}
+
+// mapHashSeed is drawn once per process
+var mapHashSeed = maphash.MakeSeed()
+
+// MapHasher hash with hash/maphash and a random seed drawn when the process starts. The keys sent by the clients
+// of a server can't be crafted to collide, at the price of hashes changing from one process to the next.
+func MapHasher(key string) uint64 {
+	var h maphash.Hash
+	h.SetSeed(mapHashSeed)
+	_, _ = h.WriteString(key)
+	return h.Sum64()
+}
+
+// roundShardCount round n up to a power of two, so a shard is picked by masking the hash
+func roundShardCount(n int) int {
+	count := 1
+	for count < n {
+		count <<= 1
+	}
+	return count
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func Test_XXHasher(t *testing.T) {
+	// reference values of xxHash64 with a zero seed
+	tests := []struct {
+		key  string
+		want uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+		{"Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1},
+	}
+	for _, tt := range tests {
+		if got := XXHasher(tt.key); got != tt.want {
+			t.Errorf("xxhash_incorrect, key: %q, got: %#x, want: %#x", tt.key, got, tt.want)
+		}
+	}
+}
+
+func Test_MapHasher(t *testing.T) {
+	if MapHasher("kash") != MapHasher("kash") {
+		t.Errorf("map_hasher_should_be_deterministic_in_a_process")
+	}
+	if MapHasher("kash") == MapHasher("hsak") {
+		t.Errorf("map_hasher_should_differ_between_keys")
+	}
+}
+
+func Test_roundShardCount(t *testing.T) {
+	tests := []struct{ n, want int }{{1, 1}, {2, 2}, {3, 4}, {96, 128}, {128, 128}}
+	for _, tt := range tests {
+		if got := roundShardCount(tt.n); got != tt.want {
+			t.Errorf("round_shard_count_incorrect, n: %v, got: %v, want: %v", tt.n, got, tt.want)
+		}
+	}
+}
+
+func Test_SetShardCount(t *testing.T) {
+	for _, hasher := range []Hasher{FNVHasher, XXHasher, MapHasher} {
+		s := GetShardedMapStore(SetShardCount(96), SetHasher(hasher)).(*shardedMapStore)
+		if len(s.shardedMaps) != 128 {
+			t.Errorf("shard_count_incorrect, got: %v, want: %v", len(s.shardedMaps), 128)
+		}
+		for i := 0; i < 10000; i++ {
+			_ = s.Set(fmt.Sprint(i), i)
+		}
+		// the keys are spread over every shard
+		for i := range s.shardedMaps {
+			if n := len(s.shardedMaps[i].m); n < 10000/128/3 {
+				t.Errorf("shard_unbalanced, shard: %v, keys: %v", i, n)
+			}
+		}
+		if v, _ := s.Get("42"); v != 42 {
+			t.Errorf("get_incorrect, got: %v, want: %v", v, 42)
+		}
+
+		a := GetArenaStore(SetShardCount(3), SetHasher(hasher)).(*arenaStore)
+		if len(a.shards) != 4 {
+			t.Errorf("arena_shard_count_incorrect, got: %v, want: %v", len(a.shards), 4)
+		}
+		_ = a.Set("k", "v")
+		if v, _ := a.Get("k"); v != "v" {
+			t.Errorf("arena_get_incorrect, got: %v, want: %v", v, "v")
+		}
+	}
+}
+
+func Benchmark_Hashers(b *testing.B) {
+	hashers := []struct {
+		name string
+		hash Hasher
+	}{
+		{"fnv", FNVHasher}, {"xxhash", XXHasher}, {"maphash", MapHasher},
+	}
+	for _, size := range []int{8, 64, 512} {
+		key := strings.Repeat("k", size)
+		for _, h := range hashers {
+			b.Run(fmt.Sprintf("%s-%d", h.name, size), func(b *testing.B) {
+				b.SetBytes(int64(size))
+				for i := 0; i < b.N; i++ {
+					h.hash(key)
+				}
+			})
+		}
+	}
+}
+
+// Benchmark_ShardContention show how the shard count affects the contention on the shard locks when every core
+// reads and writes the store
+func Benchmark_ShardContention(b *testing.B) {
+	keys := make([]string, 1<<14)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+	for _, n := range []int{1, 8, 32, 128, 512} {
+		b.Run(fmt.Sprintf("shards-%d", n), func(b *testing.B) {
+			s := GetShardedMapStore(SetShardCount(n), SetExpiryInterval(0))
+			for _, key := range keys {
+				_ = s.Set(key, key)
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					key := keys[i&(len(keys)-1)]
+					if i%4 == 0 {
+						_ = s.Set(key, key)
+					} else {
+						_, _ = s.Get(key)
+					}
+					i += 7
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,394 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+var errCorruptHash = errors.New("corrupt hash")
+
+// hashField is a field of a hash and its own deadline
+type hashField struct {
+	value    interface{}
+	deadline int64 // timestamp nanosecond. maxInt64 means the field doesn't expire.
+}
+
+// hashValue is the value of a hash key. The expired fields are skipped by the reads, and removed by the next write
+// of the hash or by the janitor, which delete its key if none is left. A read finding every field expired deletes
+// the key too.
+type hashValue struct {
+	fields   map[string]hashField
+	expiring int   // the number of fields with a deadline
+	next     int64 // the earliest deadline of a field, maxInt64 if none. It may be earlier after a del.
+	size     int64 // estimated bytes used by the fields
+}
+
+var (
+	hashValueOverhead = int64(unsafe.Sizeof(hashValue{}))
+	hashFieldOverhead = int64(unsafe.Sizeof(hashField{})) + mapSlotOverhead
+)
+
+func newHashValue() *hashValue {
+	return &hashValue{fields: make(map[string]hashField), next: maxInt64}
+}
+
+func fieldSize(field string, value interface{}) int64 {
+	return hashFieldOverhead + int64(len(field)) + sizeOf(value)
+}
+
+// fieldDeadline return the deadline of a field set with timeout, 0 meaning no timeout
+func fieldDeadline(now int64, timeout time.Duration) int64 {
+	if timeout == 0 {
+		return maxInt64
+	}
+	return now + int64(timeout)
+}
+
+func (h *hashValue) kind() collectionKind {
+	return hashKind
+}
+
+// len return the number of fields, the expired ones not removed yet included, see count
+func (h *hashValue) len() int {
+	return len(h.fields)
+}
+
+func (h *hashValue) footprint() int64 {
+	return hashValueOverhead + h.size
+}
+
+func (h *hashValue) snapshot(now int64) interface{} {
+	return h.all(now)
+}
+
+func (h *hashValue) clone() collection {
+	c := &hashValue{fields: make(map[string]hashField, len(h.fields)), expiring: h.expiring, next: h.next, size: h.size}
+	for field, f := range h.fields {
+		c.fields[field] = f
+	}
+	return c
+}
+
+func (h *hashValue) get(field string, now int64) (interface{}, bool) {
+	f, ok := h.fields[field]
+	if !ok || now > f.deadline {
+		return nil, false
+	}
+	return f.value, true
+}
+
+func (h *hashValue) set(field string, value interface{}, deadline int64) {
+	h.del(field)
+	h.fields[field] = hashField{value: value, deadline: deadline}
+	h.size += fieldSize(field, value)
+	if deadline != maxInt64 {
+		h.expiring++
+	}
+	if deadline < h.next {
+		h.next = deadline
+	}
+}
+
+func (h *hashValue) del(field string) {
+	f, ok := h.fields[field]
+	if !ok {
+		return
+	}
+	delete(h.fields, field)
+	h.size -= fieldSize(field, f.value)
+	if f.deadline != maxInt64 {
+		h.expiring--
+	}
+}
+
+// purge remove the expired fields
+func (h *hashValue) purge(now int64) {
+	if now <= h.next {
+		return
+	}
+	h.next = maxInt64
+	for field, f := range h.fields {
+		if now > f.deadline {
+			h.del(field)
+		} else if f.deadline < h.next {
+			h.next = f.deadline
+		}
+	}
+}
+
+// expired report whether the hash had fields and all of them expired
+func (h *hashValue) expired(now int64) bool {
+	return len(h.fields) > 0 && h.count(now) == 0
+}
+
+// count return the number of fields which didn't expire
+func (h *hashValue) count(now int64) int {
+	if h.expiring == 0 {
+		return len(h.fields)
+	}
+	n := 0
+	for _, f := range h.fields {
+		if now <= f.deadline {
+			n++
+		}
+	}
+	return n
+}
+
+// all return the fields which didn't expire
+func (h *hashValue) all(now int64) map[string]interface{} {
+	values := make(map[string]interface{}, len(h.fields))
+	for field, f := range h.fields {
+		if now <= f.deadline {
+			values[field] = f.value
+		}
+	}
+	return values
+}
+
+// encodeHash encode the fields of a hash for the arena store: the count, then the name, the deadline, the flags,
+// the length and the bytes of each field value encoded with encode
+func encodeHash(h *hashValue, encode func(value interface{}) ([]byte, uint8, error)) ([]byte, error) {
+	buf := make([]byte, 0, binary.MaxVarintLen64+int(h.size))
+	buf = putUvarint(buf, uint64(len(h.fields)))
+	for field, f := range h.fields {
+		data, flags, err := encode(f.value)
+		if err != nil {
+			return nil, err
+		}
+		buf = putUvarint(buf, uint64(len(field)))
+		buf = append(buf, field...)
+		var b [binary.MaxVarintLen64]byte
+		buf = append(buf, b[:binary.PutVarint(b[:], f.deadline)]...)
+		buf = append(buf, flags)
+		buf = putUvarint(buf, uint64(len(data)))
+		buf = append(buf, data...)
+	}
+	return buf, nil
+}
+
+// decodeHash decode what encodeHash encoded
+func decodeHash(data []byte, decode func(data []byte, flags uint8) (interface{}, error)) (*hashValue, error) {
+	corrupt := &codecError{errCorruptHash}
+	n, read := binary.Uvarint(data)
+	if read <= 0 {
+		return nil, corrupt
+	}
+	data = data[read:]
+	h := newHashValue()
+	// next return the bytes prefixed by their length
+	next := func() ([]byte, bool) {
+		size, read := binary.Uvarint(data)
+		if read <= 0 || uint64(len(data)-read) < size {
+			return nil, false
+		}
+		b := data[read : read+int(size) : read+int(size)]
+		data = data[read+int(size):]
+		return b, true
+	}
+	for i := uint64(0); i < n; i++ {
+		field, ok := next()
+		if !ok {
+			return nil, corrupt
+		}
+		deadline, read := binary.Varint(data)
+		if read <= 0 || len(data) == read {
+			return nil, corrupt
+		}
+		flags := data[read]
+		data = data[read+1:]
+		encoded, ok := next()
+		if !ok {
+			return nil, corrupt
+		}
+		value, err := decode(encoded, flags)
+		if err != nil {
+			return nil, err
+		}
+		h.set(string(field), value, deadline)
+	}
+	return h, nil
+}
+
+// HSet set the fields of the hash at key, and return how many didn't exist. The fields set lose their timeout.
+// A missing key is created with the default timeout.
+func (s *shardedMapStore) HSet(ctx context.Context, key string, values map[string]interface{}) (int, error) {
+	return s.hset(ctx, "hset", key, values, 0)
+}
+
+// HSetWithTimeout is HSet with the fields expiring after timeout, whatever the timeout of the key and of the other
+// fields. A timeout of 0 means the fields don't expire.
+func (s *shardedMapStore) HSetWithTimeout(ctx context.Context, key string, values map[string]interface{}, timeout time.Duration) (int, error) {
+	return s.hset(ctx, "hset", key, values, timeout)
+}
+
+func (s *shardedMapStore) hset(ctx context.Context, op, key string, values map[string]interface{}, timeout time.Duration) (int, error) {
+	var created int
+	err := s.updateHash(ctx, op, key, true, func(h *hashValue, now int64) error {
+		deadline := fieldDeadline(now, timeout)
+		for field, value := range values {
+			if _, live := h.get(field, now); !live {
+				created++
+			}
+			h.set(field, s.cloneOnSet(value), deadline)
+		}
+		return nil
+	})
+	return created, err
+}
+
+// HGet return the value of a field of the hash at key. ErrKeyNotFound is returned if the key or the field is
+// missing.
+func (s *shardedMapStore) HGet(ctx context.Context, key, field string) (interface{}, error) {
+	var value interface{}
+	var found bool
+	err := s.viewHash(ctx, "hget", key, func(h *hashValue, now int64) {
+		value, found = h.get(field, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, keyError("hget", key, ErrKeyNotFound)
+	}
+	return s.cloneOnGet(value), nil
+}
+
+// HDel delete fields of the hash at key, and return how many existed. The key is deleted with its last field.
+func (s *shardedMapStore) HDel(ctx context.Context, key string, fields ...string) (int, error) {
+	var deleted int
+	err := s.updateHash(ctx, "hdel", key, false, func(h *hashValue, now int64) error {
+		for _, field := range fields {
+			if _, live := h.get(field, now); live {
+				deleted++
+			}
+			h.del(field)
+		}
+		return nil
+	})
+	if errors.Is(err, ErrKeyNotFound) {
+		return 0, nil
+	}
+	return deleted, err
+}
+
+// HGetAll return the fields of the hash at key, none if it is missing
+func (s *shardedMapStore) HGetAll(ctx context.Context, key string) (map[string]interface{}, error) {
+	var values map[string]interface{}
+	err := s.viewHash(ctx, "hgetall", key, func(h *hashValue, now int64) {
+		values = h.all(now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for field, value := range values {
+		values[field] = s.cloneOnGet(value)
+	}
+	return values, nil
+}
+
+// HIncrBy add delta to the integer held by a field of the hash at key and return the result, see IncrBy. A missing
+// field is set to delta, and a field with a timeout keeps it.
+func (s *shardedMapStore) HIncrBy(ctx context.Context, key, field string, delta int64) (int64, error) {
+	var n int64
+	err := s.updateHash(ctx, "hincr by", key, true, func(h *hashValue, now int64) error {
+		current, ok := h.get(field, now)
+		if !ok {
+			n = delta
+			h.set(field, int(delta), maxInt64)
+			return nil
+		}
+		value, sum, err := incrementBy(current, delta)
+		if err != nil {
+			return err
+		}
+		n = sum
+		h.set(field, value, h.fields[field].deadline)
+		return nil
+	})
+	return n, err
+}
+
+// HExists report whether a field of the hash at key exists
+func (s *shardedMapStore) HExists(ctx context.Context, key, field string) (bool, error) {
+	var found bool
+	err := s.viewHash(ctx, "hexists", key, func(h *hashValue, now int64) {
+		_, found = h.get(field, now)
+	})
+	return found, err
+}
+
+// HLen return the number of fields of the hash at key, 0 if it is missing
+func (s *shardedMapStore) HLen(ctx context.Context, key string) (int, error) {
+	var n int
+	err := s.viewHash(ctx, "hlen", key, func(h *hashValue, now int64) {
+		n = h.count(now)
+	})
+	return n, err
+}
+
+// expireHashFields remove the expired fields of the hashes of sm, and the keys of the hashes left empty. It is run
+// by the janitor. The caller must hold the lock of sm.
+func (s *shardedMapStore) expireHashFields(sm *shardedMap, now int64) {
+	for key, e := range sm.expiringHashes {
+		h, isHash := e.data.(*hashValue)
+		if sm.m[key] != e || !isHash {
+			// overwritten by another value
+			delete(sm.expiringHashes, key)
+			continue
+		}
+		if now <= h.next {
+			continue
+		}
+		h.purge(now)
+		if h.len() == 0 {
+			s.removeEntry(sm, key, e)
+			continue
+		}
+		size := entrySize(key, h)
+		atomic.AddInt64(&sm.memUsage, size-e.size)
+		e.size = size
+		s.trackHash(sm, key, e)
+	}
+}
+
+// trackHash keep the entry of key in the hashes expireHashFields visits if it is a hash with fields expiring.
+// The caller must hold the lock of sm.
+func (s *shardedMapStore) trackHash(sm *shardedMap, key string, e *entry) {
+	if h, isHash := e.data.(*hashValue); isHash && h.next != maxInt64 {
+		if sm.expiringHashes == nil {
+			sm.expiringHashes = make(map[string]*entry)
+		}
+		sm.expiringHashes[key] = e
+		return
+	}
+	delete(sm.expiringHashes, key)
+}
+
+// viewHash call fn with the hash at key under the shard lock. A missing key is an empty hash.
+func (s *shardedMapStore) viewHash(ctx context.Context, op, key string, fn func(h *hashValue, now int64)) error {
+	return s.viewCollection(ctx, op, key, hashKind, func(c collection, now int64) {
+		h, _ := c.(*hashValue)
+		if h == nil {
+			h = newHashValue()
+		}
+		fn(h, now)
+	})
+}
+
+// updateHash call fn with the hash at key under the shard lock, see updateCollection. The expired fields are
+// removed once fn succeeded.
+func (s *shardedMapStore) updateHash(ctx context.Context, op, key string, create bool, fn func(h *hashValue, now int64) error) error {
+	return s.updateCollection(ctx, op, key, hashKind, create, func(c collection, now int64) error {
+		h := c.(*hashValue)
+		if err := fn(h, now); err != nil {
+			return err
+		}
+		h.purge(now)
+		return nil
+	})
+}
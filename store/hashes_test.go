@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_encodeHash(t *testing.T) {
+	s := GetArenaStore().(*arenaStore)
+	h := newHashValue()
+	h.set("raw", []byte("bytes"), maxInt64)
+	h.set("string", "value", 1234)
+	h.set("int", 42, maxInt64)
+	data, err := encodeHash(h, s.encode)
+	if err != nil {
+		t.Errorf("encode_hash_error, err: %v", err)
+	}
+	decoded, err := decodeHash(data, s.decode)
+	if err != nil {
+		t.Errorf("decode_hash_error, err: %v", err)
+	}
+	if !reflect.DeepEqual(decoded.fields, h.fields) {
+		t.Errorf("decoded_hash_incorrect, got: %v, want: %v", decoded.fields, h.fields)
+	}
+	if decoded.size != h.size || decoded.expiring != 1 {
+		t.Errorf("decoded_hash_accounting_incorrect, size: %v, want: %v, expiring: %v", decoded.size, h.size, decoded.expiring)
+	}
+	if _, err := decodeHash(data[:len(data)-1], s.decode); err == nil {
+		t.Errorf("truncated_hash_should_fail_to_decode")
+	}
+}
+
+func Test_Hash_JanitorExpiresFields(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s := GetShardedMapStore(SetClock(clock), SetExpiryInterval(time.Second)).(*shardedMapStore)
+	ctx := context.Background()
+	_, _ = s.HSet(ctx, "kept", map[string]interface{}{"a": "0123456789"})
+	small := s.GetMemoryUsage()
+	_, _ = s.HSetWithTimeout(ctx, "kept", map[string]interface{}{"b": "0123456789"}, time.Second)
+	_, _ = s.HSetWithTimeout(ctx, "gone", map[string]interface{}{"c": "0123456789"}, time.Second)
+
+	// nothing reads nor writes the hashes
+	clock.Advance(2 * time.Second)
+	if usage := s.GetMemoryUsage(); usage != small {
+		t.Errorf("janitor_should_free_expired_fields, got: %v, want: %v", usage, small)
+	}
+	if n := atomic.LoadInt64(&s.length); n != 1 {
+		t.Errorf("janitor_should_delete_hash_without_live_field, got: %v, want: %v", n, 1)
+	}
+}
+
+func Test_Hash_MemoryUsage(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s := GetShardedMapStore(SetClock(clock))
+	ctx := context.Background()
+	_, _ = s.HSet(ctx, "hash", map[string]interface{}{"a": "0123456789"})
+	small := s.GetMemoryUsage()
+	_, _ = s.HSetWithTimeout(ctx, "hash", map[string]interface{}{"b": "0123456789", "c": "0123456789"}, time.Second)
+	if usage := s.GetMemoryUsage(); usage < small+2*20 {
+		t.Errorf("fields_should_be_accounted, got: %v, want more than: %v", usage, small+2*20)
+	}
+
+	// the expired fields are released by the next write
+	clock.Advance(2 * time.Second)
+	_, _ = s.HIncrBy(ctx, "hash", "n", 1)
+	_, _ = s.HDel(ctx, "hash", "n")
+	if usage := s.GetMemoryUsage(); usage != small {
+		t.Errorf("expired_fields_should_free_memory, got: %v, want: %v", usage, small)
+	}
+	_, _ = s.HDel(ctx, "hash", "a")
+	if usage := s.GetMemoryUsage(); usage != 0 {
+		t.Errorf("deleted_hash_should_free_memory, got: %v, want: %v", usage, 0)
+	}
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultLFULogFactor = 10
+	defaultLFUDecayTime = time.Minute
+
+	// lfuInitFreq is the counter of a new key. It is not zero so a new key has a chance to survive
+	// long enough to collect accesses.
+	lfuInitFreq = 5
+	lfuMaxFreq  = 255
+)
+
+// lfuTouch record an access on e. The caller must hold the lock of the entry's shard.
+func (s *shardedMapStore) lfuTouch(e *entry, existed bool) {
+	now := s.now()
+	if !existed {
+		e.freq = lfuInitFreq
+		e.accessTime = now
+		return
+	}
+	e.freq = s.lfuLogIncr(s.lfuDecayedFreq(e, now))
+	e.accessTime = now
+}
+
+// lfuDecayedFreq return the counter of e after removing one point for every decay period since the last access
+func (s *shardedMapStore) lfuDecayedFreq(e *entry, now int64) uint8 {
+	if s.lfuDecayTime <= 0 {
+		return e.freq
+	}
+	periods := (now - e.accessTime) / int64(s.lfuDecayTime)
+	if periods >= int64(e.freq) {
+		return 0
+	}
+	return e.freq - uint8(periods)
+}
+
+// lfuLogIncr increment the counter logarithmically: the higher the counter, the less likely it grows
+func (s *shardedMapStore) lfuLogIncr(freq uint8) uint8 {
+	if freq == lfuMaxFreq {
+		return freq
+	}
+	base := float64(freq) - lfuInitFreq
+	if base < 0 {
+		base = 0
+	}
+	if rand.Float64() < 1.0/(base*float64(s.lfuLogFactor)+1) {
+		freq++
+	}
+	return freq
+}
+
+// lfuEvict sample up to evictionSamples entries across the shards, starting from a random one, and evict the
+// entry with the lowest decayed counter. An expired entry is evicted right away. Only one shard lock is held at
+// a time, so the victim is checked again before it is removed.
+func (s *shardedMapStore) lfuEvict(keep string) bool {
+	now := s.now()
+	start := rand.Intn(len(s.shardedMaps))
+
+	var victimShard *shardedMap
+	var victimKey string
+	var victim *entry
+	var victimFreq uint8
+	sampled := 0
+	for i := 0; i < len(s.shardedMaps) && sampled < s.evictionSamples; i++ {
+		sm := &s.shardedMaps[(start+i)%len(s.shardedMaps)]
+		sm.mu.Lock()
+		for k, e := range sm.m {
+			if k == keep {
+				continue
+			}
+			if e.deadline <= now {
+				s.removeEntry(sm, k, e)
+				sm.mu.Unlock()
+				return true
+			}
+			if freq := s.lfuDecayedFreq(e, now); victim == nil || freq < victimFreq {
+				victimShard, victimKey, victim, victimFreq = sm, k, e, freq
+			}
+			sampled++
+			if sampled >= s.evictionSamples {
+				break
+			}
+		}
+		sm.mu.Unlock()
+	}
+
+	if victim == nil {
+		// keep is the only key left
+		if keep == "" {
+			return false
+		}
+		return s.lfuEvict("")
+	}
+
+	victimShard.mu.Lock()
+	if e, ok := victimShard.m[victimKey]; ok && e == victim {
+		s.removeEntry(victimShard, victimKey, e)
+	}
+	victimShard.mu.Unlock()
+	return true
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_LFU_ScanResistance(t *testing.T) {
+	s := GetShardedMapStore(SetCapacity(10), SetEvictionPolicy(EvictionLFU), SetLFULogFactor(0),
+		SetEvictionSamples(100))
+
+	for i := 0; i < 8; i++ {
+		_ = s.Set(fmt.Sprintf("hot-%d", i), i)
+	}
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 8; i++ {
+			_, _ = s.Get(fmt.Sprintf("hot-%d", i))
+		}
+	}
+
+	// A scan of cold keys should only evict keys of the scan
+	for i := 0; i < 1000; i++ {
+		_ = s.Set(fmt.Sprintf("cold-%d", i), i)
+	}
+
+	for i := 0; i < 8; i++ {
+		if _, err := s.Get(fmt.Sprintf("hot-%d", i)); err != nil {
+			t.Errorf("hot_key_should_survive_scan, key: hot-%d, err: %v", i, err)
+		}
+	}
+	if _, err := s.Get("cold-999"); err != nil {
+		t.Errorf("key_just_set_should_not_be_evicted, err: %v", err)
+	}
+}
+
+func Test_LFU_Decay(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s := GetShardedMapStore(SetCapacity(2), SetEvictionPolicy(EvictionLFU), SetLFULogFactor(0),
+		SetLFUDecayTime(time.Millisecond), SetClock(clock))
+
+	_ = s.Set("formerly-hot", 1)
+	for i := 0; i < 20; i++ {
+		_, _ = s.Get("formerly-hot")
+	}
+	clock.Advance(50 * time.Millisecond)
+
+	// recently used a few times, while formerly-hot has decayed to zero
+	_ = s.Set("warm", 2)
+	_, _ = s.Get("warm")
+
+	_ = s.Set("new", 3)
+	if _, err := s.Get("formerly-hot"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("decayed_key_should_be_evicted, err: %v", err)
+	}
+	if _, err := s.Get("warm"); err != nil {
+		t.Errorf("warm_key_should_not_be_evicted, err: %v", err)
+	}
+}
+
+func Test_LFU_MaxMemory(t *testing.T) {
+	value := make([]byte, 1000)
+	limit := 4 * entrySize("key-00", value)
+	s := GetShardedMapStore(SetMaxMemory(fmt.Sprintf("%dB", limit)), SetEvictionPolicy(EvictionLFU))
+	for i := 0; i < 50; i++ {
+		_ = s.Set(fmt.Sprintf("key-%02d", i), value)
+		if usage := s.GetMemoryUsage(); usage > limit {
+			t.Fatalf("memory_usage_exceed_limit, got: %v, limit: %v", usage, limit)
+		}
+	}
+}
+
+func Test_lfuLogIncr(t *testing.T) {
+	s := &shardedMapStore{lfuLogFactor: defaultLFULogFactor}
+	freq := uint8(lfuInitFreq)
+	for i := 0; i < 1000; i++ {
+		freq = s.lfuLogIncr(freq)
+	}
+	// With the default factor, a thousand accesses only move the counter by a handful of points
+	if freq <= lfuInitFreq || freq > 30 {
+		t.Errorf("lfu_log_incr_out_of_range, got: %v", freq)
+	}
+	if got := s.lfuLogIncr(lfuMaxFreq); got != lfuMaxFreq {
+		t.Errorf("lfu_counter_should_saturate, got: %v", got)
+	}
+}
@@ -0,0 +1,49 @@
+package store
+
+// entryList is an intrusive doubly linked list threaded through entry.prev and entry.next.
+// An entry can only be in one list at a time. The list does no locking itself.
+type entryList struct {
+	head *entry
+	tail *entry
+	len  int
+}
+
+func (l *entryList) pushFront(e *entry) {
+	e.prev = nil
+	e.next = l.head
+	if l.head != nil {
+		l.head.prev = e
+	} else {
+		l.tail = e
+	}
+	l.head = e
+	l.len++
+}
+
+func (l *entryList) remove(e *entry) {
+	if e.prev != nil {
+		e.prev.next = e.next
+	} else {
+		l.head = e.next
+	}
+	if e.next != nil {
+		e.next.prev = e.prev
+	} else {
+		l.tail = e.prev
+	}
+	e.prev, e.next = nil, nil
+	l.len--
+}
+
+func (l *entryList) moveToFront(e *entry) {
+	if l.head == e {
+		return
+	}
+	l.remove(e)
+	l.pushFront(e)
+}
+
+// back return the least recently pushed entry, or nil when the list is empty
+func (l *entryList) back() *entry {
+	return l.tail
+}
@@ -0,0 +1,327 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"unsafe"
+)
+
+var errCorruptList = errors.New("corrupt list")
+
+// listValue is the value of a list key, a ring buffer of its elements. The sharded map store keeps it in the entry
+// and modifies it in place under the shard lock, the arena store encodes it with encodeList.
+type listValue struct {
+	items []interface{}
+	head  int // the index of the first element in items
+	n     int
+	size  int64 // estimated bytes referenced by the elements
+}
+
+var listValueOverhead = int64(unsafe.Sizeof(listValue{}))
+
+func (l *listValue) len() int {
+	return l.n
+}
+
+// at return the element at index i, 0 being the head
+func (l *listValue) at(i int) interface{} {
+	return l.items[(l.head+i)%len(l.items)]
+}
+
+func (l *listValue) grow() {
+	if l.n < len(l.items) {
+		return
+	}
+	items := make([]interface{}, 2*len(l.items)+4)
+	for i := 0; i < l.n; i++ {
+		items[i] = l.at(i)
+	}
+	l.items, l.head = items, 0
+}
+
+func (l *listValue) pushFront(value interface{}) {
+	l.grow()
+	l.head = (l.head + len(l.items) - 1) % len(l.items)
+	l.items[l.head] = value
+	l.n++
+	l.size += sizeOf(value)
+}
+
+func (l *listValue) pushBack(value interface{}) {
+	l.grow()
+	l.items[(l.head+l.n)%len(l.items)] = value
+	l.n++
+	l.size += sizeOf(value)
+}
+
+// popFront remove and return the head element, the list must not be empty
+func (l *listValue) popFront() interface{} {
+	value := l.items[l.head]
+	l.items[l.head] = nil
+	l.head = (l.head + 1) % len(l.items)
+	l.n--
+	l.size -= sizeOf(value)
+	return value
+}
+
+// popBack remove and return the tail element, the list must not be empty
+func (l *listValue) popBack() interface{} {
+	i := (l.head + l.n - 1) % len(l.items)
+	value := l.items[i]
+	l.items[i] = nil
+	l.n--
+	l.size -= sizeOf(value)
+	return value
+}
+
+// slice return the elements from start to stop included, see listRange
+func (l *listValue) slice(start, stop int) []interface{} {
+	start, stop = listRange(start, stop, l.n)
+	values := make([]interface{}, 0, stop-start)
+	for i := start; i < stop; i++ {
+		values = append(values, l.at(i))
+	}
+	return values
+}
+
+// trim keep only the elements from start to stop included, see listRange
+func (l *listValue) trim(start, stop int) {
+	start, stop = listRange(start, stop, l.n)
+	for l.n > stop {
+		l.popBack()
+	}
+	for i := 0; i < start; i++ {
+		l.popFront()
+	}
+}
+
+func (l *listValue) kind() collectionKind {
+	return listKind
+}
+
+func (l *listValue) footprint() int64 {
+	return listValueOverhead + int64(cap(l.items))*interfaceSize + l.size
+}
+
+func (l *listValue) snapshot(now int64) interface{} {
+	return l.slice(0, -1)
+}
+
+// clone return a copy of the list sharing the elements
+func (l *listValue) clone() collection {
+	c := &listValue{items: make([]interface{}, l.n), n: l.n, size: l.size}
+	for i := range c.items {
+		c.items[i] = l.at(i)
+	}
+	return c
+}
+
+// listRange turn the inclusive start and stop indexes of LRANGE and LTRIM into the bounds of a slice of a list of
+// n elements. Negative indexes count from the tail, -1 being the last element, and the out of range indexes are
+// clamped.
+func listRange(start, stop, n int) (int, int) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return 0, 0
+	}
+	return start, stop + 1
+}
+
+// listIndex turn the index of LINDEX into a position in a list of n elements, false if it is out of range
+func listIndex(index, n int) (int, bool) {
+	if index < 0 {
+		index += n
+	}
+	return index, index >= 0 && index < n
+}
+
+// encodeList encode the elements of a list for the arena store: the count, then the flags, the length and the bytes
+// of each element encoded with encode
+func encodeList(l *listValue, encode func(value interface{}) ([]byte, uint8, error)) ([]byte, error) {
+	buf := make([]byte, 0, binary.MaxVarintLen64+int(l.size)+l.n*(1+binary.MaxVarintLen32))
+	buf = putUvarint(buf, uint64(l.n))
+	for i := 0; i < l.n; i++ {
+		data, flags, err := encode(l.at(i))
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, flags)
+		buf = putUvarint(buf, uint64(len(data)))
+		buf = append(buf, data...)
+	}
+	return buf, nil
+}
+
+// decodeList decode what encodeList encoded
+func decodeList(data []byte, decode func(data []byte, flags uint8) (interface{}, error)) (*listValue, error) {
+	n, read := binary.Uvarint(data)
+	if read <= 0 {
+		return nil, &codecError{errCorruptList}
+	}
+	data = data[read:]
+	l := &listValue{items: make([]interface{}, 0, n)}
+	for i := uint64(0); i < n; i++ {
+		if len(data) == 0 {
+			return nil, &codecError{errCorruptList}
+		}
+		flags := data[0]
+		size, read := binary.Uvarint(data[1:])
+		if read <= 0 || uint64(len(data)-1-read) < size {
+			return nil, &codecError{errCorruptList}
+		}
+		data = data[1+read:]
+		value, err := decode(data[:size:size], flags)
+		if err != nil {
+			return nil, err
+		}
+		data = data[size:]
+		l.items = append(l.items, value)
+		l.size += sizeOf(value)
+	}
+	l.n = len(l.items)
+	return l, nil
+}
+
+// listLen return the count encodeList put first
+func listLen(data []byte) (int, error) {
+	n, read := binary.Uvarint(data)
+	if read <= 0 {
+		return 0, &codecError{errCorruptList}
+	}
+	return int(n), nil
+}
+
+func putUvarint(buf []byte, x uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutUvarint(b[:], x)]...)
+}
+
+// LPush insert values at the head of the list at key, one after the other, and return the length of the list.
+// A missing key is created with the default timeout.
+func (s *shardedMapStore) LPush(ctx context.Context, key string, values ...interface{}) (int, error) {
+	return s.push(ctx, "lpush", key, values, (*listValue).pushFront)
+}
+
+// RPush append values to the tail of the list at key, and return the length of the list, see LPush
+func (s *shardedMapStore) RPush(ctx context.Context, key string, values ...interface{}) (int, error) {
+	return s.push(ctx, "rpush", key, values, (*listValue).pushBack)
+}
+
+func (s *shardedMapStore) push(ctx context.Context, op, key string, values []interface{}, push func(l *listValue, value interface{})) (int, error) {
+	var n int
+	err := s.updateList(ctx, op, key, true, func(l *listValue) {
+		for _, value := range values {
+			push(l, s.cloneOnSet(value))
+		}
+		n = l.len()
+	})
+	return n, err
+}
+
+// LPop remove and return the head element of the list at key. ErrKeyNotFound is returned if there is none, and
+// the key is deleted with its last element.
+func (s *shardedMapStore) LPop(ctx context.Context, key string) (interface{}, error) {
+	return s.pop(ctx, "lpop", key, (*listValue).popFront)
+}
+
+// RPop remove and return the tail element of the list at key, see LPop
+func (s *shardedMapStore) RPop(ctx context.Context, key string) (interface{}, error) {
+	return s.pop(ctx, "rpop", key, (*listValue).popBack)
+}
+
+func (s *shardedMapStore) pop(ctx context.Context, op, key string, pop func(l *listValue) interface{}) (interface{}, error) {
+	var value interface{}
+	err := s.updateList(ctx, op, key, false, func(l *listValue) {
+		value = pop(l)
+	})
+	// the element left the list, nobody else holds it
+	return value, err
+}
+
+// LRange return the elements of the list at key from start to stop included. Negative indexes count from the
+// tail, -1 being the last element. A missing key is an empty list.
+func (s *shardedMapStore) LRange(ctx context.Context, key string, start, stop int) ([]interface{}, error) {
+	var values []interface{}
+	err := s.viewList(ctx, "lrange", key, func(l *listValue) {
+		values = l.slice(start, stop)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		values[i] = s.cloneOnGet(value)
+	}
+	return values, nil
+}
+
+// LLen return the length of the list at key, 0 if it is missing
+func (s *shardedMapStore) LLen(ctx context.Context, key string) (int, error) {
+	var n int
+	err := s.viewList(ctx, "llen", key, func(l *listValue) {
+		n = l.len()
+	})
+	return n, err
+}
+
+// LIndex return the element at index in the list at key, negative indexes counting from the tail.
+// ErrKeyNotFound is returned if the key is missing or the index out of range.
+func (s *shardedMapStore) LIndex(ctx context.Context, key string, index int) (interface{}, error) {
+	var value interface{}
+	var found bool
+	err := s.viewList(ctx, "lindex", key, func(l *listValue) {
+		var i int
+		if i, found = listIndex(index, l.len()); found {
+			value = l.at(i)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, keyError("lindex", key, ErrKeyNotFound)
+	}
+	return s.cloneOnGet(value), nil
+}
+
+// LTrim keep only the elements of the list at key from start to stop included, see LRange. The key is deleted if
+// none is left.
+func (s *shardedMapStore) LTrim(ctx context.Context, key string, start, stop int) error {
+	err := s.updateList(ctx, "ltrim", key, false, func(l *listValue) {
+		l.trim(start, stop)
+	})
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil
+	}
+	return err
+}
+
+// viewList call fn with the list at key under the shard lock. A missing key is an empty list.
+func (s *shardedMapStore) viewList(ctx context.Context, op, key string, fn func(l *listValue)) error {
+	return s.viewCollection(ctx, op, key, listKind, func(c collection, now int64) {
+		l, _ := c.(*listValue)
+		if l == nil {
+			l = &listValue{}
+		}
+		fn(l)
+	})
+}
+
+// updateList call fn with the list at key under the shard lock, see updateCollection
+func (s *shardedMapStore) updateList(ctx context.Context, op, key string, create bool, fn func(l *listValue)) error {
+	return s.updateCollection(ctx, op, key, listKind, create, func(c collection, now int64) error {
+		fn(c.(*listValue))
+		return nil
+	})
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func Test_listRange(t *testing.T) {
+	cases := []struct {
+		start, stop, n int
+		from, to       int
+	}{
+		{0, -1, 5, 0, 5},
+		{1, 3, 5, 1, 4},
+		{-2, -1, 5, 3, 5},
+		{-10, 10, 5, 0, 5},
+		{3, 1, 5, 0, 0},
+		{5, 6, 5, 0, 0},
+		{0, -1, 0, 0, 0},
+	}
+	for _, c := range cases {
+		if from, to := listRange(c.start, c.stop, c.n); from != c.from || to != c.to {
+			t.Errorf("list_range_incorrect, start: %v, stop: %v, n: %v, got: %v %v, want: %v %v", c.start, c.stop, c.n, from, to, c.from, c.to)
+		}
+	}
+}
+
+func Test_encodeList(t *testing.T) {
+	s := GetArenaStore().(*arenaStore)
+	l := &listValue{}
+	for _, v := range []interface{}{[]byte("raw"), "string", 42, []byte{}} {
+		l.pushBack(v)
+	}
+	data, err := encodeList(l, s.encode)
+	if err != nil {
+		t.Errorf("encode_list_error, err: %v", err)
+	}
+	if n, _ := listLen(data); n != 4 {
+		t.Errorf("list_len_incorrect, got: %v, want: %v", n, 4)
+	}
+	decoded, err := decodeList(data, s.decode)
+	if err != nil {
+		t.Errorf("decode_list_error, err: %v", err)
+	}
+	if got, want := decoded.slice(0, -1), l.slice(0, -1); !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded_list_incorrect, got: %v, want: %v", got, want)
+	}
+	if decoded.size != l.size {
+		t.Errorf("decoded_list_size_incorrect, got: %v, want: %v", decoded.size, l.size)
+	}
+	if _, err := decodeList(data[:len(data)-2], s.decode); err == nil {
+		t.Errorf("truncated_list_should_fail_to_decode")
+	}
+}
+
+func Test_List_MemoryUsage(t *testing.T) {
+	s := GetShardedMapStore()
+	ctx := context.Background()
+	_, _ = s.RPush(ctx, "list", "a")
+	small := s.GetMemoryUsage()
+	for i := 0; i < 100; i++ {
+		_, _ = s.RPush(ctx, "list", "0123456789")
+	}
+	if usage := s.GetMemoryUsage(); usage < small+100*10 {
+		t.Errorf("pushes_should_be_accounted, got: %v, want more than: %v", usage, small+100*10)
+	}
+	for i := 0; i < 101; i++ {
+		_, _ = s.LPop(ctx, "list")
+	}
+	if usage := s.GetMemoryUsage(); usage != 0 {
+		t.Errorf("popped_list_should_free_memory, got: %v, want: %v", usage, 0)
+	}
+}
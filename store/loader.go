@@ -0,0 +1,265 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Loader load the value of a key missing from the store, usually from the system of record
+type Loader func(ctx context.Context, key string) (interface{}, error)
+
+// ErrLoaderFailed wrap the error returned by a Loader, and the cached one while the negative TTL lasts
+var ErrLoaderFailed = errors.New("loader failed")
+
+// loadGroup collapse the concurrent loads of the same key into a single Loader call. It is shared by the stores,
+// which set clock, stats, negativeTTL and store once their options are applied.
+type loadGroup struct {
+	mu       sync.Mutex
+	calls    map[string]*loadCall
+	negative map[string]negativeEntry // loader errors cached for negativeTTL
+	sweepAt  int                      // the size of negative from which the expired errors are swept on insert
+
+	clock       Clock
+	stats       *Stats
+	negativeTTL time.Duration // How long a loader error is cached. 0 means it isn't.
+	// store cache a loaded value, unless key was written or deleted since the load started: it must still hold
+	// version, or hold nothing without having been deleted after since. See loadCall.
+	store func(key string, value interface{}, version, since uint64)
+}
+
+func newLoadGroup() loadGroup {
+	return loadGroup{
+		calls:    make(map[string]*loadCall),
+		negative: make(map[string]negativeEntry),
+	}
+}
+
+// loadCall is a Loader call in flight. Its context is canceled once every caller waiting for it gave up.
+type loadCall struct {
+	done    chan struct{}
+	value   interface{}
+	err     error
+	waiters int
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	version uint64 // the version of the value of key when the call started, 0 if there was none
+	since   uint64 // the last version given by the store when the call started
+}
+
+// minNegativeSweep is the size of the negative cache below which it isn't swept
+const minNegativeSweep = 64
+
+type negativeEntry struct {
+	err      error
+	deadline int64 // timestamp nanosecond
+}
+
+// Stats are the counters of a store since it was created
+type Stats struct {
+	Loads        int64 // Loader calls
+	LoadErrors   int64 // Loader calls which returned an error
+	Coalesced    int64 // GetOrLoad callers served by the Loader call of another caller
+	NegativeHits int64 // GetOrLoad callers served a cached loader error
+	StaleHits    int64 // reads served a value past its soft timeout
+	Refreshes    int64 // Loader calls started in the background to refresh a value
+
+	WritesFlushed   int64 // write-behind writes which reached the backend
+	WritesCoalesced int64 // write-behind writes replaced by a newer write of the same key before being flushed
+	WritesFailed    int64 // write-behind writes given up after too many retries
+
+	CompressedBytesIn  int64 // bytes of the values compressed, before compression
+	CompressedBytesOut int64 // bytes of the values compressed, after compression
+}
+
+// GetOrLoad return the value of key. On a miss, the value is loaded with loader and stored with the loader TTL.
+// Concurrent misses on the same key share one loader call. A loader error is returned wrapping ErrLoaderFailed,
+// and is cached for the negative TTL if one is set, unless the loader was canceled. ctx only bounds the wait of
+// this caller: the loader keeps running as long as one caller still waits for it, and a later miss starts a new
+// one once it is canceled. A stale value is returned right away and refreshed with loader in the background.
+// A nil loader loads from the backend of the store, a miss without either returns ErrKeyNotFound.
+func (s *shardedMapStore) GetOrLoad(ctx context.Context, key string, loader Loader) (interface{}, error) {
+	if loader == nil && s.backend != nil {
+		loader = s.loadBackend
+	}
+	since := atomic.LoadUint64(&s.versions)
+	value, _, err := s.get(ctx, key, loader)
+	if !errors.Is(err, ErrKeyNotFound) || loader == nil {
+		return value, err
+	}
+
+	value, err = s.loads.load(ctx, key, loader, since)
+	if err != nil {
+		return nil, err
+	}
+	return s.cloneOnGet(value), nil
+}
+
+// load return the value of key loaded with loader, sharing the Loader call in flight if there is one. The key was
+// found missing after since, the last version given by the store.
+func (g *loadGroup) load(ctx context.Context, key string, loader Loader, since uint64) (interface{}, error) {
+	g.mu.Lock()
+	if n, ok := g.negative[key]; ok {
+		if g.clock.Now().UnixNano() <= n.deadline {
+			g.mu.Unlock()
+			atomic.AddInt64(&g.stats.NegativeHits, 1)
+			return nil, keyError("load", key, n.err)
+		}
+		delete(g.negative, key)
+	}
+
+	call, started := g.start(key, loader, 0, since)
+	if !started {
+		atomic.AddInt64(&g.stats.Coalesced, 1)
+	}
+	call.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		if call.err != nil {
+			return nil, keyError("load", key, call.err)
+		}
+		return call.value, nil
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			call.cancel()
+		}
+		g.mu.Unlock()
+		return nil, keyError("load", key, ctx.Err())
+	}
+}
+
+// refresh reload the value of key in the background, unless it is already being loaded or its loader failed
+// within the negative TTL. The reloaded value only replaces the one at version, or a missing one if nobody deleted
+// the key after since. The caller may hold the lock of the key's shard.
+func (g *loadGroup) refresh(key string, loader Loader, version, since uint64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if n, ok := g.negative[key]; ok && g.clock.Now().UnixNano() <= n.deadline {
+		return
+	}
+	if _, started := g.start(key, loader, version, since); started {
+		atomic.AddInt64(&g.stats.Refreshes, 1)
+	}
+}
+
+// start return the load of key in flight, starting one if there is none or if it was canceled. The caller must
+// hold g.mu.
+func (g *loadGroup) start(key string, loader Loader, version, since uint64) (call *loadCall, started bool) {
+	if call, ok := g.calls[key]; ok && call.ctx.Err() == nil {
+		return call, false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	call = &loadCall{
+		done:    make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+		version: version,
+		since:   since,
+	}
+	g.calls[key] = call
+	go g.run(ctx, key, loader, call)
+	return call, true
+}
+
+// run call the loader, store its result and wake up the callers waiting for it. The error of a canceled call
+// isn't cached, since it tells nothing about the key.
+func (g *loadGroup) run(ctx context.Context, key string, loader Loader, call *loadCall) {
+	defer call.cancel()
+	atomic.AddInt64(&g.stats.Loads, 1)
+
+	value, err := loader(ctx, key)
+	if err != nil {
+		atomic.AddInt64(&g.stats.LoadErrors, 1)
+		call.err = &loaderError{err}
+	} else {
+		call.value = value
+		g.store(key, value, call.version, call.since)
+	}
+
+	g.mu.Lock()
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+	canceled := errors.Is(call.err, context.Canceled) || errors.Is(call.err, context.DeadlineExceeded)
+	if call.err != nil && !canceled && g.negativeTTL > 0 {
+		g.cacheError(key, call.err)
+	}
+	g.mu.Unlock()
+	close(call.done)
+}
+
+// cacheError cache err as the result of loading key for the negative TTL. The expired errors are swept once the
+// map doubled since the last sweep, so it stays within twice the errors cached. The caller must hold g.mu.
+func (g *loadGroup) cacheError(key string, err error) {
+	now := g.clock.Now()
+	if len(g.negative) >= g.sweepAt {
+		for k, n := range g.negative {
+			if now.UnixNano() > n.deadline {
+				delete(g.negative, k)
+			}
+		}
+		g.sweepAt = 2 * len(g.negative)
+		if g.sweepAt < minNegativeSweep {
+			g.sweepAt = minNegativeSweep
+		}
+	}
+	g.negative[key] = negativeEntry{
+		err:      err,
+		deadline: now.Add(g.negativeTTL).UnixNano(),
+	}
+}
+
+func (s *shardedMapStore) loaderTTL() time.Duration {
+	if s.loadTTL != 0 {
+		return s.loadTTL
+	}
+	return s.defaultTimeout
+}
+
+// GetStats return a snapshot of the counters of the store
+func (s *shardedMapStore) GetStats() Stats {
+	return s.stats.snapshot()
+}
+
+// snapshot read the counters atomically
+func (st *Stats) snapshot() Stats {
+	return Stats{
+		Loads:        atomic.LoadInt64(&st.Loads),
+		LoadErrors:   atomic.LoadInt64(&st.LoadErrors),
+		Coalesced:    atomic.LoadInt64(&st.Coalesced),
+		NegativeHits: atomic.LoadInt64(&st.NegativeHits),
+		StaleHits:    atomic.LoadInt64(&st.StaleHits),
+		Refreshes:    atomic.LoadInt64(&st.Refreshes),
+
+		WritesFlushed:   atomic.LoadInt64(&st.WritesFlushed),
+		WritesCoalesced: atomic.LoadInt64(&st.WritesCoalesced),
+		WritesFailed:    atomic.LoadInt64(&st.WritesFailed),
+
+		CompressedBytesIn:  atomic.LoadInt64(&st.CompressedBytesIn),
+		CompressedBytesOut: atomic.LoadInt64(&st.CompressedBytesOut),
+	}
+}
+
+// loaderError make the error of a Loader match ErrLoaderFailed while keeping the original one reachable
+type loaderError struct {
+	err error
+}
+
+func (e *loaderError) Error() string {
+	return ErrLoaderFailed.Error() + ": " + e.err.Error()
+}
+
+func (e *loaderError) Is(target error) bool {
+	return target == ErrLoaderFailed
+}
+
+func (e *loaderError) Unwrap() error {
+	return e.err
+}
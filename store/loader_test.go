@@ -0,0 +1,222 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_GetOrLoad(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s := GetShardedMapStore(SetClock(clock), SetLoaderTTL(time.Minute))
+
+	var calls int32
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return "loaded-" + key, nil
+	}
+
+	v, err := s.GetOrLoad(context.Background(), "user:1", loader)
+	if err != nil || v != "loaded-user:1" {
+		t.Errorf("get_or_load_error, v: %v, err: %v", v, err)
+	}
+	// the second read is a hit
+	v, err = s.GetOrLoad(context.Background(), "user:1", loader)
+	if err != nil || v != "loaded-user:1" || atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("loaded_value_should_be_cached, v: %v, err: %v, calls: %v", v, err, calls)
+	}
+
+	// cached with the loader TTL
+	clock.Advance(time.Minute + time.Nanosecond)
+	if _, err := s.Get("user:1"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("loaded_value_should_expire, err: %v", err)
+	}
+}
+
+func Test_GetOrLoad_Singleflight(t *testing.T) {
+	s := GetShardedMapStore()
+
+	var calls int32
+	release := make(chan struct{})
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return 42, nil
+	}
+
+	const callers = 50
+	var wg sync.WaitGroup
+	wg.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			defer wg.Done()
+			if v, err := s.GetOrLoad(context.Background(), "stampede", loader); err != nil || v != 42 {
+				t.Errorf("get_or_load_error, v: %v, err: %v", v, err)
+			}
+		}()
+	}
+	// wait for all the callers to queue up on the single load
+	for s.GetStats().Coalesced < callers-1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("loader_should_be_called_once, calls: %v", calls)
+	}
+	if stats := s.GetStats(); stats.Loads != 1 || stats.Coalesced != callers-1 {
+		t.Errorf("stats_incorrect, stats: %+v", stats)
+	}
+}
+
+func Test_GetOrLoad_NegativeTTL(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s := GetShardedMapStore(SetClock(clock), SetNegativeTTL(time.Second))
+
+	errDB := errors.New("db down")
+	var calls int32
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, errDB
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := s.GetOrLoad(context.Background(), "user:1", loader)
+		if !errors.Is(err, ErrLoaderFailed) || !errors.Is(err, errDB) {
+			t.Errorf("should_be_loader_error, err: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("loader_error_should_be_cached, calls: %v", calls)
+	}
+	if stats := s.GetStats(); stats.NegativeHits != 2 || stats.LoadErrors != 1 {
+		t.Errorf("stats_incorrect, stats: %+v", stats)
+	}
+
+	clock.Advance(time.Second + time.Nanosecond)
+	_, _ = s.GetOrLoad(context.Background(), "user:1", loader)
+	if calls != 2 {
+		t.Errorf("loader_should_be_retried_after_negative_ttl, calls: %v", calls)
+	}
+}
+
+func Test_GetOrLoad_NegativeSweep(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s := GetShardedMapStore(SetClock(clock), SetNegativeTTL(time.Second)).(*shardedMapStore)
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		return nil, errors.New("db down")
+	}
+
+	for i := 0; i < 1000; i++ {
+		_, _ = s.GetOrLoad(context.Background(), fmt.Sprintf("user:%d", i), loader)
+		clock.Advance(100 * time.Millisecond)
+	}
+	// only the last 10 errors are still cached
+	s.loads.mu.Lock()
+	cached := len(s.loads.negative)
+	s.loads.mu.Unlock()
+	if cached > 2*minNegativeSweep {
+		t.Errorf("expired_errors_should_be_swept, got: %v, want: <= %v", cached, 2*minNegativeSweep)
+	}
+}
+
+func Test_GetOrLoad_CallerGivesUp(t *testing.T) {
+	s := GetShardedMapStore()
+
+	loaderCanceled := make(chan struct{})
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		<-ctx.Done()
+		close(loaderCanceled)
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.GetOrLoad(ctx, "slow", loader); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("get_or_load_should_time_out, err: %v", err)
+	}
+
+	// nobody waits anymore, so the load is canceled
+	select {
+	case <-loaderCanceled:
+	case <-time.After(time.Second):
+		t.Errorf("loader_should_be_canceled_when_all_callers_gave_up")
+	}
+}
+
+func Test_GetOrLoad_CanceledLoadNotShared(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s := GetShardedMapStore(SetClock(clock), SetNegativeTTL(time.Minute))
+
+	var calls int32
+	started := make(chan struct{})
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return "loaded", nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	if _, err := s.GetOrLoad(ctx, "key", loader); !errors.Is(err, context.Canceled) {
+		t.Errorf("get_or_load_should_be_canceled, err: %v", err)
+	}
+
+	// the canceled load is neither joined nor cached
+	if v, err := s.GetOrLoad(context.Background(), "key", loader); err != nil || v != "loaded" {
+		t.Errorf("get_or_load_should_start_a_new_load, v: %v, err: %v", v, err)
+	}
+	if stats := s.GetStats(); stats.NegativeHits != 0 {
+		t.Errorf("canceled_load_should_not_be_cached, negative_hits: %v", stats.NegativeHits)
+	}
+}
+
+func Test_GetOrLoad_ConcurrentWrite(t *testing.T) {
+	writes := []struct {
+		name  string
+		write func(s Store)
+		want  interface{}
+	}{
+		{"set", func(s Store) { _ = s.Set("key", "set") }, "set"},
+		{"delete", func(s Store) { _ = s.Set("key", "set"); _ = s.Delete("key") }, nil},
+	}
+	for _, newStore := range []func(opts ...Option) Store{GetShardedMapStore, GetArenaStore} {
+		for _, w := range writes {
+			s := newStore()
+			started := make(chan struct{})
+			release := make(chan struct{})
+			loader := func(ctx context.Context, key string) (interface{}, error) {
+				close(started)
+				<-release
+				return "loaded", nil
+			}
+			loaded := make(chan interface{})
+			go func() {
+				v, _ := s.GetOrLoad(context.Background(), "key", loader)
+				loaded <- v
+			}()
+			<-started
+			w.write(s)
+			close(release)
+			if v := <-loaded; v != "loaded" {
+				t.Errorf("caller_should_get_the_loaded_value, write: %v, got: %v", w.name, v)
+			}
+
+			// the write made while the loader ran is kept
+			if v, _ := s.Get("key"); v != w.want {
+				t.Errorf("load_should_not_overwrite_a_concurrent_write, write: %v, got: %v, want: %v", w.name, v, w.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"math/rand"
+)
+
+// LRUMode decide where EvictionLRU keeps its recency lists
+type LRUMode uint32
+
+const (
+	// LRUGlobal keep one linked list for the whole store. The eviction is exact, but every access of every shard
+	// contends on the same mutex.
+	LRUGlobal LRUMode = 0
+	// LRUPerShard give each shard its own list and an equal slice of the capacity and max memory. Accesses on
+	// different shards never contend, and a shard evicts its own tail as soon as it is over its slice.
+	// The capacity is enforced per shard, each one holding up to capacity/shardCount keys rounded up, so the store
+	// can hold up to shardCount-1 keys more than the capacity, e.g. 128 keys with a capacity of 100 and 32 shards.
+	LRUPerShard LRUMode = 1
+	// LRUPerShardApprox give each shard its own list but keep the limits global. The victim is the oldest of the
+	// tails of evictionSamples shards, so the eviction approximates a global LRU.
+	LRUPerShardApprox LRUMode = 2
+)
+
+// lruTouch put the entry at the front of its LRU list. The caller must hold the lock of sm.
+func (s *shardedMapStore) lruTouch(sm *shardedMap, e *entry, existed bool) {
+	if s.lruMode == LRUGlobal {
+		s.linkedListMutex.Lock()
+		defer s.linkedListMutex.Unlock()
+		if existed {
+			// The key already be in cache. Don't need to create new node in linked list
+			s.lruList.moveToFront(e)
+		} else {
+			s.lruList.pushFront(e)
+		}
+		return
+	}
+
+	if s.lruMode == LRUPerShardApprox {
+		e.accessTime = s.now()
+	}
+	if existed {
+		sm.lru.moveToFront(e)
+	} else {
+		sm.lru.pushFront(e)
+	}
+}
+
+// lruRemove unlink the entry from its LRU list. The caller must hold the lock of sm.
+func (s *shardedMapStore) lruRemove(sm *shardedMap, e *entry) {
+	if s.lruMode == LRUGlobal {
+		s.linkedListMutex.Lock()
+		s.lruList.remove(e)
+		s.linkedListMutex.Unlock()
+		return
+	}
+	sm.lru.remove(e)
+}
+
+// lruEvict evict the least recently used entry of the global list. The tail is read under the linked list mutex,
+// but removed under the shard lock first, so the lock order stays shard -> linked list everywhere.
+func (s *shardedMapStore) lruEvict() bool {
+	// TODO: should support evict multiple
+	s.linkedListMutex.Lock()
+	tail := s.lruList.back()
+	s.linkedListMutex.Unlock()
+	if tail == nil {
+		return false
+	}
+
+	sm := s.selectSharedMap(tail.key)
+	sm.mu.Lock()
+	if e, ok := sm.m[tail.key]; ok && e == tail {
+		s.removeEntry(sm, tail.key, e)
+	}
+	sm.mu.Unlock()
+	return true
+}
+
+// shardLRUEvict evict the tail of the shard until the shard is back under its slice of the limits. The entry just
+// written is never evicted. The caller must hold the lock of sm.
+func (s *shardedMapStore) shardLRUEvict(sm *shardedMap, keep *entry) {
+	for s.shardCapacity != 0 && len(sm.m) > s.shardCapacity ||
+		s.shardMaxMemory != 0 && sm.memUsage > s.shardMaxMemory {
+		tail := sm.lru.back()
+		if tail == nil || tail == keep {
+			return
+		}
+		s.removeEntry(sm, tail.key, tail)
+	}
+}
+
+// sampledLRUEvict look at the tails of evictionSamples shards, starting from a random one, and evict the one
+// accessed the longest time ago. The key just written is skipped unless it is the only candidate left. Only one
+// shard lock is held at a time.
+func (s *shardedMapStore) sampledLRUEvict(keep string) bool {
+	start := rand.Intn(len(s.shardedMaps))
+
+	var victimShard *shardedMap
+	var victim *entry
+	var victimTime int64
+	sampled := 0
+	for i := 0; i < len(s.shardedMaps) && sampled < s.evictionSamples; i++ {
+		sm := &s.shardedMaps[(start+i)%len(s.shardedMaps)]
+		sm.mu.Lock()
+		tail := sm.lru.back()
+		if tail != nil && tail.key == keep {
+			tail = tail.prev
+		}
+		if tail != nil {
+			if victim == nil || tail.accessTime < victimTime {
+				victimShard, victim, victimTime = sm, tail, tail.accessTime
+			}
+			sampled++
+		}
+		sm.mu.Unlock()
+	}
+	if victim == nil {
+		// keep is the only key left
+		if keep == "" {
+			return false
+		}
+		return s.sampledLRUEvict("")
+	}
+
+	victimShard.mu.Lock()
+	if e, ok := victimShard.m[victim.key]; ok && e == victim {
+		s.removeEntry(victimShard, victim.key, e)
+	}
+	victimShard.mu.Unlock()
+	return true
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_LRUPerShard_flow(t *testing.T) {
+	// Every key lives in the same shard, so the shard slice of the capacity is what is enforced
+	keys := sameShardKeys(4)
+	s := GetShardedMapStore(SetCapacity(2*defaultShardCount), SetEvictionPolicy(EvictionLRU), SetLRUMode(LRUPerShard))
+
+	res := callFuncs(s,
+		[]string{"Set", "Set", "Get", "Set", "Get", "Set", "Get", "Get", "Get"},
+		[][]interface{}{{keys[0], 1}, {keys[1], "2"}, {keys[0]}, {keys[2], 3}, {keys[1]}, {keys[3], 4}, {keys[0]}, {keys[2]}, {keys[3]}},
+	)
+	expected := []interface{}{nil, nil, 1, nil, -1, nil, -1, 3, 4}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("return value not correct, res=%v, expected=%v", res, expected)
+	}
+
+	// The other shards are not affected by the full one
+	for i := 0; i < 10*defaultShardCount; i++ {
+		if key := fmt.Sprintf("other-%d", i); FNVHasher(key)%defaultShardCount != 0 {
+			_ = s.Set(key, i)
+		}
+	}
+	if _, err := s.Get(keys[3]); err != nil {
+		t.Errorf("key_of_full_shard_should_not_be_evicted, err: %v", err)
+	}
+}
+
+func Test_LRUPerShardApprox_flow(t *testing.T) {
+	// Sampling the tails of all the shards gives an exact global LRU
+	testCases := []struct {
+		funcNames []string
+		argsSlice [][]interface{}
+		expected  []interface{}
+	}{
+		{
+			[]string{"Set", "Set", "Get", "Set", "Get", "Set", "Get", "Get", "Get"},
+			[][]interface{}{{"1", 1}, {"2", "2"}, {"1"}, {"3", 3}, {"2"}, {"4", 4}, {"1"}, {"3"}, {"4"}},
+			[]interface{}{nil, nil, 1, nil, -1, nil, -1, 3, 4},
+		},
+		{
+			[]string{"Set", "Set", "Set", "Set", "Get", "Get"},
+			[][]interface{}{{"2", 1}, {"1", 1}, {"2", 3}, {"4", 1}, {"1"}, {"2"}},
+			[]interface{}{nil, nil, nil, nil, -1, 3},
+		},
+	}
+
+	for _, testCase := range testCases {
+		s := GetShardedMapStore(SetCapacity(2), SetEvictionPolicy(EvictionLRU), SetLRUMode(LRUPerShardApprox),
+			SetEvictionSamples(defaultShardCount))
+		res := callFuncs(s, testCase.funcNames, testCase.argsSlice)
+		if !reflect.DeepEqual(res, testCase.expected) {
+			t.Errorf("return value not correct, res=%v, expected=%v", res, testCase.expected)
+		}
+	}
+}
+
+func Test_LRUPerShardApprox_KeepsKeyJustSet(t *testing.T) {
+	// the clock doesn't move, so the access times of the tails can't tell the key just set from the others
+	s := GetShardedMapStore(SetCapacity(3), SetEvictionPolicy(EvictionLRU), SetLRUMode(LRUPerShardApprox),
+		SetClock(NewFakeClock(time.Now())))
+	for i := 0; i < 4000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if err := s.Set(key, i); err != nil {
+			t.Fatalf("set_cache_error, err: %v", err)
+		}
+		if _, err := s.Get(key); err != nil {
+			t.Fatalf("key_just_set_should_not_be_evicted, key: %v, err: %v", key, err)
+		}
+	}
+}
+
+func Benchmark_ParallelGet(b *testing.B) {
+	stores := []struct {
+		name string
+		s    Store
+	}{
+		{"NoEviction", GetShardedMapStore()},
+		{"LRUGlobal", GetShardedMapStore(SetCapacity(100000), SetEvictionPolicy(EvictionLRU))},
+		{"LRUPerShard", GetShardedMapStore(SetCapacity(100000), SetEvictionPolicy(EvictionLRU),
+			SetLRUMode(LRUPerShard))},
+		{"LRUPerShardApprox", GetShardedMapStore(SetCapacity(100000), SetEvictionPolicy(EvictionLRU),
+			SetLRUMode(LRUPerShardApprox))},
+	}
+	const keyCount = 10000
+	for _, st := range stores {
+		for i := 0; i < keyCount; i++ {
+			_ = st.s.Set(strconv.Itoa(i), i)
+		}
+		b.Run(st.name, func(b *testing.B) {
+			var seed int64
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(atomic.AddInt64(&seed, 7919))
+				for pb.Next() {
+					_, _ = st.s.Get(strconv.Itoa(i % keyCount))
+					i++
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// LZCompressor is a fast LZ77 compressor in the spirit of Snappy and LZ4. It trades ratio for speed: the
+// matches are found with a single hash table lookup, without any entropy coding.
+//
+// The format is the uvarint length of the uncompressed data followed by the elements. An element starts with
+// a uvarint n. An even n is followed by n>>1 literal bytes, an odd n is a copy of n>>1 bytes from a uvarint
+// offset back in the output.
+type LZCompressor struct{}
+
+const (
+	lzHashBits  = 14
+	lzMinMatch  = 4
+	lzMaxOffset = 1 << 16
+)
+
+var errLZCorrupt = errors.New("lz: corrupt input")
+
+func (LZCompressor) Compress(src []byte) ([]byte, error) {
+	dst := make([]byte, 0, len(src)/2+binary.MaxVarintLen64)
+	dst = appendUvarint64(dst, uint64(len(src)))
+
+	var table [1 << lzHashBits]int32 // position plus one of the last 4 bytes hashed there
+	lit := 0
+	i := 0
+	for i+lzMinMatch <= len(src) {
+		cur := binary.LittleEndian.Uint32(src[i:])
+		h := (cur * 0x1e35a7bd) >> (32 - lzHashBits)
+		cand := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if cand < 0 || i-cand > lzMaxOffset || binary.LittleEndian.Uint32(src[cand:]) != cur {
+			i++
+			continue
+		}
+
+		n := lzMinMatch
+		for i+n < len(src) && src[cand+n] == src[i+n] {
+			n++
+		}
+		if lit < i {
+			dst = appendUvarint64(dst, uint64(i-lit)<<1)
+			dst = append(dst, src[lit:i]...)
+		}
+		dst = appendUvarint64(dst, uint64(n)<<1|1)
+		dst = appendUvarint64(dst, uint64(i-cand))
+		i += n
+		lit = i
+	}
+	if lit < len(src) {
+		dst = appendUvarint64(dst, uint64(len(src)-lit)<<1)
+		dst = append(dst, src[lit:]...)
+	}
+	return dst, nil
+}
+
+func (LZCompressor) Decompress(src []byte) ([]byte, error) {
+	size, k := binary.Uvarint(src)
+	if k <= 0 {
+		return nil, errLZCorrupt
+	}
+	src = src[k:]
+	// don't trust the length of a corrupt input for the allocation
+	capacity := size
+	if capacity > lzMaxOffset {
+		capacity = lzMaxOffset
+	}
+	dst := make([]byte, 0, capacity)
+	for len(src) > 0 {
+		n, k := binary.Uvarint(src)
+		if k <= 0 {
+			return nil, errLZCorrupt
+		}
+		src = src[k:]
+		length := int(n >> 1)
+		if n&1 == 0 {
+			if length > len(src) || uint64(len(dst)+length) > size {
+				return nil, errLZCorrupt
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		}
+
+		offset, k := binary.Uvarint(src)
+		if k <= 0 || offset == 0 || offset > uint64(len(dst)) || uint64(len(dst)+length) > size {
+			return nil, errLZCorrupt
+		}
+		src = src[k:]
+		// the copy may overlap the bytes it produces, e.g. a run of a single byte has an offset of 1
+		start := len(dst) - int(offset)
+		for j := 0; j < length; j++ {
+			dst = append(dst, dst[start+j])
+		}
+	}
+	if uint64(len(dst)) != size {
+		return nil, errLZCorrupt
+	}
+	return dst, nil
+}
+
+func appendUvarint64(dst []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(dst, buf[:binary.PutUvarint(buf[:], v)]...)
+}
@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryBackend is a Backend keeping the values in a map. It is meant for tests.
+type MemoryBackend struct {
+	mu sync.RWMutex
+	m  map[string]interface{}
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		m: make(map[string]interface{}),
+	}
+}
+
+func (b *MemoryBackend) Load(ctx context.Context, key string) (interface{}, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	value, ok := b.m[key]
+	if !ok {
+		return nil, keyError("load", key, ErrKeyNotFound)
+	}
+	return value, nil
+}
+
+func (b *MemoryBackend) Store(ctx context.Context, key string, value interface{}) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.m[key] = value
+	return nil
+}
+
+func (b *MemoryBackend) Delete(ctx context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.m, key)
+	return nil
+}
+
+// Len return the number of keys in the backend
+func (b *MemoryBackend) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.m)
+}
@@ -0,0 +1,148 @@
+package store
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// incrementBy return value plus delta, of the same type as value, and the sum as an int64. value is any of the Go
+// integer types, or a decimal integer as a []byte or a string, what the TCP server stores. The sum must fit both
+// the type of value and an int64, or ErrOverflow is returned.
+func incrementBy(value interface{}, delta int64) (interface{}, int64, error) {
+	switch v := value.(type) {
+	case int:
+		n, err := addInt(int64(v), delta, strconv.IntSize)
+		return int(n), n, err
+	case int8:
+		n, err := addInt(int64(v), delta, 8)
+		return int8(n), n, err
+	case int16:
+		n, err := addInt(int64(v), delta, 16)
+		return int16(n), n, err
+	case int32:
+		n, err := addInt(int64(v), delta, 32)
+		return int32(n), n, err
+	case int64:
+		n, err := addInt(v, delta, 64)
+		return n, n, err
+	case uint:
+		n, err := addUint(uint64(v), delta, strconv.IntSize)
+		return uint(n), int64(n), err
+	case uint8:
+		n, err := addUint(uint64(v), delta, 8)
+		return uint8(n), int64(n), err
+	case uint16:
+		n, err := addUint(uint64(v), delta, 16)
+		return uint16(n), int64(n), err
+	case uint32:
+		n, err := addUint(uint64(v), delta, 32)
+		return uint32(n), int64(n), err
+	case uint64:
+		n, err := addUint(v, delta, 64)
+		return n, int64(n), err
+	case []byte:
+		n, err := addDecimal(string(v), delta)
+		return []byte(strconv.FormatInt(n, 10)), n, err
+	case string:
+		n, err := addDecimal(v, delta)
+		return strconv.FormatInt(n, 10), n, err
+	}
+	return nil, 0, ErrNotInteger
+}
+
+// addInt return x plus delta, or ErrOverflow if it doesn't fit a signed integer of bits
+func addInt(x, delta int64, bits int) (int64, error) {
+	n := x + delta
+	if delta > 0 && n < x || delta < 0 && n > x {
+		return 0, ErrOverflow
+	}
+	if bits < 64 && (n < -1<<(bits-1) || n > 1<<(bits-1)-1) {
+		return 0, ErrOverflow
+	}
+	return n, nil
+}
+
+// addUint return x plus delta, or ErrOverflow if it doesn't fit an unsigned integer of bits or an int64
+func addUint(x uint64, delta int64, bits int) (uint64, error) {
+	var n uint64
+	if delta >= 0 {
+		n = x + uint64(delta)
+		if n < x {
+			return 0, ErrOverflow
+		}
+	} else {
+		// the two's complement makes this right for math.MinInt64 too
+		d := uint64(-delta)
+		if d > x {
+			return 0, ErrOverflow
+		}
+		n = x - d
+	}
+	if bits < 64 && n > 1<<uint(bits)-1 || n > math.MaxInt64 {
+		return 0, ErrOverflow
+	}
+	return n, nil
+}
+
+func addDecimal(s string, delta int64) (int64, error) {
+	x, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+	return addInt(x, delta, 64)
+}
+
+// incrementByFloat return value plus delta and the sum as a float64. A float32 stays a float32, a []byte or a
+// string holds the sum in decimal, and the integer types become a float64. A sum which is not finite returns
+// ErrOverflow.
+func incrementByFloat(value interface{}, delta float64) (interface{}, float64, error) {
+	if math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return nil, 0, ErrNotFloat
+	}
+	switch v := value.(type) {
+	case float64:
+		f, err := addFloat(v, delta, 64)
+		return f, f, err
+	case float32:
+		f, err := addFloat(float64(v), delta, 32)
+		return float32(f), f, err
+	case []byte:
+		f, err := addFloatDecimal(string(v), delta)
+		return []byte(strconv.FormatFloat(f, 'f', -1, 64)), f, err
+	case string:
+		f, err := addFloatDecimal(v, delta)
+		return strconv.FormatFloat(f, 'f', -1, 64), f, err
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f, err := addFloat(float64(rv.Int()), delta, 64)
+		return f, f, err
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		f, err := addFloat(float64(rv.Uint()), delta, 64)
+		return f, f, err
+	}
+	return nil, 0, ErrNotFloat
+}
+
+// addFloat return x plus delta, or ErrOverflow if it isn't a finite float of bits
+func addFloat(x, delta float64, bits int) (float64, error) {
+	f := x + delta
+	if bits == 32 {
+		f = float64(float32(f))
+	}
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0, ErrOverflow
+	}
+	return f, nil
+}
+
+func addFloatDecimal(s string, delta float64) (float64, error) {
+	x, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(x) || math.IsInf(x, 0) {
+		return 0, ErrNotFloat
+	}
+	return addFloat(x, delta, 64)
+}
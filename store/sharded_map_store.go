@@ -1,17 +1,30 @@
 package store
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	shardCount = 32
+	// defaultShardCount is the number of shards unless SetShardCount says otherwise
+	defaultShardCount = 32
 
-	// The EvictionPolicy is not implemented
-	EvictionRandom EvictionPolicy = 0
-	EvictionLRU    EvictionPolicy = 1
+	// defaultEvictionSamples is the number of entries a random eviction looks at before picking a victim
+	defaultEvictionSamples = 5
+
+	EvictionRandom  EvictionPolicy = 0
+	EvictionLRU     EvictionPolicy = 1
+	EvictionLFU     EvictionPolicy = 2
+	// EvictionTinyLFU is the W-TinyLFU admission policy: an LRU window in front of a segmented LRU main region,
+	// guarded by a frequency sketch. It needs SetCapacity, a SetMaxMemory alone is rejected.
+	EvictionTinyLFU EvictionPolicy = 3
 
 	maxInt64 = int64(^uint64(0)>>1)
 )
@@ -22,256 +35,800 @@ type EvictionPolicy uint32
 type shardedMapStore struct {
 	Store
 	shardedMaps []shardedMap
+	shardCount int               // A power of two
+	shardMask uint64             // shardCount - 1, picks the shard from the low bits of the hash
+	hash Hasher
 
 	defaultTimeout time.Duration
+	clock Clock
 	maxMemory int64              // unit: bytes
 	capacity int                 // The max number of keys can be stored in cache. 0 means no limit. Default value is 0.
 	evictionPolicy EvictionPolicy
+	evictionSamples int          // The number of entries sampled by each random or LFU eviction
+
+	// LFU
+	lfuLogFactor int             // The larger the factor, the more accesses are needed to saturate the counter
+	lfuDecayTime time.Duration   // The counter is decremented by one every lfuDecayTime without access
+
+	length int64                 // current key count. Access with atomic
+	versions uint64              // the last version given to a value written. Access with atomic
 
 	// LRU
 	lru bool
-	head *entry
-	tail *entry
-	length int                   // current key count
-	linkedListMutex sync.Mutex   // TODO: LL operation should lock with this mutex
+	lruMode LRUMode
+	lruList entryList            // Only used with LRUGlobal
+	linkedListMutex sync.Mutex   // Guard lruList. Always acquired after the shard lock, never before
+	shardCapacity int            // The slice of capacity each shard owns with LRUPerShard, rounded up
+	shardMaxMemory int64         // The slice of maxMemory each shard owns with LRUPerShard
+
+	tinyLFU *tinyLFU             // Only set with EvictionTinyLFU
+
+	// Read-through
+	loads loadGroup
+	loadTTL time.Duration        // The timeout of the loaded values. 0 means defaultTimeout.
+	negativeTTL time.Duration    // How long a loader error is cached. 0 means it isn't.
+	stats Stats                  // Access with atomic
+
+	// Backing store
+	backend Backend
+	writeMode WriteMode
+	writeBehind *writeBehindQueue // Only set with WriteBehind
+	writeBehindInterval time.Duration
+	writeBehindRetries int
+
+	// Stale-while-revalidate
+	staleAfter time.Duration     // The soft timeout of Set and SetWithTimeout. 0 means values never get stale.
+	refreshAhead time.Duration   // Refresh a value read within refreshAhead of its deadline. 0 disables it.
+	refreshLoader Loader         // Refresh the stale values read with Get. nil means they are only reported stale.
+
+	// Value isolation
+	cloneMode CloneMode
+	cloner Cloner
+	codec Codec                  // When set, the entries hold the encoded values as []byte
+	compressor Compressor        // When set, the entries hold the large values as *compressedValue
+	compressThreshold int        // The size in bytes from which the values are compressed
+
+	// Background expiry
+	slidingExpiration bool       // Every read of a key restarts its timeout, see SetSlidingExpiration
+	expiryInterval time.Duration // How often the janitor removes expired keys. 0 means no janitor.
+	stopJanitor func()
+	closeOnce sync.Once
 }
 
 type shardedMap struct {
 	m map[string]*entry
-	mu sync.RWMutex
+	mu shardMutex
 
 	opCount uint    // memo the number of keys mutated since last time eviction
+	memUsage int64  // estimated bytes used by the entries of this shard. Access with atomic
+	expiry expiryHeap // the entries with a deadline, the nearest first
+
+	lru entryList   // Only used with LRUPerShard and LRUPerShardApprox. Guarded by mu.
+	tinyLFUBuffer []tinyLFUAccess // the accesses not applied to the W-TinyLFU policy yet. Guarded by mu.
+	deleted uint64   // the version given when a key of this shard was last deleted by a caller. Guarded by mu.
+	expiringHashes map[string]*entry // the hashes with fields expiring, see expireHashFields. Guarded by mu.
 }
 
 type entry struct {
 	data interface{}
 	deadline int64    // timestamp nanosecond
+	softDeadline int64 // timestamp nanosecond after which the data is stale. maxInt64 means never.
+	timeout int64     // nanosecond, what Touch restarts the deadline with. 0 means no timeout.
+	sliding bool      // every read restarts the timeout
+	version uint64    // changes whenever the value is written, see CompareAndSwapVersion
+	size int64        // estimated bytes used by the key, the value and the entry itself
+
+	// For LFU
+	freq uint8        // logarithmic access counter
+	accessTime int64  // timestamp nanosecond of the last access, used to decay freq and to compare shard LRU tails
 
-	// For LRU
-	key string        // TODO: This is bad cause it would need too many additional space. Maybe change it to *string?
+	key string        // shares the bytes of the map key, so it only costs a string header
+	expiryIndex int   // position in the shard expiry heap plus one. 0 means the entry is not in the heap.
+
+	// For LRU and W-TinyLFU
 	prev *entry
 	next *entry
+	segment uint8     // the W-TinyLFU segment the entry is linked in
 }
 
 func GetShardedMapStore(opts... Option) Store {
 	s := &shardedMapStore{
-		shardedMaps: make([]shardedMap, shardCount),
-	}
-	i := 0
-	for i < len(s.shardedMaps) {
-		sm := &s.shardedMaps[i]
-		sm.mu.Lock()
-		sm.m = make(map[string]*entry)
-		sm.mu.Unlock()
-		i++
+		shardCount:      defaultShardCount,
+		hash:            FNVHasher,
+		evictionSamples: defaultEvictionSamples,
+		lfuLogFactor:    defaultLFULogFactor,
+		lfuDecayTime:    defaultLFUDecayTime,
+		expiryInterval:  defaultExpiryInterval,
+		clock:           realClock{},
+		cloner:          DeepCopy,
+		writeBehindInterval: defaultWriteBehindInterval,
+		writeBehindRetries:  defaultWriteBehindRetries,
+		loads:           newLoadGroup(),
 	}
 	for _, opt := range opts {
 		opt(s)
 	}
 
-	if s.capacity != 0 && s.evictionPolicy == EvictionLRU {
+	s.shardedMaps = make([]shardedMap, s.shardCount)
+	s.shardMask = uint64(s.shardCount - 1)
+	for i := range s.shardedMaps {
+		s.shardedMaps[i].m = make(map[string]*entry)
+		s.shardedMaps[i].mu = newShardMutex()
+	}
+
+	if (s.capacity != 0 || s.maxMemory != 0) && s.evictionPolicy == EvictionLRU {
 		s.lru = true
+		if s.lruMode == LRUPerShard {
+			s.shardCapacity = (s.capacity + s.shardCount - 1) / s.shardCount
+			s.shardMaxMemory = s.maxMemory / int64(s.shardCount)
+		}
+	}
+	if s.evictionPolicy == EvictionTinyLFU {
+		if s.capacity == 0 && s.maxMemory != 0 {
+			// the regions and the sketch are sized in keys
+			log.Fatal("invalid_eviction_policy_option, EvictionTinyLFU needs a capacity, max memory: ", s.maxMemory)
+		}
+		s.tinyLFU = newTinyLFU(s.capacity)
+	}
+	if s.expiryInterval > 0 {
+		s.stopJanitor = s.clock.Schedule(s.expiryInterval, s.expireAll)
+	}
+	if s.backend != nil && s.writeMode == WriteBehind {
+		s.startWriteBehind()
+	}
+	s.loads.clock = s.clock
+	s.loads.stats = &s.stats
+	s.loads.negativeTTL = s.negativeTTL
+	s.loads.store = func(key string, value interface{}, version, since uint64) {
+		sm := s.selectSharedMap(key)
+		// A value too large to be cached is still served to the callers
+		_, _ = s.setIf(context.Background(), key, value, s.staleAfter, s.loaderTTL(), s.slidingExpiration, false, func(e *entry) bool {
+			if e == nil {
+				return sm.deleted <= since
+			}
+			return e.version == version
+		})
 	}
 
 	return s
 }
 
 func (s *shardedMapStore) selectSharedMap(key string) *shardedMap {
-	return &s.shardedMaps[fnv32(key)%shardCount]
+	return &s.shardedMaps[s.hash(key)&s.shardMask]
+}
+
+// lock acquire the write lock of the shard, or give up and return the error of ctx if it is done first
+func (sm *shardedMap) lock(ctx context.Context) error {
+	return lockCtx(ctx, &sm.mu)
+}
+
+// shardMutex is the lock of a shard. It is a channel holding a token while the shard is locked, so a waiter can
+// give up with a select, see lockCtx. The waiters get the lock in the order they asked for it.
+type shardMutex struct {
+	token chan struct{}
+}
+
+func newShardMutex() shardMutex {
+	return shardMutex{token: make(chan struct{}, 1)}
+}
+
+func (m *shardMutex) Lock() {
+	m.token <- struct{}{}
+}
+
+func (m *shardMutex) Unlock() {
+	select {
+	case <-m.token:
+	default:
+		panic("store: unlock of unlocked shard mutex")
+	}
+}
+
+// lockCtx acquire mu, or give up and return the error of ctx if it is done first
+func lockCtx(ctx context.Context, mu *shardMutex) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case mu.token <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *shardedMapStore) Set(key string, value interface{}) error {
+	return s.SetWithTimeoutCtx(context.Background(), key, value, s.defaultTimeout)
+}
+
+func (s *shardedMapStore) SetCtx(ctx context.Context, key string, value interface{}) error {
+	return s.SetWithTimeoutCtx(ctx, key, value, s.defaultTimeout)
+}
+
+func (s *shardedMapStore) SetWithTimeout(key string, value interface{}, timeout time.Duration) error {
+	return s.SetWithTimeoutCtx(context.Background(), key, value, timeout)
+}
+
+func (s *shardedMapStore) SetWithTimeoutCtx(ctx context.Context, key string, value interface{}, timeout time.Duration) error {
+	return s.set(ctx, key, value, s.staleAfter, timeout, s.slidingExpiration, true)
+}
+
+// SetWithSlidingTimeout store the value until it isn't read for timeout
+func (s *shardedMapStore) SetWithSlidingTimeout(key string, value interface{}, timeout time.Duration) error {
+	return s.set(context.Background(), key, value, s.staleAfter, timeout, true, true)
+}
+
+func (s *shardedMapStore) SetWithSoftTimeout(key string, value interface{}, softTimeout, timeout time.Duration) error {
+	return s.set(context.Background(), key, value, softTimeout, timeout, s.slidingExpiration, true)
 }
 
-func (s *shardedMapStore) Set(key string, value interface{}) ErrorCode {
-	return s.SetWithTimeout(key, value, s.defaultTimeout)
+// set store the value. After softTimeout, a read still returns the value but reports it stale and refresh it in
+// the background. After timeout, the value is gone. A timeout of 0 means no timeout. A sliding timeout restarts
+// on every read. persist is false for the values which come from the backend and don't need to be written back.
+func (s *shardedMapStore) set(ctx context.Context, key string, value interface{}, softTimeout, timeout time.Duration, sliding, persist bool) error {
+	_, err := s.setIf(ctx, key, value, softTimeout, timeout, sliding, persist, nil)
+	return err
 }
 
-func (s *shardedMapStore) SetWithTimeout(key string, value interface{}, timeout time.Duration) ErrorCode {
-	// TODO: This set method is very naive. It doesn't put any restriction on the input type.
-	// Also, when it get a value, it remove all the pointers above it find the real data. Only save the real data.
+// setIf is set writing only if cond returns true. cond is called under the shard lock with the live entry of key,
+// or nil if there is none.
+func (s *shardedMapStore) setIf(ctx context.Context, key string, value interface{}, softTimeout, timeout time.Duration, sliding, persist bool, cond func(e *entry) bool) (bool, error) {
+	w, err := s.prepare(key, value, softTimeout, timeout, sliding)
+	if err != nil {
+		return false, keyError("set", key, err)
+	}
+
+	sm := s.selectSharedMap(key)
+	if err := sm.lock(ctx); err != nil {
+		return false, keyError("set", key, err)
+	}
+	if cond != nil {
+		e, ok := sm.m[key]
+		if ok && w.now > e.deadline {
+			e = nil
+		}
+		if !cond(e) {
+			sm.mu.Unlock()
+			return false, nil
+		}
+	}
+	if persist {
+		if err := s.persist(ctx, key, w.value, false); err != nil {
+			sm.mu.Unlock()
+			return false, keyError("set", key, err)
+		}
+	}
+	s.write(sm, &w)
+	sm.mu.Unlock()
+
+	s.evictIfNeeded(key)
+	return true, nil
+}
+
+// preparedValue is a value ready to be stored by write
+type preparedValue struct {
+	key          string
+	value        interface{} // what is written to the backend
+	stored       interface{} // what the entry holds
+	now          int64
+	deadline     int64
+	softDeadline int64
+	timeout      time.Duration
+	sliding      bool
+	size         int64
+}
+
+// prepare encode, or clone, and compress value, and compute its deadlines, without locking anything
+func (s *shardedMapStore) prepare(key string, value interface{}, softTimeout, timeout time.Duration, sliding bool) (preparedValue, error) {
+	// The entry holds the encoded value with a codec, which is isolated from the caller too
+	stored := value
+	if s.codec != nil {
+		data, err := s.encode(value)
+		if err != nil {
+			return preparedValue{}, err
+		}
+		stored = data
+	} else {
+		value = s.cloneOnSet(value)
+		stored = value
+	}
+	stored, err := s.compress(stored)
+	if err != nil {
+		return preparedValue{}, err
+	}
 
 	// if timeout == 0, the key will never expire
-	deadline := time.Now().Add(timeout).UnixNano()
+	now := s.clock.Now()
+	deadline := now.Add(timeout).UnixNano()
 	if timeout == 0 {
 		deadline = maxInt64
 	}
+	softDeadline := now.Add(softTimeout).UnixNano()
+	if softTimeout == 0 || softDeadline > deadline {
+		softDeadline = maxInt64
+	}
 
-	sm := s.selectSharedMap(key)
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
+	size := entrySize(key, stored)
+	if s.maxMemory != 0 && size > s.maxMemory || s.shardMaxMemory != 0 && size > s.shardMaxMemory {
+		return preparedValue{}, ErrExceedMaxMemory
+	}
+	return preparedValue{
+		key:          key,
+		value:        value,
+		stored:       stored,
+		now:          now.UnixNano(),
+		deadline:     deadline,
+		softDeadline: softDeadline,
+		timeout:      timeout,
+		sliding:      sliding && timeout != 0,
+		size:         size,
+	}, nil
+}
 
-	var e *entry
-	var ok bool
-	if e, ok = sm.m[key]; ok {
+// write store a prepared value in its shard. The caller must hold the lock of sm, and call evictIfNeeded once it
+// is released.
+func (s *shardedMapStore) write(sm *shardedMap, w *preparedValue) {
+	e, ok := sm.m[w.key]
+	if ok {
 		// Avoid create new entry obj to reduce non-necessary allocation
-		e.data = value
-		e.deadline = deadline
+		atomic.AddInt64(&sm.memUsage, w.size-e.size)
+		e.data = w.stored
+		e.deadline = w.deadline
+		e.softDeadline = w.softDeadline
+		e.size = w.size
+		sm.expiry.schedule(e)
 	} else {
-		if s.lru {
-			e = &entry{
-				data:     value,
-				deadline: deadline,
-				key:      key,
-			}
-		} else {
-			e = &entry{
-				data:     value,
-				deadline: deadline,
-			}
-		}
-		s.length++
-		sm.m[key] = e
+		e = s.newEntry(w.key, w.stored, w.deadline, w.size)
+		e.softDeadline = w.softDeadline
+		s.addEntry(sm, w.key, e)
 	}
+	e.timeout = int64(w.timeout)
+	e.sliding = w.sliding
+	e.version = atomic.AddUint64(&s.versions, 1)
 	sm.opCount++
+	s.recordAccess(sm, e, ok)
+	if s.lruMode == LRUPerShard {
+		s.shardLRUEvict(sm, e)
+	}
 
+	if sm.opCount >= triggeringEvictionOptNum {
+		// Besides the janitor, a busy shard also clean up a batch of expired keys on its own
+		sm.opCount = 0
+		s.expireShard(sm, s.now(), expiryBatchSize)
+	}
+}
 
-	if s.lru {
-		s.linkedListMutex.Lock()
-		if s.head == nil {
-			// first key case
-			s.head = e
-			s.tail = s.head
-		} else {
-			if ok {
-				// The key already be in cache. Don't need to create new node in linked list
-				s.moveEntryToFront(e)
-			} else {
-				s.addEntryToFront(e)
-
-				if s.length > s.capacity {
-					s.lruEvict()
-				}
-			}
+func (s *shardedMapStore) Get(key string) (value interface{}, err error) {
+	value, _, err = s.get(context.Background(), key, s.refreshLoader)
+	return value, err
+}
+
+func (s *shardedMapStore) GetCtx(ctx context.Context, key string) (value interface{}, err error) {
+	value, _, err = s.get(ctx, key, s.refreshLoader)
+	return value, err
+}
+
+// GetInto decode the value of key into dst, a pointer. With a codec, dst can be of any type the codec decodes
+// into. Without, the value must be assignable to it, or both must be numbers.
+func (s *shardedMapStore) GetInto(ctx context.Context, key string, dst interface{}) error {
+	data, _, err := s.getData(ctx, key, s.refreshLoader)
+	if err != nil {
+		return err
+	}
+	if _, isCollection := data.(collection); isCollection {
+		return keyError("get", key, ErrWrongType)
+	}
+	data, compressed, err := s.decompress(data)
+	if err != nil {
+		return keyError("get", key, err)
+	}
+	if s.codec != nil {
+		err = s.codec.Unmarshal(data.([]byte), dst)
+		if err != nil {
+			err = &codecError{err}
+		}
+	} else {
+		if !compressed {
+			data = s.cloneOnGet(data)
 		}
-		s.linkedListMutex.Unlock()
+		err = assignDecoded(dst, data)
+	}
+	if err != nil {
+		return keyError("get", key, err)
 	}
+	return nil
+}
 
-	if sm.opCount >= triggeringEvictionOptNum {
-		go func() {
-			// TODO: Except for this, there are other occurrences that should trigger eviction
-			evictShardedMap(sm) // This function would require the lock
-			sm.opCount = 0
-		}()
+// GetWithStale is Get also reporting whether the value is past its soft timeout. A stale value is being
+// refreshed in the background.
+func (s *shardedMapStore) GetWithStale(ctx context.Context, key string) (value interface{}, stale bool, err error) {
+	return s.get(ctx, key, s.refreshLoader)
+}
+
+// get read the value of key. A stale value, or one close enough to its deadline for refresh-ahead, is refreshed
+// in the background with loader if there is one.
+func (s *shardedMapStore) get(ctx context.Context, key string, loader Loader) (value interface{}, stale bool, err error) {
+	data, stale, err := s.getData(ctx, key, loader)
+	if err != nil {
+		return nil, false, err
+	}
+	value, err = s.valueOf(data)
+	if err != nil {
+		return nil, false, keyError("get", key, err)
+	}
+	return value, stale, nil
+}
+
+// peek return the value held by the data of an entry, and whether it is a copy. The caller must not modify the
+// value if it isn't.
+func (s *shardedMapStore) peek(data interface{}) (value interface{}, copied bool, err error) {
+	if _, isCollection := data.(collection); isCollection {
+		return nil, false, ErrWrongType
+	}
+	value, copied, err = s.decompress(data)
+	if err == nil && s.codec != nil {
+		value, err = s.decode(value)
+		copied = true
+	}
+	return value, copied, err
+}
+
+// valueOf return the value held by the data of an entry, copied if the clone mode asks for it
+func (s *shardedMapStore) valueOf(data interface{}) (interface{}, error) {
+	value, copied, err := s.peek(data)
+	if err != nil || copied {
+		return value, err
 	}
-	return Success
+	return s.cloneOnGet(value), nil
 }
 
-func (s *shardedMapStore) Get(key string) (value interface{}, code ErrorCode) {
+// getData return the data of the entry of key, encoded with a codec and maybe compressed. The stored data is
+// never modified, so it can be decoded after the shard is unlocked.
+func (s *shardedMapStore) getData(ctx context.Context, key string, loader Loader) (data interface{}, stale bool, err error) {
 	sm := s.selectSharedMap(key)
-	sm.mu.Lock()
+	if err := sm.lock(ctx); err != nil {
+		return nil, false, keyError("get", key, err)
+	}
 	defer sm.mu.Unlock()
+	data, stale, ok := s.read(sm, key, s.now(), loader)
+	if !ok {
+		return nil, false, keyError("get", key, ErrKeyNotFound)
+	}
+	return data, stale, nil
+}
+
+// read return the data of the live entry of key, see getData. The caller must hold the lock of sm.
+func (s *shardedMapStore) read(sm *shardedMap, key string, now int64, loader Loader) (data interface{}, stale bool, ok bool) {
 	e, ok := sm.m[key]
 	if !ok {
-		return nil, KeyNotFound
+		if s.tinyLFU != nil {
+			s.tinyLFU.recordMiss(sm, key)
+		}
+		return nil, false, false
 	}
-	if time.Now().UnixNano() > e.deadline {
+	if now > e.deadline {
 		// The key was timeout. Evict it.
-		delete(sm.m, key)
-		return nil, KeyNotFound
+		s.removeEntry(sm, key, e)
+		return nil, false, false
+	}
+	s.recordAccess(sm, e, true)
+	if e.sliding {
+		s.restart(sm, e, now)
 	}
 
-	if s.lru {
-		s.linkedListMutex.Lock()
-		// move the entry to the head of linked list
-		s.moveEntryToFront(e)
-		s.linkedListMutex.Unlock()
+	stale = now > e.softDeadline
+	if stale {
+		atomic.AddInt64(&s.stats.StaleHits, 1)
+	}
+	refreshAhead := s.refreshAhead != 0 && e.deadline != maxInt64 && now > e.deadline-int64(s.refreshAhead)
+	if (stale || refreshAhead) && loader != nil {
+		s.loads.refresh(key, loader, e.version, atomic.LoadUint64(&s.versions))
 	}
 
-	// TODO: This is terrible. If return the data directly, users can edit the data outside the cache store.
-	return e.data, Success
+	return e.data, stale, true
 }
 
-func (s *shardedMapStore) Delete(key string) ErrorCode {
-	e := s.deleteKeyFromMap(key)
-	if s.lru {
-		s.linkedListMutex.Lock()
-		s.evictEntryFromLL(e)
-		s.linkedListMutex.Unlock()
-		s.length--
-	}
-	return Success
+func (s *shardedMapStore) Delete(key string) error {
+	return s.DeleteCtx(context.Background(), key)
 }
 
-// Increase increase the number stored at the key by one. Set the value to 1 if the key is not exist.
-// Return an error if the stored value is not an "integer". Support int, uint32, uint64
-func (s *shardedMapStore) Increase(key string) ErrorCode {
+func (s *shardedMapStore) DeleteCtx(ctx context.Context, key string) error {
 	sm := s.selectSharedMap(key)
-	sm.mu.Lock()
+	if err := sm.lock(ctx); err != nil {
+		return keyError("delete", key, err)
+	}
 	defer sm.mu.Unlock()
 
-	var e *entry
+	if err := s.persist(ctx, key, nil, true); err != nil {
+		return keyError("delete", key, err)
+	}
+	if e, ok := sm.m[key]; ok {
+		s.removeEntry(sm, key, e)
+	}
+	s.markDeleted(sm)
+	return nil
+}
+
+// Increase increase the number stored at the key by one. Set the value to 1 if the key is not exist.
+// Return an error if the stored value is not an "integer". See IncrBy.
+func (s *shardedMapStore) Increase(key string) error {
+	return s.IncreaseCtx(context.Background(), key)
+}
+
+func (s *shardedMapStore) IncreaseCtx(ctx context.Context, key string) error {
+	_, err := s.incrBy(ctx, "increase", key, 1)
+	return err
+}
+
+// IncrBy add delta to the integer stored at key and return the result. A missing key is set to delta with the
+// default timeout. The value keeps its type, any of the Go integer types, or a decimal integer held by a []byte or
+// a string. ErrNotInteger is returned for the other values, and ErrOverflow if the result doesn't fit the type or
+// an int64.
+func (s *shardedMapStore) IncrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	return s.incrBy(ctx, "incr by", key, delta)
+}
+
+// DecrBy subtract delta from the integer stored at key, see IncrBy
+func (s *shardedMapStore) DecrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	if delta == math.MinInt64 {
+		return 0, keyError("decr by", key, ErrOverflow)
+	}
+	return s.incrBy(ctx, "decr by", key, -delta)
+}
+
+func (s *shardedMapStore) incrBy(ctx context.Context, op, key string, delta int64) (int64, error) {
+	var n int64
+	err := s.modify(ctx, op, key, func(current interface{}, ok bool) (value interface{}, err error) {
+		if !ok {
+			n = delta
+			return int(delta), nil
+		}
+		value, n, err = incrementBy(current, delta)
+		return value, err
+	})
+	return n, err
+}
+
+// IncrByFloat add delta to the number stored at key and return the result. A missing key is set to delta with the
+// default timeout. A float32 stays a float32, a []byte or a string holds the result in decimal, and the Go integer
+// types become a float64. ErrNotFloat is returned for the other values, and ErrOverflow if the result isn't finite.
+func (s *shardedMapStore) IncrByFloat(ctx context.Context, key string, delta float64) (float64, error) {
+	var f float64
+	err := s.modify(ctx, "incr by float", key, func(current interface{}, ok bool) (value interface{}, err error) {
+		if !ok {
+			current = float64(0)
+		}
+		value, f, err = incrementByFloat(current, delta)
+		return value, err
+	})
+	return f, err
+}
+
+// modify replace the value of key with what apply returns for the current one, under the shard lock. ok is false
+// when the key is missing, and the new value then gets the default timeout.
+func (s *shardedMapStore) modify(ctx context.Context, op, key string, apply func(current interface{}, ok bool) (interface{}, error)) error {
+	sm := s.selectSharedMap(key)
+	if err := sm.lock(ctx); err != nil {
+		return keyError(op, key, err)
+	}
 
+	now := s.now()
+	var current interface{}
 	e, ok := sm.m[key]
+	if ok && now > e.deadline {
+		// The key was timeout. Evict it.
+		s.removeEntry(sm, key, e)
+		ok = false
+	}
+	if ok {
+		var err error
+		if current, _, err = s.peek(e.data); err != nil {
+			sm.mu.Unlock()
+			return keyError(op, key, err)
+		}
+	}
+	value, err := apply(current, ok)
+	if err != nil {
+		sm.mu.Unlock()
+		return keyError(op, key, err)
+	}
+
+	stored := value
+	if s.codec != nil {
+		stored, err = s.encode(value)
+	}
+	if err == nil {
+		stored, err = s.compress(stored)
+	}
+	if err != nil {
+		sm.mu.Unlock()
+		return keyError(op, key, err)
+	}
+	if err := s.persist(ctx, key, value, false); err != nil {
+		sm.mu.Unlock()
+		return keyError(op, key, err)
+	}
+
+	size := entrySize(key, stored)
 	if !ok {
-		if s.lru {
-			e = &entry{
-				data:     1,
-				deadline: maxInt64,
-				key:      key,
-			}
-		} else {
-			e = &entry{
-				data:     1,
-				deadline: maxInt64,
-			}
+		deadline := maxInt64
+		if s.defaultTimeout != 0 {
+			deadline = now + int64(s.defaultTimeout)
 		}
-		sm.m[key] = e
-		s.length++
+		e = s.newEntry(key, stored, deadline, size)
+		e.timeout = int64(s.defaultTimeout)
+		e.sliding = s.slidingExpiration && s.defaultTimeout != 0
+		s.addEntry(sm, key, e)
 	} else {
-		switch data := e.data.(type) {
-		case int:
-			e.data = data + 1
-		case uint32:
-			e.data = data + 1
-		case uint64:
-			e.data = data + 1
-		default:
-			return ValueNotNumberType
-		}
+		atomic.AddInt64(&sm.memUsage, size-e.size)
+		e.data = stored
+		e.size = size
 	}
+	e.version = atomic.AddUint64(&s.versions, 1)
 
-	if s.lru {
-		s.linkedListMutex.Lock()
-		// TODO: The following codes are duplicated
-		if s.head == nil {
-			// first key case
-			s.head = e
-			s.tail = s.head
-		} else {
-			if ok {
-				// The key already be in cache. Don't need to create new node in linked list
-				s.moveEntryToFront(e)
-			} else {
-				s.addEntryToFront(e)
-
-				if s.length > s.capacity {
-					s.lruEvict()
-				}
-			}
-		}
-		s.linkedListMutex.Unlock()
+	s.recordAccess(sm, e, ok)
+	if ok && e.sliding {
+		s.restart(sm, e, now)
 	}
+	if s.lruMode == LRUPerShard {
+		s.shardLRUEvict(sm, e)
+	}
+	sm.mu.Unlock()
+
+	s.evictIfNeeded(key)
+	return nil
+}
 
-	return Success
+func (s *shardedMapStore) GetTTL(key string) (int64, error) {
+	return s.GetTTLCtx(context.Background(), key)
 }
 
-func (s *shardedMapStore) GetTTL(key string) (int64, ErrorCode) {
+func (s *shardedMapStore) GetTTLCtx(ctx context.Context, key string) (int64, error) {
 	sm := s.selectSharedMap(key)
-	sm.mu.Lock()
+	if err := sm.lock(ctx); err != nil {
+		return 0, keyError("get ttl", key, err)
+	}
 	defer sm.mu.Unlock()
 	v, ok := sm.m[key]
 	if !ok {
-		return 0, KeyNotFound
+		return 0, keyError("get ttl", key, ErrKeyNotFound)
 	}
-	if time.Now().UnixNano() > v.deadline {
+	if s.now() > v.deadline {
 		// The key was timeout. Evict it.
-		delete(sm.m, key)
-		return 0, KeyNotFound
+		s.removeEntry(sm, key, v)
+		return 0, keyError("get ttl", key, ErrKeyNotFound)
+	}
+	return v.deadline, nil
+}
+
+// GetRemainingTTL return how long key has left to live, or NoExpiry if it has no timeout
+func (s *shardedMapStore) GetRemainingTTL(ctx context.Context, key string) (time.Duration, error) {
+	var ttl time.Duration
+	err := s.updateDeadline(ctx, "get ttl", key, func(sm *shardedMap, e *entry, now int64) bool {
+		ttl = NoExpiry
+		if e.deadline != maxInt64 {
+			ttl = time.Duration(e.deadline - now)
+		}
+		return true
+	})
+	return ttl, err
+}
+
+// Expire give key a new timeout, which slides if the key's did. A timeout which isn't positive deletes the key.
+func (s *shardedMapStore) Expire(ctx context.Context, key string, timeout time.Duration) error {
+	return s.updateDeadline(ctx, "expire", key, func(sm *shardedMap, e *entry, now int64) bool {
+		if timeout <= 0 {
+			return false
+		}
+		e.timeout = int64(timeout)
+		s.restart(sm, e, now)
+		return true
+	})
+}
+
+// ExpireAt make key expire at deadline. A deadline which already passed deletes the key.
+func (s *shardedMapStore) ExpireAt(ctx context.Context, key string, deadline time.Time) error {
+	return s.updateDeadline(ctx, "expire at", key, func(sm *shardedMap, e *entry, now int64) bool {
+		d := deadline.UnixNano()
+		if d <= now {
+			return false
+		}
+		e.timeout = d - now
+		s.restart(sm, e, now)
+		return true
+	})
+}
+
+// Persist remove the timeout of key, and report whether it had one
+func (s *shardedMapStore) Persist(ctx context.Context, key string) (bool, error) {
+	persisted := false
+	err := s.updateDeadline(ctx, "persist", key, func(sm *shardedMap, e *entry, now int64) bool {
+		persisted = e.deadline != maxInt64
+		e.timeout = 0
+		e.sliding = false
+		e.deadline = maxInt64
+		sm.expiry.schedule(e)
+		return true
+	})
+	return persisted, err
+}
+
+// Touch restart the timeout of key, as if it was set again, and count it as accessed for the eviction
+func (s *shardedMapStore) Touch(ctx context.Context, key string) error {
+	return s.updateDeadline(ctx, "touch", key, func(sm *shardedMap, e *entry, now int64) bool {
+		s.recordAccess(sm, e, true)
+		s.restart(sm, e, now)
+		return true
+	})
+}
+
+// updateDeadline call update with the live entry of key under the shard lock. The entry is deleted if update
+// returns false.
+func (s *shardedMapStore) updateDeadline(ctx context.Context, op, key string, update func(sm *shardedMap, e *entry, now int64) bool) error {
+	sm := s.selectSharedMap(key)
+	if err := sm.lock(ctx); err != nil {
+		return keyError(op, key, err)
+	}
+	defer sm.mu.Unlock()
+	e, ok := sm.m[key]
+	if !ok {
+		return keyError(op, key, ErrKeyNotFound)
+	}
+	now := s.now()
+	if now > e.deadline {
+		// The key was timeout. Evict it.
+		s.removeEntry(sm, key, e)
+		return keyError(op, key, ErrKeyNotFound)
+	}
+	if !update(sm, e, now) {
+		s.removeEntry(sm, key, e)
+		s.markDeleted(sm)
+	}
+	return nil
+}
+
+// restart push the deadline of e its timeout past now. The caller must hold the lock of the entry's shard.
+func (s *shardedMapStore) restart(sm *shardedMap, e *entry, now int64) {
+	if e.timeout == 0 {
+		return
 	}
-	return v.deadline, Success
+	e.deadline = now + e.timeout
+	sm.expiry.schedule(e)
 }
 
-func (s *shardedMapStore) Close() ErrorCode {
-	s.shardedMaps = nil
-	return Success
+// GetMemoryUsage return the estimated bytes used by all the entries in the store
+func (s *shardedMapStore) GetMemoryUsage() int64 {
+	var total int64
+	for i := 0; i < len(s.shardedMaps); i++ {
+		total += atomic.LoadInt64(&s.shardedMaps[i].memUsage)
+	}
+	return total
+}
+
+// Close stop the background work of the store. With WriteBehind, the pending writes are flushed first and
+// the error tells how many of them were given up.
+func (s *shardedMapStore) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		if s.stopJanitor != nil {
+			s.stopJanitor()
+		}
+		if s.writeBehind != nil {
+			err = s.stopWriteBehind()
+		}
+		s.shardedMaps = nil
+	})
+	return err
+}
+
+// now return the current timestamp nanosecond of the store clock
+func (s *shardedMapStore) now() int64 {
+	return s.clock.Now().UnixNano()
+}
+
+func (s *shardedMapStore) setClock(clock Clock) {
+	// s method can only be called at init stage of cache
+	s.clock = clock
 }
 
 func (s *shardedMapStore) setDefaultTimeout(timeout time.Duration) {
@@ -283,6 +840,104 @@ func (s *shardedMapStore) setEvictionPolicy(policy EvictionPolicy) {
 	s.evictionPolicy = policy
 }
 
+func (s *shardedMapStore) setEvictionSamples(n int) {
+	// s method can only be called at init stage of cache
+	s.evictionSamples = n
+}
+
+func (s *shardedMapStore) setLFULogFactor(factor int) {
+	// s method can only be called at init stage of cache
+	s.lfuLogFactor = factor
+}
+
+func (s *shardedMapStore) setLFUDecayTime(d time.Duration) {
+	// s method can only be called at init stage of cache
+	s.lfuDecayTime = d
+}
+
+func (s *shardedMapStore) setLRUMode(mode LRUMode) {
+	// s method can only be called at init stage of cache
+	s.lruMode = mode
+}
+
+func (s *shardedMapStore) setSlidingExpiration(enabled bool) {
+	// s method can only be called at init stage of cache
+	s.slidingExpiration = enabled
+}
+
+func (s *shardedMapStore) setExpiryInterval(interval time.Duration) {
+	// s method can only be called at init stage of cache
+	s.expiryInterval = interval
+}
+
+func (s *shardedMapStore) setLoaderTTL(ttl time.Duration) {
+	// s method can only be called at init stage of cache
+	s.loadTTL = ttl
+}
+
+func (s *shardedMapStore) setNegativeTTL(ttl time.Duration) {
+	// s method can only be called at init stage of cache
+	s.negativeTTL = ttl
+}
+
+func (s *shardedMapStore) setStaleAfter(d time.Duration) {
+	// s method can only be called at init stage of cache
+	s.staleAfter = d
+}
+
+func (s *shardedMapStore) setRefreshAhead(d time.Duration) {
+	// s method can only be called at init stage of cache
+	s.refreshAhead = d
+}
+
+func (s *shardedMapStore) setRefreshLoader(loader Loader) {
+	// s method can only be called at init stage of cache
+	s.refreshLoader = loader
+}
+
+func (s *shardedMapStore) setCloneMode(mode CloneMode) {
+	// s method can only be called at init stage of cache
+	s.cloneMode = mode
+}
+
+func (s *shardedMapStore) setCloner(cloner Cloner) {
+	// s method can only be called at init stage of cache
+	s.cloner = cloner
+}
+
+func (s *shardedMapStore) setShardCount(n int) {
+	// s method can only be called at init stage of cache
+	s.shardCount = roundShardCount(n)
+}
+
+func (s *shardedMapStore) setHasher(hasher Hasher) {
+	// s method can only be called at init stage of cache
+	s.hash = hasher
+}
+
+func (s *shardedMapStore) setCodec(codec Codec) {
+	// s method can only be called at init stage of cache
+	s.codec = codec
+}
+
+func (s *shardedMapStore) setCompression(compressor Compressor, threshold int) {
+	// s method can only be called at init stage of cache
+	s.compressor = compressor
+	s.compressThreshold = threshold
+}
+
+func (s *shardedMapStore) setBackend(backend Backend, mode WriteMode) {
+	// s method can only be called at init stage of cache
+	s.backend = backend
+	s.writeMode = mode
+}
+
+func (s *shardedMapStore) setWriteBehindFlush(interval time.Duration, maxRetries int) {
+	// s method can only be called at init stage of cache
+	s.writeBehindInterval = interval
+	s.writeBehindRetries = maxRetries
+}
+
 func (s *shardedMapStore) setMaxMemory(size int64) {
 	s.maxMemory = size
 }
@@ -293,143 +948,196 @@ func (s *shardedMapStore) setCapacity(cap int) {
 }
 
 // dumpAllJSON print all the data in cache in json format including the timeout data
-func (s *shardedMapStore) DumpAllJSON() (string, ErrorCode) {
+func (s *shardedMapStore) DumpAllJSON() (string, error) {
 	// TODO: Maybe can support also dump the timeout of each cache key?
 	// TODO: Support limiting the output
 	totalSize := 0
 	for i := 0; i < len(s.shardedMaps); i++ {
 		sm := &s.shardedMaps[i]
-		sm.mu.RLock()
+		sm.mu.Lock()
 		totalSize += len(sm.m)
-		sm.mu.RUnlock()
+		sm.mu.Unlock()
 	}
 
 	res := make(map[string]interface{}, totalSize)
+	collections := make(map[string]interface{})
+	now := s.now()
 	for i := 0; i < len(s.shardedMaps); i++ {
 		sm := &s.shardedMaps[i]
-		sm.mu.RLock()
+		sm.mu.Lock()
 		for key, entryValue := range sm.m {
+			if now > entryValue.deadline {
+				// expired, waiting for the janitor
+				continue
+			}
+			if c, isCollection := entryValue.data.(collection); isCollection {
+				// copied under the lock, the collections are modified in place
+				collections[key] = c.snapshot(now)
+				continue
+			}
 			res[key] = entryValue.data
 		}
-		sm.mu.RUnlock()
+		sm.mu.Unlock()
+	}
+	if s.codec != nil || s.compressor != nil {
+		_, isJSON := s.codec.(JSONCodec)
+		for key, data := range res {
+			value, _, err := s.decompress(data)
+			if err == nil && isJSON {
+				value = json.RawMessage(value.([]byte))
+			} else if err == nil && s.codec != nil {
+				value, err = s.decode(value)
+			}
+			if err != nil {
+				return "", fmt.Errorf("dump all json: %w", keyError("decode", key, err))
+			}
+			res[key] = value
+		}
+	}
+	for key, snapshot := range collections {
+		res[key] = snapshot
 	}
 
 	resBytes, err := json.Marshal(res)
 	if err != nil {
-		return "", JSONMarshalErr
+		return "", fmt.Errorf("dump all json: %w", err)
 	}
-	return string(resBytes), Success
+	return string(resBytes), nil
 }
 
-// evictShardedMap loop though the sharded map and evict the expired key
-func evictShardedMap(sm *shardedMap) {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-	now := time.Now().UnixNano()
-	for k, e := range sm.m {
-		if e.deadline <= now {
-			delete(sm.m, k)
-		}
+func (s *shardedMapStore) newEntry(key string, value interface{}, deadline int64, size int64) *entry {
+	return &entry{
+		data:         value,
+		deadline:     deadline,
+		softDeadline: maxInt64,
+		size:         size,
+		key:          key,
 	}
 }
 
-func (s *shardedMapStore) moveEntryToFront(e *entry) {
-	// TODO: There are some duplicated codes.
-	if e == s.head {
-		return
-	} else if e == s.tail {
-		prev := e.prev
-		prev.next = nil
-		s.tail = prev
-
-		e.next = s.head
-		s.head.prev = e
-		s.head = e
-	} else {
-		prev := e.prev
-		prev.next = e.next
-		e.next.prev = prev
+// addEntry put a new entry into the sharded map. The caller must hold the lock of sm.
+func (s *shardedMapStore) addEntry(sm *shardedMap, key string, e *entry) {
+	sm.m[key] = e
+	sm.expiry.schedule(e)
+	atomic.AddInt64(&sm.memUsage, e.size)
+	atomic.AddInt64(&s.length, 1)
+}
+
+// removeEntry is the only way an entry should leave the store. It keeps the memory usage, the key count
+// and the LRU linked list in step with the map. The caller must hold the lock of sm.
+func (s *shardedMapStore) removeEntry(sm *shardedMap, key string, e *entry) {
+	delete(sm.m, key)
+	delete(sm.expiringHashes, key)
+	sm.expiry.unschedule(e)
+	atomic.AddInt64(&sm.memUsage, -e.size)
+	atomic.AddInt64(&s.length, -1)
 
-		e.next = s.head
-		s.head.prev = e
-		s.head = e
+	if s.lru {
+		s.lruRemove(sm, e)
+	}
+	if s.tinyLFU != nil {
+		s.tinyLFU.remove(e)
 	}
 }
 
-func (s *shardedMapStore) addEntryToFront(e *entry) {
-	if s.head == nil {
-		s.head = e
-		s.tail = s.head
-		return
-	}
-	s.head.prev = e
-	e.next = s.head
-	s.head = e
+// markDeleted record that a caller just deleted a key of sm, so that the loads started before don't bring it back.
+// Evictions and expirations aren't recorded. The caller must hold the lock of sm.
+func (s *shardedMapStore) markDeleted(sm *shardedMap) {
+	sm.deleted = atomic.AddUint64(&s.versions, 1)
 }
 
-func (s *shardedMapStore) evictTailFromLL() {
-	if s.tail == nil {
-		// nothing to evict
-		return
+// recordAccess let the eviction policy know e was just read or written. The caller must hold the lock of the
+// entry's shard.
+func (s *shardedMapStore) recordAccess(sm *shardedMap, e *entry, existed bool) {
+	if s.lru {
+		s.lruTouch(sm, e, existed)
 	}
-	prev := s.tail.prev
-	if prev == nil {
-		// only one element in linked list
-		if s.tail != s.head {
-			panic("tail of linked list has no prev")
-		}
-		s.head, s.tail = nil, nil
-		return
+	switch s.evictionPolicy {
+	case EvictionLFU:
+		s.lfuTouch(e, existed)
+	case EvictionTinyLFU:
+		s.tinyLFU.access(sm, e, existed)
 	}
-	s.tail = prev
-	prev.next = nil
 }
 
-func (s *shardedMapStore) evictHeadFromLL() {
-	if s.head == nil {
-		// nothing to evict
-		return
+// overLimit report whether the store hold more keys or more bytes than it is allowed to
+func (s *shardedMapStore) overLimit() bool {
+	if s.capacity != 0 && atomic.LoadInt64(&s.length) > int64(s.capacity) {
+		return true
 	}
-	next := s.head.next
-	if next == nil {
-		// only one element in linked list
-		if s.tail != s.head {
-			panic("head of linked list has no next")
-		}
-		s.head, s.tail = nil, nil
-		return
+	if s.maxMemory != 0 && s.GetMemoryUsage() > s.maxMemory {
+		return true
 	}
-	s.head = next
-	next.prev = nil
+	return false
 }
 
-func (s *shardedMapStore) evictEntryFromLL(e *entry) {
-	if e == s.tail {
-		s.evictTailFromLL()
-	} else if e == s.head {
-		s.evictHeadFromLL()
-	} else {
-		e.prev.next, e.next.prev = e.next, e.prev
+// evictIfNeeded evict entries according to the eviction policy until the store is back under its limits.
+// The key just written is kept unless it is the only candidate left. It must be called without holding any shard lock.
+func (s *shardedMapStore) evictIfNeeded(keep string) {
+	if s.lru && s.lruMode == LRUPerShard {
+		// Each shard already enforced its own slice of the limits
+		return
+	}
+	for s.overLimit() {
+		var evicted bool
+		if s.lru && s.lruMode == LRUPerShardApprox {
+			evicted = s.sampledLRUEvict(keep)
+		} else if s.lru {
+			evicted = s.lruEvict()
+		} else if s.tinyLFU != nil {
+			evicted = s.tinyLFUEvict()
+		} else if s.evictionPolicy == EvictionLFU {
+			evicted = s.lfuEvict(keep)
+		} else {
+			evicted = s.randomEvict(keep)
+		}
+		if !evicted {
+			return
+		}
 	}
 }
 
-func (s *shardedMapStore) deleteKeyFromMap(key string) (e *entry) {
-	sm := s.selectSharedMap(key)
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
+// randomEvict evict a sampled entry. It starts from a random shard and samples up to evictionSamples entries
+// of the first shard with a candidate other than keep, relying on the randomized map iteration order. An expired
+// entry among the samples is preferred, otherwise the first sampled entry is evicted. Only one shard lock is held
+// at a time.
+func (s *shardedMapStore) randomEvict(keep string) bool {
+	now := s.now()
+	start := rand.Intn(len(s.shardedMaps))
+	for i := 0; i < len(s.shardedMaps); i++ {
+		sm := &s.shardedMaps[(start+i)%len(s.shardedMaps)]
+		sm.mu.Lock()
 
-	e, _ = sm.m[key]
-	delete(sm.m, key)
+		var victimKey string
+		var victim *entry
+		sampled := 0
+		for k, e := range sm.m {
+			if k == keep {
+				continue
+			}
+			if e.deadline <= now {
+				victimKey, victim = k, e
+				break
+			}
+			if victim == nil {
+				victimKey, victim = k, e
+			}
+			sampled++
+			if sampled >= s.evictionSamples {
+				break
+			}
+		}
 
-	return e
+		if victim != nil {
+			s.removeEntry(sm, victimKey, victim)
+			sm.mu.Unlock()
+			return true
+		}
+		sm.mu.Unlock()
+	}
+	// keep is the only key left
+	if keep == "" {
+		return false
+	}
+	return s.randomEvict("")
 }
-
-func (s *shardedMapStore) lruEvict() {
-	// TODO: should support evict multiple
-	s.deleteKeyFromMap(s.tail.key)
-
-	// delete from double linked list
-	s.evictTailFromLL()
-
-	s.length--
-}
\ No newline at end of file
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 	"testing"
@@ -10,62 +11,63 @@ import (
 func Test_shardedMapStoreGetAndSetFlow(t *testing.T) {
 	s := GetShardedMapStore()
 	// Get key from empty store
-	v, code := s.Get("123")
-	if code != KeyNotFound {
-		t.Errorf("should_be_error_cache_not_found, got: %v", code)
+	v, err := s.Get("123")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("should_be_error_cache_not_found, got: %v", err)
 	}
 	if v != nil {
 		t.Errorf("value_should_be_nil, got: %v", v)
 	}
 
 	// set the key
-	code = s.SetWithTimeout("123", map[string]string{"jack": "box"}, 1 * time.Minute)
-	if code != Success {
-		t.Errorf("set_cache_error, code: %v", code)
+	err = s.SetWithTimeout("123", map[string]string{"jack": "box"}, 1 * time.Minute)
+	if err != nil {
+		t.Errorf("set_cache_error, err: %v", err)
 	}
 
 	// Get the key just set
-	v, code = s.Get("123")
-	if code != Success {
-		t.Errorf("Get_cache_error, code: %v", code)
+	v, err = s.Get("123")
+	if err != nil {
+		t.Errorf("Get_cache_error, err: %v", err)
 	}
 	if !reflect.DeepEqual(v, map[string]string{"jack": "box"}) {
 		t.Errorf("get_cache_value_incorrect, value: %v, want: %v", v, map[string]string{"jack": "box"})
 	}
 
 	// delete key
-	code = s.Delete("123")
-	if code != Success {
-		t.Errorf("delete_cache_error, code: %v", code)
+	err = s.Delete("123")
+	if err != nil {
+		t.Errorf("delete_cache_error, err: %v", err)
 	}
 
 	// Get the deleted key
-	v, code = s.Get("123")
-	if code != KeyNotFound {
-		t.Errorf("should_be_error_cache_not_found, got: %v", code)
+	v, err = s.Get("123")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("should_be_error_cache_not_found, got: %v", err)
 	}
 }
 
 func Test_shardedMapStore_eviction(t *testing.T) {
-	s := GetShardedMapStore()
-	code := s.SetWithTimeout("evicted_key", "box", 100*time.Millisecond)
-	if code != Success {
-		t.Errorf("set_cache_error, code: %v", code)
+	clock := NewFakeClock(time.Now())
+	s := GetShardedMapStore(SetClock(clock))
+	err := s.SetWithTimeout("evicted_key", "box", 100*time.Millisecond)
+	if err != nil {
+		t.Errorf("set_cache_error, err: %v", err)
 	}
-	time.Sleep(100*time.Millisecond)
+	clock.Advance(101*time.Millisecond)
 
 	i := 0
 	for i < 101 {
-		code = s.SetWithTimeout("123", "box", 100*time.Millisecond)
-		if code != Success {
-			t.Errorf("set_cache_error, code: %v", code)
+		err = s.SetWithTimeout("123", "box", 100*time.Millisecond)
+		if err != nil {
+			t.Errorf("set_cache_error, err: %v", err)
 		}
 		i++
 	}
 	// cache key were expired
-	_, code = s.Get("evicted_key")
-	if code != KeyNotFound {
-		t.Errorf("cache_key_should_expired, code: %v", code)
+	_, err = s.Get("evicted_key")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("cache_key_should_expired, err: %v", err)
 	}
 	// Should trigger eviction
 	want := "{\"123\":\"box\"}"
@@ -75,48 +77,46 @@ func Test_shardedMapStore_eviction(t *testing.T) {
 }
 
 func Test_shardedMapStore_SetDefaultTime(t *testing.T) {
-	s := GetShardedMapStore(SetDefaultTimeout(100 * time.Millisecond))
+	clock := NewFakeClock(time.Now())
+	s := GetShardedMapStore(SetDefaultTimeout(100 * time.Millisecond), SetClock(clock))
 
 	// set key with default timeout
-	code := s.Set("default_timeout", "box")
-	if code != Success {
-		t.Errorf("set_cache_error, code: %v", code)
+	err := s.Set("default_timeout", "box")
+	if err != nil {
+		t.Errorf("set_cache_error, err: %v", err)
 	}
 	// get key right after set
-	v, code := s.Get("default_timeout")
-	if code != Success {
-		t.Errorf("get_cache_error, code: %v", code)
+	v, err := s.Get("default_timeout")
+	if err != nil {
+		t.Errorf("get_cache_error, err: %v", err)
 	}
 	if !reflect.DeepEqual(v, "box") {
 		t.Errorf("get_cache_value_incorrect, value: %v, want: %v", v, "box")
 	}
-	time.Sleep(100*time.Millisecond)
+	clock.Advance(101*time.Millisecond)
 
 	// get again after timeout
-	_, code = s.Get("default_timeout")
-	if code != KeyNotFound {
-		t.Errorf("cache_key_should_expired, code: %v", code)
+	_, err = s.Get("default_timeout")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("cache_key_should_expired, err: %v", err)
 	}
 }
 
-// TODO: user modify the data outside the in-memory cache should not affect the data in the cache
-// Means need to deep copy the data?
-
 func Test_DumpAllJSON(t *testing.T) {
 	s := GetShardedMapStore()
 
-	code := s.Set("Best", "Bites!")
-	if code != Success {
-		t.Errorf("set_cache_error, code: %v", code)
+	err := s.Set("Best", "Bites!")
+	if err != nil {
+		t.Errorf("set_cache_error, err: %v", err)
 	}
-	code = s.Set("Timbre", "+")
-	if code != Success {
-		t.Errorf("set_cache_error, code: %v", code)
+	err = s.Set("Timbre", "+")
+	if err != nil {
+		t.Errorf("set_cache_error, err: %v", err)
 	}
 
-	jsonStr, code := s.DumpAllJSON()
-	if code != Success {
-		t.Errorf("dump_all_json_error, code: %v", code)
+	jsonStr, err := s.DumpAllJSON()
+	if err != nil {
+		t.Errorf("dump_all_json_error, err: %v", err)
 	}
 	want := "{\"Best\":\"Bites!\",\"Timbre\":\"+\"}"
 	if jsonStr != want {
@@ -128,45 +128,45 @@ func Test_DumpAllJSON(t *testing.T) {
 func Test_Increase(t *testing.T) {
 	s := GetShardedMapStore()
 
-	code := s.Increase("desert")
-	if code != Success {
-		t.Errorf("incr_non_existed_key_err, code: %v", code)
+	err := s.Increase("desert")
+	if err != nil {
+		t.Errorf("incr_non_existed_key_err, err: %v", err)
 	}
 
-	v, code := s.Get("desert")
-	if code != Success {
-		t.Errorf("get_cache_error, code: %v", code)
+	v, err := s.Get("desert")
+	if err != nil {
+		t.Errorf("get_cache_error, err: %v", err)
 	}
 	if v.(int) != 1 {
 		t.Errorf("incr_value_err")
 	}
 
-	code = s.Increase("desert")
-	if code != Success {
-		t.Errorf("incr_existed_err, code: %v", code)
+	err = s.Increase("desert")
+	if err != nil {
+		t.Errorf("incr_existed_err, err: %v", err)
 	}
-	v, code = s.Get("desert")
-	if code != Success {
-		t.Errorf("get_cache_error, code: %v", code)
+	v, err = s.Get("desert")
+	if err != nil {
+		t.Errorf("get_cache_error, err: %v", err)
 	}
 	if v.(int) != 2 {
 		t.Errorf("incr_value_err, expected=%v, got=%v", 2, v)
 	}
 
 
-	code = s.Set("gossip", uint32(1234))
-	if code != Success {
-		t.Errorf("set_cache_error, code: %v", code)
+	err = s.Set("gossip", uint32(1234))
+	if err != nil {
+		t.Errorf("set_cache_error, err: %v", err)
 	}
 
-	code = s.Increase("gossip")
-	if code != Success {
-		t.Errorf("incr_existed_key_err, code: %v", code)
+	err = s.Increase("gossip")
+	if err != nil {
+		t.Errorf("incr_existed_key_err, err: %v", err)
 	}
 
-	v, code = s.Get("gossip")
-	if code != Success {
-		t.Errorf("get_cache_error, code: %v", code)
+	v, err = s.Get("gossip")
+	if err != nil {
+		t.Errorf("get_cache_error, err: %v", err)
 	}
 	if v.(uint32) != uint32(1235) {
 		t.Errorf("incr_value_err, expected=%v, got=%v", 1235, v)
@@ -179,7 +179,7 @@ type TestStruct struct {
 }
 
 func Test_modifyCacheDataFromOutside(t *testing.T) {
-	s := GetShardedMapStore()
+	s := GetShardedMapStore(SetCloneMode(CloneOnSetAndGet))
 
 	_ = s.Set("gossip", &TestStruct{5, 50})
 
@@ -200,14 +200,14 @@ func Test_GetTTL(t *testing.T) {
 	s := GetShardedMapStore()
 	_ = s.SetWithTimeout("salmon", "meteor", 5 * time.Minute)
 
-	_, code := s.GetTTL("milktea")
-	if code != KeyNotFound {
-		t.Errorf("should_be_key_not_found, code: %v", code)
+	_, err := s.GetTTL("milktea")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("should_be_key_not_found, err: %v", err)
 	}
 
-	ttl, code := s.GetTTL("salmon")
-	if code != Success {
-		t.Errorf("should_be_success, code: %v", code)
+	ttl, err := s.GetTTL("salmon")
+	if err != nil {
+		t.Errorf("should_be_success, err: %v", err)
 	}
 	fmt.Println("ttl: ", ttl)
 }
@@ -221,8 +221,8 @@ func callFunc(s Store, funcName string, args []interface{}) (resp interface{}) {
 		s.SetWithTimeout(args[0].(string), args[1], args[1].(time.Duration))
 		return nil
 	case "Get":
-		value, code := s.Get(args[0].(string))
-		if code == KeyNotFound {
+		value, err := s.Get(args[0].(string))
+		if errors.Is(err, ErrKeyNotFound) {
 			return -1
 		}
 		return value
@@ -286,4 +286,153 @@ func Test_LRU_flow(t *testing.T) {
 			t.Errorf("return value not correct, res=%v, expected=%v", res, testCase.expected)
 		}
 	}
-}
\ No newline at end of file
+}
+func Test_GetMemoryUsage(t *testing.T) {
+	s := GetShardedMapStore()
+	if usage := s.GetMemoryUsage(); usage != 0 {
+		t.Errorf("empty_store_memory_usage_should_be_zero, got: %v", usage)
+	}
+
+	_ = s.Set("river", []byte("0123456789"))
+	want := entrySize("river", []byte("0123456789"))
+	if usage := s.GetMemoryUsage(); usage != want {
+		t.Errorf("memory_usage_incorrect, got: %v, want: %v", usage, want)
+	}
+
+	// overwrite the key with a bigger value
+	_ = s.Set("river", []byte("01234567890123456789"))
+	want = entrySize("river", []byte("01234567890123456789"))
+	if usage := s.GetMemoryUsage(); usage != want {
+		t.Errorf("memory_usage_incorrect_after_overwrite, got: %v, want: %v", usage, want)
+	}
+
+	_ = s.Delete("river")
+	if usage := s.GetMemoryUsage(); usage != 0 {
+		t.Errorf("memory_usage_should_be_zero_after_delete, got: %v", usage)
+	}
+}
+
+func Test_MaxMemory_LRU(t *testing.T) {
+	value := make([]byte, 1000)
+	limit := 3 * entrySize("key-0", value)
+	s := GetShardedMapStore(SetMaxMemory(fmt.Sprintf("%dB", limit)), SetEvictionPolicy(EvictionLRU))
+
+	for i := 0; i < 3; i++ {
+		if err := s.Set(fmt.Sprintf("key-%d", i), value); err != nil {
+			t.Errorf("set_cache_error, err: %v", err)
+		}
+	}
+	// key-0 becomes the most recently used one
+	_, _ = s.Get("key-0")
+	_ = s.Set("key-3", value)
+
+	if usage := s.GetMemoryUsage(); usage > limit {
+		t.Errorf("memory_usage_exceed_limit, got: %v, limit: %v", usage, limit)
+	}
+	if _, err := s.Get("key-1"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("least_recently_used_key_should_be_evicted, err: %v", err)
+	}
+	for _, key := range []string{"key-0", "key-2", "key-3"} {
+		if _, err := s.Get(key); err != nil {
+			t.Errorf("key_should_not_be_evicted, key: %v, err: %v", key, err)
+		}
+	}
+}
+
+func Test_MaxMemory_ValueTooLarge(t *testing.T) {
+	s := GetShardedMapStore(SetMaxMemory("1KB"))
+	if err := s.Set("huge", make([]byte, 4096)); !errors.Is(err, ErrExceedMaxMemory) {
+		t.Errorf("should_be_exceed_max_memory, err: %v", err)
+	}
+	if usage := s.GetMemoryUsage(); usage != 0 {
+		t.Errorf("rejected_value_should_not_use_memory, got: %v", usage)
+	}
+}
+
+func Test_MaxMemory_Increase(t *testing.T) {
+	limit := 2 * entrySize("counter-0", 1)
+	s := GetShardedMapStore(SetMaxMemory(fmt.Sprintf("%dB", limit)))
+
+	for i := 0; i < 5; i++ {
+		if err := s.Increase(fmt.Sprintf("counter-%d", i)); err != nil {
+			t.Errorf("incr_non_existed_key_err, err: %v", err)
+		}
+	}
+	if usage := s.GetMemoryUsage(); usage > limit {
+		t.Errorf("memory_usage_exceed_limit, got: %v, limit: %v", usage, limit)
+	}
+}
+
+func Test_EvictionRandom_Capacity(t *testing.T) {
+	s := GetShardedMapStore(SetCapacity(10), SetEvictionPolicy(EvictionRandom))
+	for i := 0; i < 100; i++ {
+		if err := s.Set(fmt.Sprintf("key-%d", i), i); err != nil {
+			t.Errorf("set_cache_error, err: %v", err)
+		}
+	}
+
+	found := 0
+	for i := 0; i < 100; i++ {
+		if _, err := s.Get(fmt.Sprintf("key-%d", i)); err == nil {
+			found++
+		}
+	}
+	if found != 10 {
+		t.Errorf("random_eviction_should_keep_capacity_keys, got: %v, want: %v", found, 10)
+	}
+}
+
+func Test_EvictionRandom_KeepsKeyJustSet(t *testing.T) {
+	s := GetShardedMapStore(SetCapacity(3), SetEvictionPolicy(EvictionRandom))
+	for i := 0; i < 4000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if err := s.Set(key, i); err != nil {
+			t.Fatalf("set_cache_error, err: %v", err)
+		}
+		if _, err := s.Get(key); err != nil {
+			t.Fatalf("key_just_set_should_not_be_evicted, key: %v, err: %v", key, err)
+		}
+	}
+}
+
+func Test_EvictionRandom_MaxMemory(t *testing.T) {
+	value := make([]byte, 1000)
+	limit := 5 * entrySize("key-00", value)
+	s := GetShardedMapStore(SetMaxMemory(fmt.Sprintf("%dB", limit)), SetEvictionPolicy(EvictionRandom))
+	for i := 0; i < 50; i++ {
+		_ = s.Set(fmt.Sprintf("key-%02d", i), value)
+		if usage := s.GetMemoryUsage(); usage > limit {
+			t.Fatalf("memory_usage_exceed_limit, got: %v, limit: %v", usage, limit)
+		}
+	}
+}
+
+func Test_EvictionRandom_PreferExpired(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s := GetShardedMapStore(SetCapacity(2), SetEvictionPolicy(EvictionRandom), SetEvictionSamples(defaultShardCount),
+		SetClock(clock), SetExpiryInterval(0))
+	// Put every key into the same shard so a single sample covers all of them
+	keys := sameShardKeys(3)
+	_ = s.Set(keys[0], "fresh")
+	_ = s.SetWithTimeout(keys[1], "stale", time.Millisecond)
+	clock.Advance(2 * time.Millisecond)
+	_ = s.Set(keys[2], "fresh")
+
+	for _, key := range []string{keys[0], keys[2]} {
+		if _, err := s.Get(key); err != nil {
+			t.Errorf("fresh_key_should_not_be_evicted, key: %v, err: %v", key, err)
+		}
+	}
+}
+
+// sameShardKeys generate n keys which are all stored in the same shard
+func sameShardKeys(n int) []string {
+	keys := make([]string, 0, n)
+	for i := 0; len(keys) < n; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if FNVHasher(key)%defaultShardCount == 0 {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+const (
+	// mapSlotOverhead is a rough cost of a key slot in map[string]*entry: the string header, the pointer and
+	// the tophash byte rounded up
+	mapSlotOverhead = 32
+)
+
+var entryOverhead = int64(unsafe.Sizeof(entry{})) + mapSlotOverhead
+
+// entrySize estimate the bytes an entry keyed by key and holding value occupies in the store
+func entrySize(key string, value interface{}) int64 {
+	return entryOverhead + int64(len(key)) + sizeOf(value)
+}
+
+// sizeOf estimate the bytes referenced by value. The common types stored through the TCP server are handled
+// without reflection. Other types are walked with reflect, and memory shared by several pointers is only
+// counted once.
+func sizeOf(value interface{}) int64 {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case []byte:
+		return int64(unsafe.Sizeof(v)) + int64(cap(v))
+	case string:
+		return int64(unsafe.Sizeof(v)) + int64(len(v))
+	case bool, int8, uint8:
+		return 1
+	case int16, uint16:
+		return 2
+	case int32, uint32, float32:
+		return 4
+	case int, uint, int64, uint64, uintptr, float64, complex64:
+		return 8
+	case complex128:
+		return 16
+	case collection:
+		return v.footprint()
+	}
+	rv := reflect.ValueOf(value)
+	return int64(rv.Type().Size()) + indirectSizeOf(rv, make(map[uintptr]struct{}))
+}
+
+// indirectSizeOf return the bytes referenced by v but not stored inline in v itself
+func indirectSizeOf(v reflect.Value, seen map[uintptr]struct{}) int64 {
+	switch v.Kind() {
+	case reflect.String:
+		return int64(v.Len())
+	case reflect.Ptr:
+		if v.IsNil() || visited(v.Pointer(), seen) {
+			return 0
+		}
+		elem := v.Elem()
+		return int64(elem.Type().Size()) + indirectSizeOf(elem, seen)
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		elem := v.Elem()
+		return int64(elem.Type().Size()) + indirectSizeOf(elem, seen)
+	case reflect.Slice:
+		if v.IsNil() || visited(v.Pointer(), seen) {
+			return 0
+		}
+		size := int64(v.Cap()) * int64(v.Type().Elem().Size())
+		for i := 0; i < v.Len(); i++ {
+			size += indirectSizeOf(v.Index(i), seen)
+		}
+		return size
+	case reflect.Array:
+		var size int64
+		for i := 0; i < v.Len(); i++ {
+			size += indirectSizeOf(v.Index(i), seen)
+		}
+		return size
+	case reflect.Map:
+		if v.IsNil() || visited(v.Pointer(), seen) {
+			return 0
+		}
+		slot := int64(v.Type().Key().Size() + v.Type().Elem().Size() + 1)
+		size := int64(v.Len()) * slot
+		iter := v.MapRange()
+		for iter.Next() {
+			size += indirectSizeOf(iter.Key(), seen) + indirectSizeOf(iter.Value(), seen)
+		}
+		return size
+	case reflect.Struct:
+		var size int64
+		for i := 0; i < v.NumField(); i++ {
+			size += indirectSizeOf(v.Field(i), seen)
+		}
+		return size
+	}
+	return 0
+}
+
+func visited(p uintptr, seen map[uintptr]struct{}) bool {
+	if _, ok := seen[p]; ok {
+		return true
+	}
+	seen[p] = struct{}{}
+	return false
+}
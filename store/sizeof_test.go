@@ -0,0 +1,42 @@
+package store
+
+import (
+	"testing"
+	"unsafe"
+)
+
+type sizeOfNode struct {
+	Name string
+	Next *sizeOfNode
+}
+
+func Test_sizeOf(t *testing.T) {
+	loop := &sizeOfNode{Name: "abcd"}
+	loop.Next = loop
+
+	testCases := []struct {
+		name  string
+		value interface{}
+		want  int64
+	}{
+		{"nil", nil, 0},
+		{"bytes", make([]byte, 10, 16), int64(unsafe.Sizeof([]byte{})) + 16},
+		{"string", "hello", int64(unsafe.Sizeof("")) + 5},
+		{"int", 42, 8},
+		{"uint32", uint32(42), 4},
+		{"string slice", []string{"ab", "cde"}, int64(unsafe.Sizeof([]string{})) + 2*int64(unsafe.Sizeof("")) + 5},
+		{"cyclic pointer", loop, int64(unsafe.Sizeof(loop)) + int64(unsafe.Sizeof(*loop)) + 4},
+	}
+
+	for _, testCase := range testCases {
+		if got := sizeOf(testCase.value); got != testCase.want {
+			t.Errorf("size_of_incorrect, case: %v, got: %v, want: %v", testCase.name, got, testCase.want)
+		}
+	}
+
+	small := sizeOf(map[string]string{"a": "b"})
+	large := sizeOf(map[string]string{"a": "b", "long key": "long long value"})
+	if small >= large {
+		t.Errorf("size_of_map_should_grow_with_content, small: %v, large: %v", small, large)
+	}
+}
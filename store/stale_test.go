@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitRefreshes wait until the background refreshes of s are done
+func waitRefreshes(t *testing.T, s Store, key string, want interface{}) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if v, stale, err := s.GetWithStale(context.Background(), key); err == nil && !stale && v == want {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("value_not_refreshed, key: %v, want: %v", key, want)
+}
+
+func Test_StaleWhileRevalidate(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	var version int32
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		return atomic.AddInt32(&version, 1), nil
+	}
+	s := GetShardedMapStore(SetClock(clock), SetRefreshLoader(loader), SetExpiryInterval(0))
+
+	_ = s.SetWithSoftTimeout("pricing", int32(0), time.Second, time.Hour)
+
+	v, stale, err := s.GetWithStale(context.Background(), "pricing")
+	if err != nil || stale || v != int32(0) {
+		t.Errorf("fresh_value_incorrect, v: %v, stale: %v, err: %v", v, stale, err)
+	}
+
+	clock.Advance(2 * time.Second)
+	// served right away even though it is stale
+	v, stale, err = s.GetWithStale(context.Background(), "pricing")
+	if err != nil || !stale || v != int32(0) {
+		t.Errorf("stale_value_should_be_served, v: %v, stale: %v, err: %v", v, stale, err)
+	}
+
+	waitRefreshes(t, s, "pricing", int32(1))
+	if stats := s.GetStats(); stats.Refreshes != 1 || stats.StaleHits < 1 {
+		t.Errorf("stats_incorrect, stats: %+v", stats)
+	}
+}
+
+func Test_StaleWhileRevalidate_ConcurrentSet(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	started := make(chan struct{})
+	release := make(chan struct{})
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		close(started)
+		<-release
+		return "refreshed", nil
+	}
+	s := GetShardedMapStore(SetClock(clock), SetRefreshLoader(loader), SetExpiryInterval(0)).(*shardedMapStore)
+
+	_ = s.SetWithSoftTimeout("pricing", "stale", time.Second, time.Hour)
+	clock.Advance(2 * time.Second)
+	_, _ = s.Get("pricing")
+	<-started
+	_ = s.Set("pricing", "set")
+	close(release)
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		s.loads.mu.Lock()
+		running := len(s.loads.calls)
+		s.loads.mu.Unlock()
+		if running == 0 {
+			break
+		}
+	}
+	// the refresh started before the write doesn't replace it
+	if v, _ := s.Get("pricing"); v != "set" {
+		t.Errorf("refresh_should_not_overwrite_a_newer_value, got: %v, want: %v", v, "set")
+	}
+}
+
+func Test_StaleAfter_GetOrLoad(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s := GetShardedMapStore(SetClock(clock), SetStaleAfter(time.Second), SetLoaderTTL(time.Hour),
+		SetExpiryInterval(0))
+
+	var version int32
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		return atomic.AddInt32(&version, 1), nil
+	}
+
+	if v, err := s.GetOrLoad(context.Background(), "config", loader); err != nil || v != int32(1) {
+		t.Errorf("get_or_load_error, v: %v, err: %v", v, err)
+	}
+	clock.Advance(2 * time.Second)
+	// the stale value is returned, and the loader of GetOrLoad refreshes it
+	if v, err := s.GetOrLoad(context.Background(), "config", loader); err != nil || v != int32(1) {
+		t.Errorf("stale_value_should_be_served, v: %v, err: %v", v, err)
+	}
+	waitRefreshes(t, s, "config", int32(2))
+}
+
+func Test_RefreshAhead(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	var version int32
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		return atomic.AddInt32(&version, 1), nil
+	}
+	s := GetShardedMapStore(SetClock(clock), SetRefreshLoader(loader), SetRefreshAhead(10*time.Second),
+		SetExpiryInterval(0), SetDefaultTimeout(time.Minute))
+
+	_ = s.Set("hot", int32(0))
+	clock.Advance(30 * time.Second)
+	if _, err := s.Get("hot"); err != nil {
+		t.Errorf("get_cache_error, err: %v", err)
+	}
+	if stats := s.GetStats(); stats.Refreshes != 0 {
+		t.Errorf("should_not_refresh_before_refresh_ahead_window, stats: %+v", stats)
+	}
+
+	clock.Advance(25 * time.Second)
+	if v, err := s.Get("hot"); err != nil || v != int32(0) {
+		t.Errorf("get_cache_error, v: %v, err: %v", v, err)
+	}
+	waitRefreshes(t, s, "hot", int32(1))
+
+	// refreshed with a new deadline
+	clock.Advance(30 * time.Second)
+	if v, err := s.Get("hot"); err != nil || v != int32(1) {
+		t.Errorf("refreshed_value_should_not_expire, v: %v, err: %v", v, err)
+	}
+}
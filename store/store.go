@@ -1,6 +1,7 @@
 package store
 
 import (
+	"context"
 	"github.com/docker/go-units"
 	"log"
 	"time"
@@ -8,35 +9,111 @@ import (
 
 const (
 	triggeringEvictionOptNum = 100
-)
-
-// ErrorCode
-type ErrorCode uint32
 
-const (
-	Success            = 1
-	KeyNotFound        = 9001
-	ValueNotNumberType = 9002
-	JSONMarshalErr     = 9003
+	// NoExpiry is the remaining TTL of a key without timeout
+	NoExpiry time.Duration = -1
 )
 
+// Store is a key-value cache. The errors returned wrap the sentinel errors declared in errors.go.
+//
+// Each operation has a Ctx variant which gives up waiting for the shard lock once the context is done,
+// returning an error wrapping ctx.Err().
 type Store interface {
-	Set(key string, value interface{}) ErrorCode
-	SetWithTimeout(key string, value interface{}, timeout time.Duration) ErrorCode
-	Get(key string) (interface{}, ErrorCode)
-	Delete(key string) ErrorCode
-	Increase(key string) ErrorCode
+	Set(key string, value interface{}) error
+	SetWithTimeout(key string, value interface{}, timeout time.Duration) error
+	Get(key string) (interface{}, error)
+	Delete(key string) error
+	Increase(key string) error
+
+	SetCtx(ctx context.Context, key string, value interface{}) error
+	SetWithTimeoutCtx(ctx context.Context, key string, value interface{}, timeout time.Duration) error
+	GetCtx(ctx context.Context, key string) (interface{}, error)
+	DeleteCtx(ctx context.Context, key string) error
+	IncreaseCtx(ctx context.Context, key string) error
+
+	IncrBy(ctx context.Context, key string, delta int64) (int64, error)
+	DecrBy(ctx context.Context, key string, delta int64) (int64, error)
+	IncrByFloat(ctx context.Context, key string, delta float64) (float64, error)
+
+	SetNX(ctx context.Context, key string, value interface{}, timeout time.Duration) (bool, error)
+	SetXX(ctx context.Context, key string, value interface{}, timeout time.Duration) (bool, error)
+	GetSet(ctx context.Context, key string, value interface{}, timeout time.Duration) (old interface{}, existed bool, err error)
+	GetWithVersion(ctx context.Context, key string) (value interface{}, version uint64, err error)
+	// Version return the version of key, 0 if it doesn't exist. It doesn't read the value, so it works on lists
+	// and hashes, isn't counted as an access by the eviction policy and doesn't restart a sliding timeout.
+	Version(ctx context.Context, key string) (uint64, error)
+	CompareAndSwap(ctx context.Context, key string, old, value interface{}, timeout time.Duration) (bool, error)
+	CompareAndSwapVersion(ctx context.Context, key string, version uint64, value interface{}, timeout time.Duration) (bool, error)
 
-	GetTTL(key string) (int64, ErrorCode)
+	MGet(ctx context.Context, keys []string) (map[string]interface{}, error)
+	MSet(ctx context.Context, values map[string]interface{}, timeout time.Duration) error
+	MSetAtomic(ctx context.Context, values map[string]interface{}, timeout time.Duration) error
+	MDelete(ctx context.Context, keys []string) (int, error)
+	Txn(ctx context.Context, keys []string, fn func(tx Tx) error) error
+
+	LPush(ctx context.Context, key string, values ...interface{}) (int, error)
+	RPush(ctx context.Context, key string, values ...interface{}) (int, error)
+	LPop(ctx context.Context, key string) (interface{}, error)
+	RPop(ctx context.Context, key string) (interface{}, error)
+	LRange(ctx context.Context, key string, start, stop int) ([]interface{}, error)
+	LLen(ctx context.Context, key string) (int, error)
+	LIndex(ctx context.Context, key string, index int) (interface{}, error)
+	LTrim(ctx context.Context, key string, start, stop int) error
+
+	HSet(ctx context.Context, key string, values map[string]interface{}) (int, error)
+	HSetWithTimeout(ctx context.Context, key string, values map[string]interface{}, timeout time.Duration) (int, error)
+	HGet(ctx context.Context, key, field string) (interface{}, error)
+	HDel(ctx context.Context, key string, fields ...string) (int, error)
+	HGetAll(ctx context.Context, key string) (map[string]interface{}, error)
+	HIncrBy(ctx context.Context, key, field string, delta int64) (int64, error)
+	HExists(ctx context.Context, key, field string) (bool, error)
+	HLen(ctx context.Context, key string) (int, error)
+
+	GetTTL(key string) (int64, error)
+	GetTTLCtx(ctx context.Context, key string) (int64, error)
+	GetMemoryUsage() int64
+
+	SetWithSlidingTimeout(key string, value interface{}, timeout time.Duration) error
+	GetRemainingTTL(ctx context.Context, key string) (time.Duration, error)
+	Expire(ctx context.Context, key string, timeout time.Duration) error
+	ExpireAt(ctx context.Context, key string, deadline time.Time) error
+	Persist(ctx context.Context, key string) (bool, error)
+	Touch(ctx context.Context, key string) error
+
+	GetOrLoad(ctx context.Context, key string, loader Loader) (interface{}, error)
+	SetWithSoftTimeout(key string, value interface{}, softTimeout, timeout time.Duration) error
+	GetWithStale(ctx context.Context, key string) (value interface{}, stale bool, err error)
+	GetInto(ctx context.Context, key string, dst interface{}) error
+	GetStats() Stats
 
 	setDefaultTimeout(timeout time.Duration)
+	setShardCount(n int)
+	setHasher(hasher Hasher)
+	setClock(clock Clock)
 	setEvictionPolicy(policy EvictionPolicy)
+	setEvictionSamples(n int)
+	setLRUMode(mode LRUMode)
+	setExpiryInterval(interval time.Duration)
+	setSlidingExpiration(enabled bool)
+	setLFULogFactor(factor int)
+	setLFUDecayTime(d time.Duration)
+	setLoaderTTL(ttl time.Duration)
+	setNegativeTTL(ttl time.Duration)
+	setStaleAfter(d time.Duration)
+	setRefreshAhead(d time.Duration)
+	setRefreshLoader(loader Loader)
+	setCloneMode(mode CloneMode)
+	setCloner(cloner Cloner)
+	setCodec(codec Codec)
+	setCompression(compressor Compressor, threshold int)
+	setBackend(backend Backend, mode WriteMode)
+	setWriteBehindFlush(interval time.Duration, maxRetries int)
 	setMaxMemory(size int64)
 	setCapacity(cap int)
 
-	DumpAllJSON() (string, ErrorCode)
+	DumpAllJSON() (string, error)
 
-	Close() ErrorCode
+	Close() error
 }
 
 type Option func(s Store)
@@ -48,6 +125,143 @@ func SetDefaultTimeout(timeout time.Duration) Option {
 	}
 }
 
+// SetShardCount set the number of shards, rounded up to a power of two. More shards mean less contention on
+// the shard locks between the cores, but the per shard limits of LRUPerShard and of the arena store get smaller.
+// The default is 32.
+func SetShardCount(n int) Option {
+	return func(s Store) {
+		if n < 1 || n > 1<<16 {
+			log.Fatal("invalid_shard_count_option, n: ", n)
+			return
+		}
+		s.setShardCount(n)
+	}
+}
+
+// SetHasher replace FNVHasher, the hash picking the shard of a key. Use MapHasher when the keys come from
+// untrusted clients.
+func SetHasher(hasher Hasher) Option {
+	return func(s Store) {
+		if hasher == nil {
+			log.Fatal("invalid_hasher_option, hasher: nil")
+			return
+		}
+		s.setHasher(hasher)
+	}
+}
+
+// SetClock replace the clock used for the TTLs, the eviction and the background expiry. Mostly for tests,
+// see FakeClock.
+func SetClock(clock Clock) Option {
+	return func(s Store) {
+		s.setClock(clock)
+	}
+}
+
+// SetLoaderTTL set the timeout of the values loaded by GetOrLoad. By default they use the default timeout.
+func SetLoaderTTL(ttl time.Duration) Option {
+	return func(s Store) {
+		s.setLoaderTTL(ttl)
+	}
+}
+
+// SetNegativeTTL cache the errors of the loaders of GetOrLoad for ttl, so a failing system of record isn't hammered
+// by every miss. 0, the default, doesn't cache them.
+func SetNegativeTTL(ttl time.Duration) Option {
+	return func(s Store) {
+		s.setNegativeTTL(ttl)
+	}
+}
+
+// SetStaleAfter give the values written by Set, SetWithTimeout and GetOrLoad a soft timeout. Past it, a read
+// still returns the value right away, reports it stale and refreshes it in the background.
+func SetStaleAfter(d time.Duration) Option {
+	return func(s Store) {
+		s.setStaleAfter(d)
+	}
+}
+
+// SetRefreshAhead refresh in the background a value read less than d before its deadline, so a hot key is
+// reloaded before it expires instead of missing.
+func SetRefreshAhead(d time.Duration) Option {
+	return func(s Store) {
+		s.setRefreshAhead(d)
+	}
+}
+
+// SetRefreshLoader register the Loader used to refresh the values read by Get. GetOrLoad refreshes with its own.
+func SetRefreshLoader(loader Loader) Option {
+	return func(s Store) {
+		s.setRefreshLoader(loader)
+	}
+}
+
+// SetCloneMode choose when the values are copied, so the callers mutating what they set or got don't mutate the
+// cached data. See CloneMode. The lists and the hashes are never returned whole, their elements are copied one by
+// one as they are pushed and read.
+func SetCloneMode(mode CloneMode) Option {
+	return func(s Store) {
+		s.setCloneMode(mode)
+	}
+}
+
+// SetCloner replace DeepCopy, the Cloner used by SetCloneMode. E.g. a Cloner calling a Clone method of the values.
+func SetCloner(cloner Cloner) Option {
+	return func(s Store) {
+		if cloner == nil {
+			log.Fatal("invalid_cloner_option, cloner: nil")
+			return
+		}
+		s.setCloner(cloner)
+	}
+}
+
+// SetCodec store the values encoded by codec instead of as they are. The memory accounting becomes exact, the
+// callers can't mutate the cached values, and Get returns what the codec decodes into an interface{}. Use GetInto
+// to decode into a given type. The lists and hashes aren't encoded as a whole: the sharded map store keeps them as
+// structures, which their commands modify in place, and the arena store encodes their elements one by one. Their
+// memory usage stays an estimate.
+func SetCodec(codec Codec) Option {
+	return func(s Store) {
+		s.setCodec(codec)
+	}
+}
+
+// SetCompression compress the []byte and string values, and the values encoded with SetCodec, of at least
+// threshold bytes with compressor. They are decompressed by Get, and their compressed size is what counts
+// against SetMaxMemory. The values compressor doesn't shrink are stored as they are. The elements of the lists and
+// the hashes aren't compressed, since their commands read and modify them one by one.
+func SetCompression(compressor Compressor, threshold int) Option {
+	return func(s Store) {
+		if compressor == nil || threshold < 0 {
+			log.Fatal("invalid_compression_option, compressor: ", compressor, ", threshold: ", threshold)
+			return
+		}
+		s.setCompression(compressor, threshold)
+	}
+}
+
+// SetBackend put the store in front of a system of record. Set, Delete and Increase write to the backend, either
+// synchronously with WriteThrough or batched in the background with WriteBehind. GetOrLoad with a nil Loader
+// loads from it.
+func SetBackend(backend Backend, mode WriteMode) Option {
+	return func(s Store) {
+		s.setBackend(backend, mode)
+	}
+}
+
+// SetWriteBehindFlush set how often WriteBehind flushes the pending writes, and how many times a failed write is
+// retried, with an exponential backoff starting at interval, before it is given up.
+func SetWriteBehindFlush(interval time.Duration, maxRetries int) Option {
+	return func(s Store) {
+		if interval <= 0 || maxRetries < 0 {
+			log.Fatal("invalid_write_behind_flush_option, interval: ", interval, ", max retries: ", maxRetries)
+			return
+		}
+		s.setWriteBehindFlush(interval, maxRetries)
+	}
+}
+
 // SetEvictionPolicy set the policy when the memory usage exceed the threshold
 func SetEvictionPolicy(policy EvictionPolicy) Option {
 	return func(s Store) {
@@ -55,9 +269,68 @@ func SetEvictionPolicy(policy EvictionPolicy) Option {
 	}
 }
 
+// SetExpiryInterval set how often the background janitor removes the expired keys. Each run removes them in small
+// batches per shard, so a shard lock is never held for long. 0 disables the janitor, and the expired keys are only
+// removed when they are accessed or when their shard is busy.
+func SetExpiryInterval(interval time.Duration) Option {
+	return func(s Store) {
+		s.setExpiryInterval(interval)
+	}
+}
+
+// SetSlidingExpiration make every read of a key restart its timeout, so only the keys idle for their whole
+// timeout expire. It applies to the values written by Set, SetWithTimeout, SetWithSoftTimeout and GetOrLoad.
+// SetWithSlidingTimeout does it for a single key.
+func SetSlidingExpiration(enabled bool) Option {
+	return func(s Store) {
+		s.setSlidingExpiration(enabled)
+	}
+}
+
+// SetEvictionSamples set how many entries the random eviction samples before picking a victim.
+// A larger number makes the eviction prefer expired keys more reliably at the cost of a longer shard lock.
+func SetEvictionSamples(n int) Option {
+	return func(s Store) {
+		if n < 1 {
+			log.Fatal("invalid_eviction_samples_option, n: ", n)
+			return
+		}
+		s.setEvictionSamples(n)
+	}
+}
+
+// SetLRUMode choose how EvictionLRU keeps track of the recency. See LRUMode.
+func SetLRUMode(mode LRUMode) Option {
+	return func(s Store) {
+		s.setLRUMode(mode)
+	}
+}
+
+// SetLFULogFactor set how fast the access counter of EvictionLFU saturates. The counter is incremented with
+// probability 1/((counter-initial)*factor+1), so a factor of 10 needs about a million accesses to reach the top.
+// A factor of 0 increments on every access.
+func SetLFULogFactor(factor int) Option {
+	return func(s Store) {
+		if factor < 0 {
+			log.Fatal("invalid_lfu_log_factor_option, factor: ", factor)
+			return
+		}
+		s.setLFULogFactor(factor)
+	}
+}
+
+// SetLFUDecayTime set the period after which an idle key loses one point of its EvictionLFU access counter,
+// so formerly hot keys age out. 0 disables the decay.
+func SetLFUDecayTime(d time.Duration) Option {
+	return func(s Store) {
+		s.setLFUDecayTime(d)
+	}
+}
+
 // SetMaxMemory generate an Option for setting the max memory used by the data
-// Note it only limit the mem usage of the values, not the mem used by the whole process
-// When mem usage exceed this threshold, the stored data would be evicted according to the eviction policy
+// Note it only limit the estimated mem usage of the keys, values and entries, not the mem used by the whole process
+// When mem usage exceed this threshold, the stored data would be evicted according to the eviction policy.
+// A single value larger than the threshold is rejected with ErrExceedMaxMemory
 func SetMaxMemory(sizeHuman string) Option {
 	return func(s Store) {
 		size, err := units.FromHumanSize(sizeHuman)
@@ -71,6 +344,7 @@ func SetMaxMemory(sizeHuman string) Option {
 
 // SetCapacity generate an Option for setting the max keys can be stored in the cache
 // When mem usage exceed this threshold, the stored data would be evicted according to the eviction policy
+// With LRUPerShard the capacity is split between the shards and rounded up, see LRUPerShard
 func SetCapacity(cap int) Option {
 	return func(s Store) {
 		s.setCapacity(cap)
@@ -0,0 +1,1401 @@
+// Package storetest is the conformance suite of the store.Store implementations. A new implementation, or a wrapper
+// around an existing one, is validated by running the suite from its own tests:
+//
+//	func Test_Conformance(t *testing.T) {
+//		storetest.Run(t, store.GetShardedMapStore)
+//	}
+//
+// Run the tests with -race, the suite hammers the store from several goroutines.
+package storetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/colindith/kash/store"
+)
+
+// Factory create the store under test with the given options. Each test creates its own.
+type Factory func(opts ...store.Option) store.Store
+
+// Run run the whole suite against the stores created by newStore
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, newStore Factory)
+	}{
+		{"SetGet", testSetGet},
+		{"Overwrite", testOverwrite},
+		{"Delete", testDelete},
+		{"Increase", testIncrease},
+		{"IncrBy", testIncrBy},
+		{"IncrByFloat", testIncrByFloat},
+		{"SetNX", testSetNX},
+		{"GetSet", testGetSet},
+		{"CompareAndSwap", testCompareAndSwap},
+		{"Batch", testBatch},
+		{"MSetAtomic", testMSetAtomic},
+		{"Txn", testTxn},
+		{"TxnConcurrent", testTxnConcurrent},
+		{"List", testList},
+		{"ListWrongType", testListWrongType},
+		{"ListTTL", testListTTL},
+		{"Hash", testHash},
+		{"HashFieldTTL", testHashFieldTTL},
+		{"HashConcurrent", testHashConcurrent},
+		{"TTL", testTTL},
+		{"ZeroTimeout", testZeroTimeout},
+		{"NegativeTimeout", testNegativeTimeout},
+		{"DefaultTimeout", testDefaultTimeout},
+		{"Expire", testExpire},
+		{"Persist", testPersist},
+		{"SlidingExpiration", testSlidingExpiration},
+		{"Capacity", testCapacity},
+		{"MaxMemory", testMaxMemory},
+		{"DumpAllJSON", testDumpAllJSON},
+		{"Context", testContext},
+		{"GetOrLoad", testGetOrLoad},
+		{"StaleWhileRevalidate", testStaleWhileRevalidate},
+		{"Concurrent", testConcurrent},
+		{"Close", testClose},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore)
+		})
+	}
+}
+
+type point struct {
+	X int
+	Y int
+}
+
+func testSetGet(t *testing.T, newStore Factory) {
+	s := newStore()
+	defer s.Close()
+
+	v, err := s.Get("missing")
+	if !errors.Is(err, store.ErrKeyNotFound) || v != nil {
+		t.Errorf("missing_key_error, v: %v, err: %v, want: %v", v, err, store.ErrKeyNotFound)
+	}
+
+	values := map[string]interface{}{
+		"int":    42,
+		"uint64": uint64(1 << 40),
+		"float":  1.5,
+		"bool":   true,
+		"string": "kash",
+		"empty":  "",
+		"bytes":  []byte("raw"),
+		"struct": point{1, 2},
+		"ptr":    &point{3, 4},
+		"map":    map[string]int{"a": 1},
+		"slice":  []string{"a", "b"},
+		"":       "empty key",
+	}
+	for key, value := range values {
+		if err := s.Set(key, value); err != nil {
+			t.Errorf("set_error, key: %q, err: %v", key, err)
+		}
+	}
+	for key, want := range values {
+		got, err := s.Get(key)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("get_incorrect, key: %q, got: %#v, want: %#v, err: %v", key, got, want, err)
+		}
+	}
+}
+
+func testOverwrite(t *testing.T, newStore Factory) {
+	clock := store.NewFakeClock(time.Now())
+	s := newStore(store.SetClock(clock))
+	defer s.Close()
+
+	_ = s.SetWithTimeout("k", "first", time.Second)
+	_ = s.Set("k", "second")
+	if v, _ := s.Get("k"); v != "second" {
+		t.Errorf("overwrite_incorrect, got: %v, want: %v", v, "second")
+	}
+	// the overwrite replaced the timeout too
+	clock.Advance(time.Minute)
+	if v, err := s.Get("k"); err != nil || v != "second" {
+		t.Errorf("overwrite_should_reset_timeout, v: %v, err: %v", v, err)
+	}
+}
+
+func testDelete(t *testing.T, newStore Factory) {
+	s := newStore()
+	defer s.Close()
+
+	_ = s.Set("k", "v")
+	if err := s.Delete("k"); err != nil {
+		t.Errorf("delete_error, err: %v", err)
+	}
+	if _, err := s.Get("k"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("deleted_key_error, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+	if err := s.Delete("k"); err != nil {
+		t.Errorf("delete_missing_key_error, err: %v", err)
+	}
+	// deleted keys can be set again
+	_ = s.Set("k", "again")
+	if v, _ := s.Get("k"); v != "again" {
+		t.Errorf("set_after_delete_incorrect, got: %v, want: %v", v, "again")
+	}
+}
+
+func testIncrease(t *testing.T, newStore Factory) {
+	s := newStore()
+	defer s.Close()
+
+	tests := []struct {
+		initial interface{}
+		want    interface{}
+	}{
+		{41, 42},
+		{uint32(41), uint32(42)},
+		{uint64(41), uint64(42)},
+	}
+	for _, tt := range tests {
+		_ = s.Set("counter", tt.initial)
+		if err := s.Increase("counter"); err != nil {
+			t.Errorf("increase_error, initial: %T, err: %v", tt.initial, err)
+		}
+		if v, _ := s.Get("counter"); v != tt.want {
+			t.Errorf("increase_incorrect, got: %#v, want: %#v", v, tt.want)
+		}
+	}
+
+	if err := s.Increase("new"); err != nil {
+		t.Errorf("increase_new_key_error, err: %v", err)
+	}
+	if v, _ := s.Get("new"); v != 1 {
+		t.Errorf("increase_new_key_incorrect, got: %#v, want: %#v", v, 1)
+	}
+
+	_ = s.Set("string", "kash")
+	if err := s.Increase("string"); !errors.Is(err, store.ErrNotInteger) {
+		t.Errorf("increase_string_error, got: %v, want: %v", err, store.ErrNotInteger)
+	}
+	if v, _ := s.Get("string"); v != "kash" {
+		t.Errorf("failed_increase_should_keep_value, got: %v, want: %v", v, "kash")
+	}
+}
+
+func testIncrBy(t *testing.T, newStore Factory) {
+	clock := store.NewFakeClock(time.Now())
+	s := newStore(store.SetClock(clock), store.SetDefaultTimeout(time.Minute))
+	defer s.Close()
+	ctx := context.Background()
+
+	tests := []struct {
+		initial interface{}
+		delta   int64
+		want    interface{}
+		n       int64
+	}{
+		{int8(100), 27, int8(127), 127},
+		{int16(-5), -10, int16(-15), -15},
+		{int64(1) << 40, 1, int64(1)<<40 + 1, 1<<40 + 1},
+		{uint8(10), -10, uint8(0), 0},
+		{uint(7), 3, uint(10), 10},
+		{[]byte("41"), 1, []byte("42"), 42},
+		{"-3", 5, "2", 2},
+	}
+	for _, tt := range tests {
+		_ = s.Set("counter", tt.initial)
+		n, err := s.IncrBy(ctx, "counter", tt.delta)
+		if err != nil || n != tt.n {
+			t.Errorf("incr_by_incorrect, initial: %#v, got: %v, want: %v, err: %v", tt.initial, n, tt.n, err)
+		}
+		if v, _ := s.Get("counter"); !reflect.DeepEqual(v, tt.want) {
+			t.Errorf("incr_by_value_incorrect, got: %#v, want: %#v", v, tt.want)
+		}
+	}
+
+	overflows := []struct {
+		initial interface{}
+		delta   int64
+	}{
+		{int8(127), 1},
+		{uint16(0), -1},
+		{uint64(math.MaxUint64), 0},
+		{int64(math.MinInt64), -1},
+		{[]byte("9223372036854775807"), 1},
+	}
+	for _, tt := range overflows {
+		_ = s.Set("counter", tt.initial)
+		if _, err := s.IncrBy(ctx, "counter", tt.delta); !errors.Is(err, store.ErrOverflow) {
+			t.Errorf("incr_by_overflow_error, initial: %#v, got: %v, want: %v", tt.initial, err, store.ErrOverflow)
+		}
+		if v, _ := s.Get("counter"); !reflect.DeepEqual(v, tt.initial) {
+			t.Errorf("overflow_should_keep_value, got: %#v, want: %#v", v, tt.initial)
+		}
+	}
+	if _, err := s.DecrBy(ctx, "counter", math.MinInt64); !errors.Is(err, store.ErrOverflow) {
+		t.Errorf("decr_by_min_int64_error, got: %v, want: %v", err, store.ErrOverflow)
+	}
+
+	_ = s.Set("string", "kash")
+	if _, err := s.IncrBy(ctx, "string", 1); !errors.Is(err, store.ErrNotInteger) {
+		t.Errorf("incr_by_string_error, got: %v, want: %v", err, store.ErrNotInteger)
+	}
+
+	// a missing key starts from 0 and gets the default timeout
+	if n, err := s.DecrBy(ctx, "new", 5); err != nil || n != -5 {
+		t.Errorf("decr_by_new_key_incorrect, got: %v, want: %v, err: %v", n, -5, err)
+	}
+	if ttl, _ := s.GetRemainingTTL(ctx, "new"); ttl != time.Minute {
+		t.Errorf("new_counter_ttl_incorrect, got: %v, want: %v", ttl, time.Minute)
+	}
+	clock.Advance(30 * time.Second)
+	_, _ = s.IncrBy(ctx, "new", 1)
+	if ttl, _ := s.GetRemainingTTL(ctx, "new"); ttl != 30*time.Second {
+		t.Errorf("incr_by_should_keep_ttl, got: %v, want: %v", ttl, 30*time.Second)
+	}
+}
+
+func testIncrByFloat(t *testing.T, newStore Factory) {
+	s := newStore()
+	defer s.Close()
+	ctx := context.Background()
+
+	tests := []struct {
+		initial interface{}
+		delta   float64
+		want    interface{}
+		f       float64
+	}{
+		{1.5, 1, 2.5, 2.5},
+		{float32(0.5), 0.25, float32(0.75), 0.75},
+		{[]byte("10"), 0.5, []byte("10.5"), 10.5},
+		{"3.5", -3.5, "0", 0},
+		{int32(2), 0.5, 2.5, 2.5},
+	}
+	for _, tt := range tests {
+		_ = s.Set("counter", tt.initial)
+		f, err := s.IncrByFloat(ctx, "counter", tt.delta)
+		if err != nil || f != tt.f {
+			t.Errorf("incr_by_float_incorrect, initial: %#v, got: %v, want: %v, err: %v", tt.initial, f, tt.f, err)
+		}
+		if v, _ := s.Get("counter"); !reflect.DeepEqual(v, tt.want) {
+			t.Errorf("incr_by_float_value_incorrect, got: %#v, want: %#v", v, tt.want)
+		}
+	}
+
+	_ = s.Set("counter", math.MaxFloat64)
+	if _, err := s.IncrByFloat(ctx, "counter", math.MaxFloat64); !errors.Is(err, store.ErrOverflow) {
+		t.Errorf("incr_by_float_overflow_error, got: %v, want: %v", err, store.ErrOverflow)
+	}
+	if _, err := s.IncrByFloat(ctx, "counter", math.NaN()); !errors.Is(err, store.ErrNotFloat) {
+		t.Errorf("incr_by_nan_error, got: %v, want: %v", err, store.ErrNotFloat)
+	}
+	_ = s.Set("string", "kash")
+	if _, err := s.IncrByFloat(ctx, "string", 1); !errors.Is(err, store.ErrNotFloat) {
+		t.Errorf("incr_by_float_string_error, got: %v, want: %v", err, store.ErrNotFloat)
+	}
+	if f, err := s.IncrByFloat(ctx, "new", 0.5); err != nil || f != 0.5 {
+		t.Errorf("incr_by_float_new_key_incorrect, got: %v, want: %v, err: %v", f, 0.5, err)
+	}
+}
+
+func testSetNX(t *testing.T, newStore Factory) {
+	clock := store.NewFakeClock(time.Now())
+	s := newStore(store.SetClock(clock))
+	defer s.Close()
+	ctx := context.Background()
+
+	if ok, err := s.SetXX(ctx, "lock", "a", 0); err != nil || ok {
+		t.Errorf("set_xx_missing_key_should_not_set, ok: %v, err: %v", ok, err)
+	}
+	if ok, err := s.SetNX(ctx, "lock", "a", time.Second); err != nil || !ok {
+		t.Errorf("set_nx_missing_key_should_set, ok: %v, err: %v", ok, err)
+	}
+	if ok, _ := s.SetNX(ctx, "lock", "b", time.Second); ok {
+		t.Errorf("set_nx_existing_key_should_not_set")
+	}
+	if v, _ := s.Get("lock"); v != "a" {
+		t.Errorf("set_nx_value_incorrect, got: %v, want: %v", v, "a")
+	}
+	if ok, err := s.SetXX(ctx, "lock", "c", time.Second); err != nil || !ok {
+		t.Errorf("set_xx_existing_key_should_set, ok: %v, err: %v", ok, err)
+	}
+
+	// an expired lock can be taken again
+	clock.Advance(time.Second + time.Nanosecond)
+	if ok, _ := s.SetNX(ctx, "lock", "d", 0); !ok {
+		t.Errorf("set_nx_expired_key_should_set")
+	}
+
+	// only one of the concurrent callers gets the lock
+	var wg sync.WaitGroup
+	var winners int32
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if ok, _ := s.SetNX(ctx, "contended", i, 0); ok {
+				atomic.AddInt32(&winners, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if winners != 1 {
+		t.Errorf("set_nx_winners_incorrect, got: %v, want: %v", winners, 1)
+	}
+}
+
+func testGetSet(t *testing.T, newStore Factory) {
+	s := newStore()
+	defer s.Close()
+	ctx := context.Background()
+
+	old, existed, err := s.GetSet(ctx, "k", "a", 0)
+	if err != nil || existed || old != nil {
+		t.Errorf("get_set_missing_key_incorrect, old: %v, existed: %v, err: %v", old, existed, err)
+	}
+	old, existed, err = s.GetSet(ctx, "k", "b", 0)
+	if err != nil || !existed || old != "a" {
+		t.Errorf("get_set_incorrect, old: %v, existed: %v, err: %v", old, existed, err)
+	}
+	if v, _ := s.Get("k"); v != "b" {
+		t.Errorf("get_set_value_incorrect, got: %v, want: %v", v, "b")
+	}
+}
+
+func testCompareAndSwap(t *testing.T, newStore Factory) {
+	s := newStore()
+	defer s.Close()
+	ctx := context.Background()
+
+	if ok, err := s.CompareAndSwap(ctx, "k", "a", "b", 0); err != nil || ok {
+		t.Errorf("cas_missing_key_should_not_swap, ok: %v, err: %v", ok, err)
+	}
+	_ = s.Set("k", []byte("a"))
+	if ok, _ := s.CompareAndSwap(ctx, "k", []byte("x"), []byte("b"), 0); ok {
+		t.Errorf("cas_other_value_should_not_swap")
+	}
+	if ok, err := s.CompareAndSwap(ctx, "k", []byte("a"), []byte("b"), 0); err != nil || !ok {
+		t.Errorf("cas_should_swap, ok: %v, err: %v", ok, err)
+	}
+	if v, _ := s.Get("k"); !reflect.DeepEqual(v, []byte("b")) {
+		t.Errorf("cas_value_incorrect, got: %v, want: %v", v, []byte("b"))
+	}
+
+	_, version, err := s.GetWithVersion(ctx, "k")
+	if err != nil {
+		t.Errorf("get_with_version_error, err: %v", err)
+	}
+	_ = s.Set("k", []byte("b"))
+	if ok, _ := s.CompareAndSwapVersion(ctx, "k", version, []byte("c"), 0); ok {
+		t.Errorf("cas_version_should_change_on_write")
+	}
+	_, version, _ = s.GetWithVersion(ctx, "k")
+	if ok, err := s.CompareAndSwapVersion(ctx, "k", version, []byte("c"), 0); err != nil || !ok {
+		t.Errorf("cas_version_should_swap, ok: %v, err: %v", ok, err)
+	}
+	if _, err := s.IncrBy(ctx, "counter", 1); err != nil {
+		t.Errorf("incr_by_error, err: %v", err)
+	}
+	_, version, _ = s.GetWithVersion(ctx, "counter")
+	_, _ = s.IncrBy(ctx, "counter", 1)
+	if _, v, _ := s.GetWithVersion(ctx, "counter"); v == version {
+		t.Errorf("incr_by_should_change_version, got: %v", v)
+	}
+	if _, _, err := s.GetWithVersion(ctx, "missing"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("get_with_version_missing_key_error, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+	_, version, _ = s.GetWithVersion(ctx, "counter")
+	if v, err := s.Version(ctx, "counter"); err != nil || v != version {
+		t.Errorf("version_incorrect, got: %v, want: %v, err: %v", v, version, err)
+	}
+	if v, err := s.Version(ctx, "missing"); err != nil || v != 0 {
+		t.Errorf("version_missing_key_incorrect, got: %v, want: %v, err: %v", v, 0, err)
+	}
+}
+
+func testBatch(t *testing.T, newStore Factory) {
+	clock := store.NewFakeClock(time.Now())
+	s := newStore(store.SetClock(clock))
+	defer s.Close()
+	ctx := context.Background()
+
+	values := make(map[string]interface{})
+	keys := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		values[key] = i
+		keys = append(keys, key)
+	}
+	if err := s.MSet(ctx, values, 0); err != nil {
+		t.Errorf("mset_error, err: %v", err)
+	}
+	_ = s.SetWithTimeout("expiring", 1, time.Second)
+	clock.Advance(2 * time.Second)
+
+	got, err := s.MGet(ctx, append(keys, "missing", "expiring"))
+	if err != nil {
+		t.Errorf("mget_error, err: %v", err)
+	}
+	if !reflect.DeepEqual(got, values) {
+		t.Errorf("mget_incorrect, got: %v, want: %v", got, values)
+	}
+
+	if err := s.MSetAtomic(ctx, map[string]interface{}{"key-0": "a", "key-1": "b"}, time.Second); err != nil {
+		t.Errorf("mset_atomic_error, err: %v", err)
+	}
+	if v, _ := s.Get("key-1"); v != "b" {
+		t.Errorf("mset_atomic_value_incorrect, got: %v, want: %v", v, "b")
+	}
+	if ttl, _ := s.GetRemainingTTL(ctx, "key-0"); ttl != time.Second {
+		t.Errorf("mset_ttl_incorrect, got: %v, want: %v", ttl, time.Second)
+	}
+
+	_ = s.SetWithTimeout("expiring", 1, time.Second)
+	clock.Advance(2 * time.Second)
+	n, err := s.MDelete(ctx, append(keys[2:], "missing", "expiring", "key-2"))
+	if err != nil || n != 98 {
+		t.Errorf("mdelete_count_incorrect, got: %v, want: %v, err: %v", n, 98, err)
+	}
+	if got, _ := s.MGet(ctx, keys); len(got) != 0 {
+		t.Errorf("mdelete_should_delete_all, left: %v", got)
+	}
+
+	ctxCanceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := s.MGet(ctxCanceled, keys); !errors.Is(err, context.Canceled) {
+		t.Errorf("mget_canceled_error, got: %v, want: %v", err, context.Canceled)
+	}
+	if err := s.MSetAtomic(ctxCanceled, values, 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("mset_atomic_canceled_error, got: %v, want: %v", err, context.Canceled)
+	}
+}
+
+func testMSetAtomic(t *testing.T, newStore Factory) {
+	s := newStore()
+	defer s.Close()
+	ctx := context.Background()
+
+	keys := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				values := make(map[string]interface{}, len(keys))
+				for _, key := range keys {
+					values[key] = w
+				}
+				if err := s.MSetAtomic(ctx, values, 0); err != nil {
+					t.Errorf("mset_atomic_error, err: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// the last writer wrote every key
+	got, _ := s.MGet(ctx, keys)
+	for _, key := range keys {
+		if got[key] != got[keys[0]] {
+			t.Errorf("mset_atomic_interleaved, got: %v", got)
+			break
+		}
+	}
+}
+
+func testTxn(t *testing.T, newStore Factory) {
+	clock := store.NewFakeClock(time.Now())
+	s := newStore(store.SetClock(clock))
+	defer s.Close()
+	ctx := context.Background()
+
+	_ = s.Set("old", "x")
+	_ = s.SetWithTimeout("counter", 10, time.Minute)
+	_, counterVersion, _ := s.GetWithVersion(ctx, "counter")
+	err := s.Txn(ctx, []string{"old", "counter", "index"}, func(tx store.Tx) error {
+		if v, err := tx.Version("counter"); err != nil || v != counterVersion {
+			t.Errorf("txn_version_incorrect, got: %v, want: %v, err: %v", v, counterVersion, err)
+		}
+		n, err := tx.IncrBy("counter", 1)
+		if err != nil || n != 11 {
+			t.Errorf("txn_incr_by_incorrect, got: %v, want: %v, err: %v", n, 11, err)
+		}
+		if v, _ := tx.Get("counter"); v != 11 {
+			t.Errorf("txn_should_read_its_writes, got: %v, want: %v", v, 11)
+		}
+		_ = tx.Set("index", "counter=11", 0)
+		_ = tx.Delete("old")
+		if _, err := tx.Get("old"); !errors.Is(err, store.ErrKeyNotFound) {
+			t.Errorf("txn_deleted_key_error, got: %v, want: %v", err, store.ErrKeyNotFound)
+		}
+		if err := tx.Set("other", 1, 0); !errors.Is(err, store.ErrNotInTxn) {
+			t.Errorf("txn_undeclared_key_error, got: %v, want: %v", err, store.ErrNotInTxn)
+		}
+		// nothing is visible before the commit
+		return nil
+	})
+	if err != nil {
+		t.Errorf("txn_error, err: %v", err)
+	}
+	if v, _ := s.Get("counter"); v != 11 {
+		t.Errorf("txn_counter_incorrect, got: %v, want: %v", v, 11)
+	}
+	if ttl, _ := s.GetRemainingTTL(ctx, "counter"); ttl != time.Minute {
+		t.Errorf("txn_incr_by_should_keep_ttl, got: %v, want: %v", ttl, time.Minute)
+	}
+	if v, _ := s.Get("index"); v != "counter=11" {
+		t.Errorf("txn_index_incorrect, got: %v, want: %v", v, "counter=11")
+	}
+	if _, err := s.Get("old"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("txn_should_delete, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+
+	// an error discards every write
+	abort := errors.New("abort")
+	err = s.Txn(ctx, []string{"counter", "index"}, func(tx store.Tx) error {
+		_, _ = tx.IncrBy("counter", 1)
+		_ = tx.Delete("index")
+		return abort
+	})
+	if err != abort {
+		t.Errorf("txn_should_return_fn_error, got: %v, want: %v", err, abort)
+	}
+	if v, _ := s.Get("counter"); v != 11 {
+		t.Errorf("aborted_txn_should_not_write, got: %v, want: %v", v, 11)
+	}
+	if _, err := s.Get("index"); err != nil {
+		t.Errorf("aborted_txn_should_not_delete, err: %v", err)
+	}
+}
+
+func testTxnConcurrent(t *testing.T, newStore Factory) {
+	s := newStore()
+	defer s.Close()
+	ctx := context.Background()
+
+	keys := []string{"x", "y", "z"}
+	for _, key := range keys {
+		_ = s.Set(key, 1000)
+	}
+	// move units around, locking the keys in every order
+	var wg sync.WaitGroup
+	for g := 0; g < 6; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			from, to := keys[g%3], keys[(g+1+g/3)%3]
+			for i := 0; i < 200; i++ {
+				err := s.Txn(ctx, []string{to, from}, func(tx store.Tx) error {
+					if _, err := tx.IncrBy(from, -1); err != nil {
+						return err
+					}
+					_, err := tx.IncrBy(to, 1)
+					return err
+				})
+				if err != nil {
+					t.Errorf("txn_error, err: %v", err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, key := range keys {
+		v, _ := s.Get(key)
+		total += v.(int)
+	}
+	if total != 3000 {
+		t.Errorf("txn_lost_update, got: %v, want: %v", total, 3000)
+	}
+}
+
+func testList(t *testing.T, newStore Factory) {
+	s := newStore()
+	defer s.Close()
+	ctx := context.Background()
+
+	if n, err := s.RPush(ctx, "list", "b", "c"); n != 2 || err != nil {
+		t.Errorf("rpush_incorrect, got: %v, want: %v, err: %v", n, 2, err)
+	}
+	if n, err := s.LPush(ctx, "list", "a", "z"); n != 4 || err != nil {
+		t.Errorf("lpush_incorrect, got: %v, want: %v, err: %v", n, 4, err)
+	}
+	// pushed to the head one after the other
+	want := []interface{}{"z", "a", "b", "c"}
+	if values, err := s.LRange(ctx, "list", 0, -1); !reflect.DeepEqual(values, want) || err != nil {
+		t.Errorf("lrange_incorrect, got: %v, want: %v, err: %v", values, want, err)
+	}
+	ranges := []struct {
+		start, stop int
+		want        []interface{}
+	}{
+		{1, 2, []interface{}{"a", "b"}},
+		{-2, -1, []interface{}{"b", "c"}},
+		{-100, 1, []interface{}{"z", "a"}},
+		{2, 100, []interface{}{"b", "c"}},
+		{3, 1, []interface{}{}},
+		{5, 10, []interface{}{}},
+	}
+	for _, r := range ranges {
+		if values, _ := s.LRange(ctx, "list", r.start, r.stop); !reflect.DeepEqual(values, r.want) {
+			t.Errorf("lrange_incorrect, start: %v, stop: %v, got: %v, want: %v", r.start, r.stop, values, r.want)
+		}
+	}
+	if v, err := s.LIndex(ctx, "list", -1); v != "c" || err != nil {
+		t.Errorf("lindex_incorrect, got: %v, want: %v, err: %v", v, "c", err)
+	}
+	if _, err := s.LIndex(ctx, "list", 4); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("lindex_out_of_range_error, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+
+	if v, err := s.LPop(ctx, "list"); v != "z" || err != nil {
+		t.Errorf("lpop_incorrect, got: %v, want: %v, err: %v", v, "z", err)
+	}
+	if v, err := s.RPop(ctx, "list"); v != "c" || err != nil {
+		t.Errorf("rpop_incorrect, got: %v, want: %v, err: %v", v, "c", err)
+	}
+	if n, _ := s.LLen(ctx, "list"); n != 2 {
+		t.Errorf("llen_incorrect, got: %v, want: %v", n, 2)
+	}
+
+	_, _ = s.RPush(ctx, "list", "c", "d", "e")
+	if err := s.LTrim(ctx, "list", 1, -2); err != nil {
+		t.Errorf("ltrim_error, err: %v", err)
+	}
+	want = []interface{}{"b", "c", "d"}
+	if values, _ := s.LRange(ctx, "list", 0, -1); !reflect.DeepEqual(values, want) {
+		t.Errorf("ltrim_incorrect, got: %v, want: %v", values, want)
+	}
+
+	// the key is deleted with its last element
+	_ = s.LTrim(ctx, "list", 5, 10)
+	if _, err := s.LPop(ctx, "list"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("empty_list_should_be_deleted, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+	if _, err := s.Get("list"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("empty_list_should_be_deleted, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+	if n, err := s.LLen(ctx, "missing"); n != 0 || err != nil {
+		t.Errorf("missing_list_should_be_empty, got: %v, err: %v", n, err)
+	}
+	if err := s.LTrim(ctx, "missing", 0, 1); err != nil {
+		t.Errorf("ltrim_missing_error, err: %v", err)
+	}
+
+	// as many elements as needed to wrap around
+	for i := 0; i < 100; i++ {
+		_, _ = s.RPush(ctx, "queue", i)
+		if i%3 == 0 {
+			_, _ = s.LPop(ctx, "queue")
+		}
+	}
+	if v, _ := s.LIndex(ctx, "queue", 0); v != 34 {
+		t.Errorf("queue_head_incorrect, got: %v, want: %v", v, 34)
+	}
+	if n, _ := s.LLen(ctx, "queue"); n != 66 {
+		t.Errorf("queue_len_incorrect, got: %v, want: %v", n, 66)
+	}
+
+	_ = s.Set("string", "value")
+	_, _ = s.RPush(ctx, "dumped", "a", "b")
+	var dump map[string]interface{}
+	jsonStr, _ := s.DumpAllJSON()
+	if err := json.Unmarshal([]byte(jsonStr), &dump); err != nil {
+		t.Errorf("dump_all_json_error, err: %v", err)
+	}
+	if want := []interface{}{"a", "b"}; !reflect.DeepEqual(dump["dumped"], want) || dump["string"] != "value" {
+		t.Errorf("dump_should_hold_lists_as_arrays, got: %v", jsonStr)
+	}
+}
+
+func testListWrongType(t *testing.T, newStore Factory) {
+	s := newStore()
+	defer s.Close()
+	ctx := context.Background()
+
+	_ = s.Set("string", "value")
+	_, _ = s.RPush(ctx, "list", "a")
+	errs := map[string]error{}
+	_, errs["lpush"] = s.LPush(ctx, "string", "a")
+	_, errs["rpop"] = s.RPop(ctx, "string")
+	_, errs["lrange"] = s.LRange(ctx, "string", 0, -1)
+	_, errs["llen"] = s.LLen(ctx, "string")
+	_, errs["lindex"] = s.LIndex(ctx, "string", 0)
+	errs["ltrim"] = s.LTrim(ctx, "string", 0, 1)
+	_, errs["get"] = s.Get("list")
+	_, errs["incr by"] = s.IncrBy(ctx, "list", 1)
+	_, _, errs["get set"] = s.GetSet(ctx, "list", "b", 0)
+	for op, err := range errs {
+		if !errors.Is(err, store.ErrWrongType) {
+			t.Errorf("wrong_type_error, op: %v, got: %v, want: %v", op, err, store.ErrWrongType)
+		}
+	}
+	if v, _ := s.Get("string"); v != "value" {
+		t.Errorf("wrong_type_should_not_write, got: %v, want: %v", v, "value")
+	}
+	if n, _ := s.LLen(ctx, "list"); n != 1 {
+		t.Errorf("wrong_type_should_not_write, got: %v, want: %v", n, 1)
+	}
+
+	// a transaction can read the version of a list and delete it, but not decode it
+	err := s.Txn(ctx, []string{"list"}, func(tx store.Tx) error {
+		if v, err := tx.Version("list"); err != nil || v == 0 {
+			t.Errorf("txn_list_version_incorrect, got: %v, err: %v", v, err)
+		}
+		if _, err := tx.Get("list"); !errors.Is(err, store.ErrWrongType) {
+			t.Errorf("wrong_type_error, op: txn get, got: %v, want: %v", err, store.ErrWrongType)
+		}
+		return tx.Delete("list")
+	})
+	if err != nil {
+		t.Errorf("txn_error, err: %v", err)
+	}
+	if n, _ := s.LLen(ctx, "list"); n != 0 {
+		t.Errorf("txn_should_delete_list, got: %v, want: %v", n, 0)
+	}
+
+	// a SET replaces a list
+	_, _ = s.RPush(ctx, "list", "a")
+	_ = s.Set("list", "value")
+	if v, err := s.Get("list"); v != "value" || err != nil {
+		t.Errorf("set_should_replace_list, got: %v, want: %v, err: %v", v, "value", err)
+	}
+}
+
+func testListTTL(t *testing.T, newStore Factory) {
+	clock := store.NewFakeClock(time.Now())
+	s := newStore(store.SetClock(clock), store.SetDefaultTimeout(time.Minute))
+	defer s.Close()
+	ctx := context.Background()
+
+	_, _ = s.RPush(ctx, "list", "a", "b")
+	if ttl, _ := s.GetRemainingTTL(ctx, "list"); ttl != time.Minute {
+		t.Errorf("new_list_should_get_default_timeout, got: %v, want: %v", ttl, time.Minute)
+	}
+	_ = s.Expire(ctx, "list", time.Second)
+	clock.Advance(500 * time.Millisecond)
+	// the pushes and pops keep the timeout of the whole list
+	_, _ = s.RPush(ctx, "list", "c")
+	_, _ = s.LPop(ctx, "list")
+	if ttl, _ := s.GetRemainingTTL(ctx, "list"); ttl != 500*time.Millisecond {
+		t.Errorf("list_should_keep_timeout, got: %v, want: %v", ttl, 500*time.Millisecond)
+	}
+	clock.Advance(time.Second)
+	if n, _ := s.LLen(ctx, "list"); n != 0 {
+		t.Errorf("list_should_expire, got: %v, want: %v", n, 0)
+	}
+	if n, _ := s.RPush(ctx, "list", "d"); n != 1 {
+		t.Errorf("expired_list_should_restart_empty, got: %v, want: %v", n, 1)
+	}
+}
+
+func testHash(t *testing.T, newStore Factory) {
+	s := newStore()
+	defer s.Close()
+	ctx := context.Background()
+
+	if n, err := s.HSet(ctx, "user", map[string]interface{}{"name": "ann", "visits": 1}); n != 2 || err != nil {
+		t.Errorf("hset_incorrect, got: %v, want: %v, err: %v", n, 2, err)
+	}
+	if n, _ := s.HSet(ctx, "user", map[string]interface{}{"name": "bob", "city": "oslo"}); n != 1 {
+		t.Errorf("hset_should_count_new_fields, got: %v, want: %v", n, 1)
+	}
+	if v, err := s.HGet(ctx, "user", "name"); v != "bob" || err != nil {
+		t.Errorf("hget_incorrect, got: %v, want: %v, err: %v", v, "bob", err)
+	}
+	if _, err := s.HGet(ctx, "user", "missing"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("hget_missing_field_error, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+	if n, err := s.HIncrBy(ctx, "user", "visits", 41); n != 42 || err != nil {
+		t.Errorf("hincr_by_incorrect, got: %v, want: %v, err: %v", n, 42, err)
+	}
+	if n, _ := s.HIncrBy(ctx, "user", "logins", -1); n != -1 {
+		t.Errorf("hincr_by_missing_field_incorrect, got: %v, want: %v", n, -1)
+	}
+	if _, err := s.HIncrBy(ctx, "user", "name", 1); !errors.Is(err, store.ErrNotInteger) {
+		t.Errorf("hincr_by_not_integer_error, got: %v, want: %v", err, store.ErrNotInteger)
+	}
+	want := map[string]interface{}{"name": "bob", "visits": 42, "city": "oslo", "logins": -1}
+	if values, err := s.HGetAll(ctx, "user"); !reflect.DeepEqual(values, want) || err != nil {
+		t.Errorf("hgetall_incorrect, got: %v, want: %v, err: %v", values, want, err)
+	}
+	if ok, _ := s.HExists(ctx, "user", "city"); !ok {
+		t.Errorf("hexists_incorrect, got: %v, want: %v", ok, true)
+	}
+	if n, _ := s.HLen(ctx, "user"); n != 4 {
+		t.Errorf("hlen_incorrect, got: %v, want: %v", n, 4)
+	}
+
+	if n, err := s.HDel(ctx, "user", "city", "logins", "missing"); n != 2 || err != nil {
+		t.Errorf("hdel_incorrect, got: %v, want: %v, err: %v", n, 2, err)
+	}
+	if ok, _ := s.HExists(ctx, "user", "city"); ok {
+		t.Errorf("hdel_should_delete, got: %v, want: %v", ok, false)
+	}
+	// the key is deleted with its last field
+	_, _ = s.HDel(ctx, "user", "name", "visits")
+	if _, err := s.Get("user"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("empty_hash_should_be_deleted, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+	if values, err := s.HGetAll(ctx, "missing"); len(values) != 0 || err != nil {
+		t.Errorf("missing_hash_should_be_empty, got: %v, err: %v", values, err)
+	}
+	if n, err := s.HDel(ctx, "missing", "a"); n != 0 || err != nil {
+		t.Errorf("hdel_missing_incorrect, got: %v, err: %v", n, err)
+	}
+
+	_ = s.Set("string", "value")
+	_, _ = s.RPush(ctx, "list", "a")
+	_, _ = s.HSet(ctx, "hash", map[string]interface{}{"a": "b"})
+	errs := map[string]error{}
+	_, errs["hset string"] = s.HSet(ctx, "string", map[string]interface{}{"a": "b"})
+	_, errs["hget list"] = s.HGet(ctx, "list", "a")
+	_, errs["hlen list"] = s.HLen(ctx, "list")
+	_, errs["lpush hash"] = s.LPush(ctx, "hash", "a")
+	_, errs["get hash"] = s.Get("hash")
+	for op, err := range errs {
+		if !errors.Is(err, store.ErrWrongType) {
+			t.Errorf("wrong_type_error, op: %v, got: %v, want: %v", op, err, store.ErrWrongType)
+		}
+	}
+
+	var dump map[string]interface{}
+	jsonStr, _ := s.DumpAllJSON()
+	if err := json.Unmarshal([]byte(jsonStr), &dump); err != nil {
+		t.Errorf("dump_all_json_error, err: %v", err)
+	}
+	if want := map[string]interface{}{"a": "b"}; !reflect.DeepEqual(dump["hash"], want) {
+		t.Errorf("dump_should_hold_hashes_as_objects, got: %v", jsonStr)
+	}
+}
+
+func testHashFieldTTL(t *testing.T, newStore Factory) {
+	clock := store.NewFakeClock(time.Now())
+	s := newStore(store.SetClock(clock))
+	defer s.Close()
+	ctx := context.Background()
+
+	_, _ = s.HSet(ctx, "session", map[string]interface{}{"user": "ann"})
+	_, _ = s.HSetWithTimeout(ctx, "session", map[string]interface{}{"token": "t1", "nonce": 7}, time.Second)
+	clock.Advance(500 * time.Millisecond)
+	// an increment keeps the timeout of the field, a HSET drops it
+	_, _ = s.HIncrBy(ctx, "session", "nonce", 1)
+	_, _ = s.HSet(ctx, "session", map[string]interface{}{"token": "t2"})
+	if n, _ := s.HLen(ctx, "session"); n != 3 {
+		t.Errorf("hlen_incorrect, got: %v, want: %v", n, 3)
+	}
+
+	clock.Advance(time.Second)
+	if _, err := s.HGet(ctx, "session", "nonce"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("field_should_expire, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+	want := map[string]interface{}{"user": "ann", "token": "t2"}
+	if values, _ := s.HGetAll(ctx, "session"); !reflect.DeepEqual(values, want) {
+		t.Errorf("hgetall_should_skip_expired_fields, got: %v, want: %v", values, want)
+	}
+	if n, _ := s.HLen(ctx, "session"); n != 2 {
+		t.Errorf("hlen_should_skip_expired_fields, got: %v, want: %v", n, 2)
+	}
+	if ttl, _ := s.GetRemainingTTL(ctx, "session"); ttl != store.NoExpiry {
+		t.Errorf("field_timeout_should_not_apply_to_key, got: %v, want: %v", ttl, store.NoExpiry)
+	}
+	// an expired field is a new one
+	if n, _ := s.HSet(ctx, "session", map[string]interface{}{"nonce": 1}); n != 1 {
+		t.Errorf("hset_expired_field_should_count, got: %v, want: %v", n, 1)
+	}
+
+	// the key is deleted once all the fields expired
+	_, _ = s.HSetWithTimeout(ctx, "short", map[string]interface{}{"a": 1}, time.Second)
+	clock.Advance(2 * time.Second)
+	_, _ = s.HSetWithTimeout(ctx, "short", map[string]interface{}{"b": 2}, -time.Second)
+	if _, err := s.Get("short"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("hash_without_live_field_should_be_deleted, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+	// and by a read finding them all expired
+	_, _ = s.HSetWithTimeout(ctx, "read", map[string]interface{}{"a": 1}, time.Second)
+	clock.Advance(2 * time.Second)
+	if n, _ := s.HLen(ctx, "read"); n != 0 {
+		t.Errorf("hlen_incorrect, got: %v, want: %v", n, 0)
+	}
+	if _, err := s.Get("read"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("read_hash_without_live_field_should_be_deleted, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+}
+
+func testHashConcurrent(t *testing.T, newStore Factory) {
+	s := newStore()
+	defer s.Close()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				_, _ = s.HIncrBy(ctx, "counters", "total", 1)
+				_, _ = s.HIncrBy(ctx, "counters", fmt.Sprint("worker-", g), 1)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	values, _ := s.HGetAll(ctx, "counters")
+	if values["total"] != 800 || len(values) != 9 {
+		t.Errorf("hincr_by_lost_update, got: %v", values)
+	}
+}
+
+func testTTL(t *testing.T, newStore Factory) {
+	clock := store.NewFakeClock(time.Now())
+	s := newStore(store.SetClock(clock))
+	defer s.Close()
+
+	_ = s.SetWithTimeout("k", "v", time.Second)
+	deadline, err := s.GetTTL("k")
+	if want := clock.Now().Add(time.Second).UnixNano(); err != nil || deadline != want {
+		t.Errorf("ttl_incorrect, got: %v, want: %v, err: %v", deadline, want, err)
+	}
+	if _, err := s.GetTTL("missing"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("ttl_missing_key_error, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+
+	// alive at its deadline, gone right after
+	clock.Advance(time.Second)
+	if _, err := s.Get("k"); err != nil {
+		t.Errorf("key_should_live_until_deadline, err: %v", err)
+	}
+	clock.Advance(time.Nanosecond)
+	if _, err := s.Get("k"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("key_should_expire, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+	if _, err := s.GetTTL("k"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("ttl_expired_key_error, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+}
+
+func testZeroTimeout(t *testing.T, newStore Factory) {
+	clock := store.NewFakeClock(time.Now())
+	s := newStore(store.SetClock(clock))
+	defer s.Close()
+
+	_ = s.SetWithTimeout("k", "v", 0)
+	clock.Advance(100 * 365 * 24 * time.Hour)
+	if v, err := s.Get("k"); err != nil || v != "v" {
+		t.Errorf("zero_timeout_should_never_expire, v: %v, err: %v", v, err)
+	}
+}
+
+func testNegativeTimeout(t *testing.T, newStore Factory) {
+	s := newStore()
+	defer s.Close()
+
+	_ = s.Set("k", "v")
+	_ = s.SetWithTimeout("k", "v", -time.Second)
+	if _, err := s.Get("k"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("negative_timeout_should_expire_right_away, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+}
+
+func testDefaultTimeout(t *testing.T, newStore Factory) {
+	clock := store.NewFakeClock(time.Now())
+	s := newStore(store.SetClock(clock), store.SetDefaultTimeout(time.Minute))
+	defer s.Close()
+
+	_ = s.Set("k", "v")
+	clock.Advance(time.Minute)
+	if _, err := s.Get("k"); err != nil {
+		t.Errorf("key_should_live_until_default_timeout, err: %v", err)
+	}
+	clock.Advance(time.Nanosecond)
+	if _, err := s.Get("k"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("key_should_expire_after_default_timeout, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+}
+
+func testExpire(t *testing.T, newStore Factory) {
+	clock := store.NewFakeClock(time.Now())
+	s := newStore(store.SetClock(clock))
+	defer s.Close()
+	ctx := context.Background()
+
+	_ = s.Set("k", "v")
+	if err := s.Expire(ctx, "k", time.Minute); err != nil {
+		t.Errorf("expire_error, err: %v", err)
+	}
+	if ttl, err := s.GetRemainingTTL(ctx, "k"); err != nil || ttl != time.Minute {
+		t.Errorf("remaining_ttl_incorrect, got: %v, want: %v, err: %v", ttl, time.Minute, err)
+	}
+	clock.Advance(time.Minute + time.Nanosecond)
+	if _, err := s.Get("k"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("key_should_expire, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+
+	_ = s.Set("k", "v")
+	if err := s.ExpireAt(ctx, "k", clock.Now().Add(time.Hour)); err != nil {
+		t.Errorf("expire_at_error, err: %v", err)
+	}
+	if deadline, _ := s.GetTTL("k"); deadline != clock.Now().Add(time.Hour).UnixNano() {
+		t.Errorf("expire_at_deadline_incorrect, got: %v, want: %v", deadline, clock.Now().Add(time.Hour).UnixNano())
+	}
+	if err := s.ExpireAt(ctx, "k", clock.Now().Add(-time.Second)); err != nil {
+		t.Errorf("expire_at_past_error, err: %v", err)
+	}
+	if _, err := s.Get("k"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("expire_at_past_should_delete, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+
+	_ = s.Set("k", "v")
+	_ = s.Expire(ctx, "k", 0)
+	if _, err := s.Get("k"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("expire_zero_should_delete, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+
+	if err := s.Expire(ctx, "missing", time.Minute); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("expire_missing_key_error, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+	if _, err := s.GetRemainingTTL(ctx, "missing"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("remaining_ttl_missing_key_error, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+}
+
+func testPersist(t *testing.T, newStore Factory) {
+	clock := store.NewFakeClock(time.Now())
+	s := newStore(store.SetClock(clock))
+	defer s.Close()
+	ctx := context.Background()
+
+	_ = s.SetWithTimeout("k", "v", time.Second)
+	if persisted, err := s.Persist(ctx, "k"); err != nil || !persisted {
+		t.Errorf("persist_error, persisted: %v, err: %v", persisted, err)
+	}
+	if persisted, err := s.Persist(ctx, "k"); err != nil || persisted {
+		t.Errorf("persist_without_timeout_should_report_false, persisted: %v, err: %v", persisted, err)
+	}
+	if ttl, err := s.GetRemainingTTL(ctx, "k"); err != nil || ttl != store.NoExpiry {
+		t.Errorf("persisted_ttl_incorrect, got: %v, want: %v, err: %v", ttl, store.NoExpiry, err)
+	}
+	clock.Advance(time.Hour)
+	if v, err := s.Get("k"); err != nil || v != "v" {
+		t.Errorf("persisted_key_should_not_expire, v: %v, err: %v", v, err)
+	}
+	if _, err := s.Persist(ctx, "missing"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("persist_missing_key_error, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+}
+
+func testSlidingExpiration(t *testing.T, newStore Factory) {
+	clock := store.NewFakeClock(time.Now())
+	s := newStore(store.SetClock(clock))
+	defer s.Close()
+	ctx := context.Background()
+
+	// Touch restarts the timeout of any key, the reads only the one of a sliding key
+	_ = s.SetWithTimeout("fixed", "v", time.Minute)
+	_ = s.SetWithSlidingTimeout("sliding", "v", time.Minute)
+	for i := 0; i < 3; i++ {
+		clock.Advance(40 * time.Second)
+		if err := s.Touch(ctx, "fixed"); err != nil {
+			t.Errorf("touch_error, err: %v", err)
+		}
+		if _, err := s.Get("sliding"); err != nil {
+			t.Errorf("sliding_key_should_live_while_read, err: %v", err)
+		}
+	}
+	if ttl, _ := s.GetRemainingTTL(ctx, "sliding"); ttl != time.Minute {
+		t.Errorf("sliding_ttl_incorrect, got: %v, want: %v", ttl, time.Minute)
+	}
+	clock.Advance(time.Minute + time.Nanosecond)
+	for _, key := range []string{"fixed", "sliding"} {
+		if _, err := s.Get(key); !errors.Is(err, store.ErrKeyNotFound) {
+			t.Errorf("idle_key_should_expire, key: %v, got: %v, want: %v", key, err, store.ErrKeyNotFound)
+		}
+	}
+	if err := s.Touch(ctx, "fixed"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("touch_missing_key_error, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+
+	// reading the version isn't a read of the value
+	_ = s.SetWithSlidingTimeout("sliding", "v", time.Minute)
+	clock.Advance(40 * time.Second)
+	_, _ = s.Version(ctx, "sliding")
+	clock.Advance(40 * time.Second)
+	if _, err := s.Get("sliding"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("version_should_not_restart_sliding_timeout, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+
+	s2 := newStore(store.SetClock(clock), store.SetSlidingExpiration(true), store.SetDefaultTimeout(time.Minute))
+	defer s2.Close()
+	_ = s2.Set("k", "v")
+	clock.Advance(40 * time.Second)
+	_, _ = s2.Get("k")
+	clock.Advance(40 * time.Second)
+	if _, err := s2.Get("k"); err != nil {
+		t.Errorf("store_wide_sliding_key_should_live_while_read, err: %v", err)
+	}
+}
+
+// count return how many of the keys are in s
+func count(s store.Store, keys []string) int {
+	n := 0
+	for _, key := range keys {
+		if _, err := s.Get(key); err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func testCapacity(t *testing.T, newStore Factory) {
+	const capacity = 320
+	s := newStore(store.SetCapacity(capacity))
+	defer s.Close()
+
+	keys := make([]string, 10*capacity)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		if err := s.Set(keys[i], i); err != nil {
+			t.Errorf("set_error, err: %v", err)
+		}
+	}
+	if n := count(s, keys); n > capacity || n == 0 {
+		t.Errorf("capacity_exceeded, got: %v, want: <= %v", n, capacity)
+	}
+	last := keys[len(keys)-1]
+	if _, err := s.Get(last); err != nil {
+		t.Errorf("last_key_should_be_kept, err: %v", err)
+	}
+}
+
+func testMaxMemory(t *testing.T, newStore Factory) {
+	const maxMemory = 64 << 10
+	s := newStore(store.SetMaxMemory("64KiB"))
+	defer s.Close()
+
+	value := make([]byte, 100)
+	keys := make([]string, 4*maxMemory/len(value))
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		if err := s.Set(keys[i], value); err != nil {
+			t.Errorf("set_error, err: %v", err)
+		}
+	}
+	if usage := s.GetMemoryUsage(); usage > maxMemory || usage <= 0 {
+		t.Errorf("max_memory_exceeded, got: %v, want: <= %v", usage, maxMemory)
+	}
+	if n := count(s, keys); n >= len(keys) || n == 0 {
+		t.Errorf("keys_should_be_evicted, got: %v of %v", n, len(keys))
+	}
+
+	if err := s.Set("huge", make([]byte, 2*maxMemory)); !errors.Is(err, store.ErrExceedMaxMemory) {
+		t.Errorf("huge_value_error, got: %v, want: %v", err, store.ErrExceedMaxMemory)
+	}
+	if _, err := s.Get("huge"); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("rejected_value_should_not_be_stored, got: %v, want: %v", err, store.ErrKeyNotFound)
+	}
+}
+
+func testDumpAllJSON(t *testing.T, newStore Factory) {
+	clock := store.NewFakeClock(time.Now())
+	s := newStore(store.SetClock(clock))
+	defer s.Close()
+
+	if dump, err := s.DumpAllJSON(); err != nil || dump != "{}" {
+		t.Errorf("empty_dump_incorrect, got: %v, err: %v", dump, err)
+	}
+
+	_ = s.Set("name", "kash")
+	_ = s.Set("count", 3)
+	_ = s.Set("point", point{1, 2})
+	_ = s.SetWithTimeout("expired", "v", time.Second)
+	clock.Advance(2 * time.Second)
+
+	dump, err := s.DumpAllJSON()
+	if err != nil {
+		t.Fatalf("dump_error, err: %v", err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(dump), &got); err != nil {
+		t.Fatalf("dump_should_be_json, got: %v, err: %v", dump, err)
+	}
+	want := map[string]interface{}{
+		"name":  "kash",
+		"count": 3.0,
+		"point": map[string]interface{}{"X": 1.0, "Y": 2.0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("dump_incorrect, got: %v, want: %v", got, want)
+	}
+}
+
+func testContext(t *testing.T, newStore Factory) {
+	s := newStore()
+	defer s.Close()
+	_ = s.Set("k", 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	errs := map[string]error{
+		"SetCtx":            s.SetCtx(ctx, "k", 2),
+		"SetWithTimeoutCtx": s.SetWithTimeoutCtx(ctx, "k", 2, time.Minute),
+		"DeleteCtx":         s.DeleteCtx(ctx, "k"),
+		"IncreaseCtx":       s.IncreaseCtx(ctx, "k"),
+	}
+	_, errs["GetCtx"] = s.GetCtx(ctx, "k")
+	_, errs["GetTTLCtx"] = s.GetTTLCtx(ctx, "k")
+	for op, err := range errs {
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("canceled_context_error, op: %v, got: %v, want: %v", op, err, context.Canceled)
+		}
+	}
+	// nothing was changed
+	if v, _ := s.Get("k"); v != 1 {
+		t.Errorf("canceled_ops_should_not_change_the_value, got: %v, want: %v", v, 1)
+	}
+
+	// a live context works like the plain methods
+	if err := s.SetCtx(context.Background(), "k", 3); err != nil {
+		t.Errorf("set_ctx_error, err: %v", err)
+	}
+	if v, err := s.GetCtx(context.Background(), "k"); err != nil || v != 3 {
+		t.Errorf("get_ctx_incorrect, v: %v, err: %v", v, err)
+	}
+}
+
+func testGetOrLoad(t *testing.T, newStore Factory) {
+	s := newStore()
+	defer s.Close()
+
+	var calls int32
+	release := make(chan struct{})
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "loaded-" + key, nil
+	}
+
+	const callers = 20
+	var wg sync.WaitGroup
+	wg.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			defer wg.Done()
+			if v, err := s.GetOrLoad(context.Background(), "k", loader); err != nil || v != "loaded-k" {
+				t.Errorf("get_or_load_incorrect, v: %v, err: %v", v, err)
+			}
+		}()
+	}
+	for s.GetStats().Coalesced < callers-1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("loader_should_be_called_once, got: %v", calls)
+	}
+	if v, err := s.Get("k"); err != nil || v != "loaded-k" {
+		t.Errorf("loaded_value_should_be_stored, v: %v, err: %v", v, err)
+	}
+
+	errLoad := errors.New("db down")
+	failing := func(ctx context.Context, key string) (interface{}, error) {
+		return nil, errLoad
+	}
+	if _, err := s.GetOrLoad(context.Background(), "failing", failing); !errors.Is(err, store.ErrLoaderFailed) ||
+		!errors.Is(err, errLoad) {
+		t.Errorf("loader_error_incorrect, got: %v", err)
+	}
+
+	// without a loader nor a backend, a miss is a miss
+	if _, err := s.GetOrLoad(context.Background(), "missing", nil); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("nil_loader_should_return_not_found, got: %v", err)
+	}
+}
+
+func testStaleWhileRevalidate(t *testing.T, newStore Factory) {
+	clock := store.NewFakeClock(time.Now())
+	var version int32
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		return int(atomic.AddInt32(&version, 1)), nil
+	}
+	s := newStore(store.SetClock(clock), store.SetRefreshLoader(loader))
+	defer s.Close()
+
+	_ = s.SetWithSoftTimeout("k", 0, time.Second, time.Hour)
+	clock.Advance(2 * time.Second)
+	v, stale, err := s.GetWithStale(context.Background(), "k")
+	if err != nil || !stale || v != 0 {
+		t.Errorf("stale_value_should_be_served, v: %v, stale: %v, err: %v", v, stale, err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if v, stale, err := s.GetWithStale(context.Background(), "k"); err == nil && !stale && v == 1 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Errorf("stale_value_should_be_refreshed")
+}
+
+func testConcurrent(t *testing.T, newStore Factory) {
+	s := newStore()
+	defer s.Close()
+
+	const goroutines = 8
+	const ops = 1000
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for g := 0; g < goroutines; g++ {
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < ops; i++ {
+				key := fmt.Sprintf("key-%d", (g*7+i)%50)
+				switch i % 4 {
+				case 0:
+					_ = s.Set(key, key)
+				case 1:
+					if v, err := s.Get(key); err == nil && v != key {
+						t.Errorf("value_of_another_key, key: %v, got: %v", key, v)
+					}
+				case 2:
+					_ = s.Delete(key)
+				case 3:
+					_ = s.Increase("counter")
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// Increase is atomic
+	if v, _ := s.Get("counter"); v != goroutines*ops/4 {
+		t.Errorf("counter_incorrect, got: %v, want: %v", v, goroutines*ops/4)
+	}
+}
+
+func testClose(t *testing.T, newStore Factory) {
+	s := newStore(store.SetDefaultTimeout(time.Minute))
+	_ = s.Set("k", "v")
+	if err := s.Close(); err != nil {
+		t.Errorf("close_error, err: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("second_close_error, err: %v", err)
+	}
+}
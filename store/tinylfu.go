@@ -0,0 +1,314 @@
+package store
+
+import (
+	"sync"
+)
+
+const (
+	// windowPercent of the capacity is given to the admission window, the rest to the main region
+	windowPercent = 1
+	// protectedPercent of the main region is given to the protected segment, the rest to the probation segment
+	protectedPercent = 80
+
+	// sketchDepth is the number of rows of the count-min sketch
+	sketchDepth = 4
+	// sketchMinWidth is the width used when the store has no capacity to size the sketch from
+	sketchMinWidth = 1024
+	// sketchMaxCount is the saturation value of a counter. Like the 4-bit counters of Caffeine.
+	sketchMaxCount = 15
+	// sketchResetMultiplier * width increments trigger a reset which halves all the counters
+	sketchResetMultiplier = 10
+
+	// tinyLFUBufferSize is the number of accesses a shard buffers before applying them to the policy
+	tinyLFUBufferSize = 32
+)
+
+// segments of the W-TinyLFU policy an entry can be in
+const (
+	segmentNone uint8 = iota
+	segmentWindow
+	segmentProbation
+	segmentProtected
+)
+
+// countMinSketch estimate the access frequency of keys in a fixed amount of memory. The counters are halved
+// periodically so the estimation follows the recent history rather than the whole lifetime of the store.
+type countMinSketch struct {
+	rows      [sketchDepth][]uint8
+	mask      uint32
+	additions int
+	resetAt   int
+}
+
+func newCountMinSketch(width int) *countMinSketch {
+	w := sketchMinWidth
+	for w < width {
+		w <<= 1
+	}
+	c := &countMinSketch{
+		mask:    uint32(w - 1),
+		resetAt: w * sketchResetMultiplier,
+	}
+	for i := range c.rows {
+		c.rows[i] = make([]uint8, w)
+	}
+	return c
+}
+
+// indexes derive one column per row from a single hash with double hashing
+func (c *countMinSketch) indexes(key string) (idx [sketchDepth]uint32) {
+	h1 := fnv32(key)
+	h2 := h1>>17 | h1<<15
+	h2 ^= 0x9e3779b9
+	for i := range idx {
+		idx[i] = (h1 + uint32(i)*h2) & c.mask
+	}
+	return idx
+}
+
+func (c *countMinSketch) increment(key string) {
+	idx := c.indexes(key)
+	added := false
+	for i, j := range idx {
+		if c.rows[i][j] < sketchMaxCount {
+			c.rows[i][j]++
+			added = true
+		}
+	}
+	if added {
+		c.additions++
+		if c.additions >= c.resetAt {
+			c.reset()
+		}
+	}
+}
+
+func (c *countMinSketch) estimate(key string) uint8 {
+	idx := c.indexes(key)
+	min := uint8(sketchMaxCount)
+	for i, j := range idx {
+		if c.rows[i][j] < min {
+			min = c.rows[i][j]
+		}
+	}
+	return min
+}
+
+// reset halve every counter to age the history
+func (c *countMinSketch) reset() {
+	for i := range c.rows {
+		for j := range c.rows[i] {
+			c.rows[i][j] >>= 1
+		}
+	}
+	c.additions /= 2
+}
+
+// tinyLFUAccess is an access buffered by a shard, on e or on key if e is nil
+type tinyLFUAccess struct {
+	e   *entry
+	key string
+}
+
+// tinyLFU implements the W-TinyLFU policy: new entries go to a small LRU window, and an entry leaving the window
+// is only admitted into the segmented LRU main region when the sketch says it is accessed more often than the
+// victim it would replace. An entry hit in probation is promoted to protected.
+// The hits and the misses are buffered by their shard and applied in batches, so most reads don't take the
+// policy mutex. The new entries are applied right away, with the buffer of their shard.
+type tinyLFU struct {
+	mu sync.Mutex // Always acquired after the shard lock, never before
+
+	capacity  int // 0 means the region sizes follow the current number of keys
+	sketch    *countMinSketch
+	window    entryList
+	probation entryList
+	protected entryList
+}
+
+func newTinyLFU(capacity int) *tinyLFU {
+	return &tinyLFU{
+		capacity: capacity,
+		sketch:   newCountMinSketch(capacity),
+	}
+}
+
+func (p *tinyLFU) size() int {
+	return p.window.len + p.probation.len + p.protected.len
+}
+
+func (p *tinyLFU) windowMax() int {
+	limit := p.capacity
+	if limit == 0 {
+		limit = p.size()
+	}
+	if max := limit * windowPercent / 100; max > 1 {
+		return max
+	}
+	return 1
+}
+
+func (p *tinyLFU) protectedMax() int {
+	limit := p.capacity
+	if limit == 0 {
+		limit = p.size()
+	}
+	return (limit - p.windowMax()) * protectedPercent / 100
+}
+
+// recordMiss count an access on a key not in the store, so a key missed often gets admitted once it is set.
+// The caller must hold the lock of sm.
+func (p *tinyLFU) recordMiss(sm *shardedMap, key string) {
+	p.buffer(sm, tinyLFUAccess{key: key})
+}
+
+// access record an access on e. A new entry is put into the window. The caller must hold the lock of sm, the
+// entry's shard.
+func (p *tinyLFU) access(sm *shardedMap, e *entry, existed bool) {
+	if existed {
+		p.buffer(sm, tinyLFUAccess{e: e})
+		return
+	}
+	p.mu.Lock()
+	p.drain(sm)
+	p.record(e)
+	p.mu.Unlock()
+}
+
+// buffer add an access to the buffer of sm, and apply the buffer once it is full. The caller must hold the lock
+// of sm.
+func (p *tinyLFU) buffer(sm *shardedMap, access tinyLFUAccess) {
+	sm.tinyLFUBuffer = append(sm.tinyLFUBuffer, access)
+	if len(sm.tinyLFUBuffer) < tinyLFUBufferSize {
+		return
+	}
+	p.mu.Lock()
+	p.drain(sm)
+	p.mu.Unlock()
+}
+
+// drain apply the accesses buffered by sm. The entries removed since their access only count in the sketch.
+// The caller must hold the lock of sm and p.mu.
+func (p *tinyLFU) drain(sm *shardedMap) {
+	for i, access := range sm.tinyLFUBuffer {
+		if access.e == nil {
+			p.sketch.increment(access.key)
+		} else if e, ok := sm.m[access.e.key]; ok && e == access.e {
+			p.record(e)
+		} else {
+			p.sketch.increment(access.e.key)
+		}
+		sm.tinyLFUBuffer[i] = tinyLFUAccess{}
+	}
+	sm.tinyLFUBuffer = sm.tinyLFUBuffer[:0]
+}
+
+// record apply an access on e, an entry of the store. The caller must hold p.mu.
+func (p *tinyLFU) record(e *entry) {
+	p.sketch.increment(e.key)
+	existed := e.segment != segmentNone
+	if !existed {
+		e.segment = segmentWindow
+		p.window.pushFront(e)
+		// While the store is not full, the window overflows into main without any admission test.
+		// Once it is full, the overflow is left in the window for victim to arbitrate.
+		for p.window.len > p.windowMax() && p.capacity != 0 && p.size() <= p.capacity {
+			p.admit(p.window.back())
+		}
+		return
+	}
+
+	switch e.segment {
+	case segmentWindow:
+		p.window.moveToFront(e)
+	case segmentProbation:
+		p.probation.remove(e)
+		e.segment = segmentProtected
+		p.protected.pushFront(e)
+		for p.protected.len > p.protectedMax() && p.protected.len > 0 {
+			demoted := p.protected.back()
+			p.protected.remove(demoted)
+			demoted.segment = segmentProbation
+			p.probation.pushFront(demoted)
+		}
+	case segmentProtected:
+		p.protected.moveToFront(e)
+	}
+}
+
+// remove unlink e from its segment. The caller must hold the lock of the entry's shard.
+func (p *tinyLFU) remove(e *entry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.unlink(e)
+}
+
+func (p *tinyLFU) unlink(e *entry) {
+	switch e.segment {
+	case segmentWindow:
+		p.window.remove(e)
+	case segmentProbation:
+		p.probation.remove(e)
+	case segmentProtected:
+		p.protected.remove(e)
+	}
+	e.segment = segmentNone
+}
+
+// victim pick the entry to evict. When the window is over its size, its LRU entry is a candidate for the main
+// region and competes with the LRU entry of probation: the less frequent one is the victim and the winner stays
+// in main. The victim is returned still linked, it is unlinked when it is removed from its shard.
+func (p *tinyLFU) victim() *entry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var candidate *entry
+	if p.window.len > p.windowMax() {
+		candidate = p.window.back()
+	}
+
+	mainVictim := p.probation.back()
+	if mainVictim == nil {
+		mainVictim = p.protected.back()
+	}
+
+	switch {
+	case candidate == nil && mainVictim == nil:
+		return p.window.back()
+	case candidate == nil:
+		return mainVictim
+	case mainVictim == nil:
+		// nothing in main to compete with. Admit the candidate and evict from the window.
+		p.admit(candidate)
+		return p.window.back()
+	}
+
+	if p.sketch.estimate(candidate.key) > p.sketch.estimate(mainVictim.key) {
+		p.admit(candidate)
+		return mainVictim
+	}
+	return candidate
+}
+
+// admit move an entry from the window to the probation segment of main
+func (p *tinyLFU) admit(e *entry) {
+	p.window.remove(e)
+	e.segment = segmentProbation
+	p.probation.pushFront(e)
+}
+
+// tinyLFUEvict evict the victim chosen by the W-TinyLFU policy. Like lruEvict, the victim is chosen under the
+// policy mutex and removed under its shard lock afterwards.
+func (s *shardedMapStore) tinyLFUEvict() bool {
+	victim := s.tinyLFU.victim()
+	if victim == nil {
+		return false
+	}
+
+	sm := s.selectSharedMap(victim.key)
+	sm.mu.Lock()
+	if e, ok := sm.m[victim.key]; ok && e == victim {
+		s.removeEntry(sm, victim.key, e)
+	}
+	sm.mu.Unlock()
+	return true
+}
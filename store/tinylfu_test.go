@@ -0,0 +1,155 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func Test_countMinSketch(t *testing.T) {
+	c := newCountMinSketch(0)
+	for i := 0; i < 10; i++ {
+		c.increment("hot")
+	}
+	c.increment("cold")
+
+	if got := c.estimate("hot"); got != 10 {
+		t.Errorf("sketch_estimate_incorrect, got: %v, want: %v", got, 10)
+	}
+	if got := c.estimate("cold"); got != 1 {
+		t.Errorf("sketch_estimate_incorrect, got: %v, want: %v", got, 1)
+	}
+	if got := c.estimate("never"); got != 0 {
+		t.Errorf("sketch_estimate_incorrect, got: %v, want: %v", got, 0)
+	}
+
+	for i := 0; i < 100; i++ {
+		c.increment("hot")
+	}
+	if got := c.estimate("hot"); got != sketchMaxCount {
+		t.Errorf("sketch_counter_should_saturate, got: %v", got)
+	}
+
+	c.reset()
+	if got := c.estimate("hot"); got != sketchMaxCount/2 {
+		t.Errorf("sketch_reset_should_halve_counters, got: %v, want: %v", got, sketchMaxCount/2)
+	}
+}
+
+func Test_TinyLFU_Capacity(t *testing.T) {
+	s := GetShardedMapStore(SetCapacity(100), SetEvictionPolicy(EvictionTinyLFU))
+	for i := 0; i < 1000; i++ {
+		_ = s.Set(fmt.Sprintf("key-%d", i), i)
+	}
+
+	found := 0
+	for i := 0; i < 1000; i++ {
+		if _, err := s.Get(fmt.Sprintf("key-%d", i)); err == nil {
+			found++
+		}
+	}
+	if found != 100 {
+		t.Errorf("tiny_lfu_should_keep_capacity_keys, got: %v, want: %v", found, 100)
+	}
+}
+
+func Test_TinyLFU_ScanResistance(t *testing.T) {
+	s := GetShardedMapStore(SetCapacity(100), SetEvictionPolicy(EvictionTinyLFU))
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 50; i++ {
+			key := fmt.Sprintf("hot-%d", i)
+			if _, err := s.Get(key); errors.Is(err, ErrKeyNotFound) {
+				_ = s.Set(key, i)
+			}
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		_ = s.Set(fmt.Sprintf("cold-%d", i), i)
+	}
+
+	for i := 0; i < 50; i++ {
+		if _, err := s.Get(fmt.Sprintf("hot-%d", i)); err != nil {
+			t.Errorf("hot_key_should_survive_scan, key: hot-%d, err: %v", i, err)
+		}
+	}
+}
+
+func Test_TinyLFU_BufferedAccesses(t *testing.T) {
+	s := GetShardedMapStore(SetCapacity(100), SetEvictionPolicy(EvictionTinyLFU)).(*shardedMapStore)
+	_ = s.Set("key", 1)
+	sm := s.selectSharedMap("key")
+	for i := 0; i < tinyLFUBufferSize-1; i++ {
+		_, _ = s.Get("key")
+	}
+	// the hits wait in the buffer of the shard
+	if got := s.tinyLFU.sketch.estimate("key"); got != 1 || len(sm.tinyLFUBuffer) != tinyLFUBufferSize-1 {
+		t.Errorf("hits_should_be_buffered, estimate: %v, buffered: %v", got, len(sm.tinyLFUBuffer))
+	}
+	_, _ = s.Get("key")
+	if got := s.tinyLFU.sketch.estimate("key"); got != sketchMaxCount || len(sm.tinyLFUBuffer) != 0 {
+		t.Errorf("full_buffer_should_be_applied, estimate: %v, buffered: %v", got, len(sm.tinyLFUBuffer))
+	}
+}
+
+func Test_TinyLFU_HitRatio(t *testing.T) {
+	trace := skewedTrace(100000)
+	lru := replayTrace(GetShardedMapStore(SetCapacity(500), SetEvictionPolicy(EvictionLRU)), trace)
+	tinyLFU := replayTrace(GetShardedMapStore(SetCapacity(500), SetEvictionPolicy(EvictionTinyLFU)), trace)
+	if tinyLFU < lru {
+		t.Errorf("tiny_lfu_hit_ratio_should_not_be_worse_than_lru, tiny_lfu: %v, lru: %v", tinyLFU, lru)
+	}
+}
+
+// skewedTrace generate keys following a zipf distribution, interrupted by scans of keys never seen again
+func skewedTrace(n int) []string {
+	r := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(r, 1.1, 1, 100000)
+	trace := make([]string, 0, n)
+	scanned := 0
+	for len(trace) < n {
+		if len(trace)%10000 == 0 {
+			for i := 0; i < 1000; i++ {
+				trace = append(trace, fmt.Sprintf("scan-%d", scanned))
+				scanned++
+			}
+		}
+		trace = append(trace, fmt.Sprintf("key-%d", zipf.Uint64()))
+	}
+	return trace[:n]
+}
+
+// replayTrace read every key of the trace, set it on a miss and return the hit ratio
+func replayTrace(s Store, trace []string) float64 {
+	hits := 0
+	for _, key := range trace {
+		if _, err := s.Get(key); err == nil {
+			hits++
+		} else {
+			_ = s.Set(key, key)
+		}
+	}
+	return float64(hits) / float64(len(trace))
+}
+
+func Benchmark_TraceReplay(b *testing.B) {
+	trace := skewedTrace(200000)
+	policies := []struct {
+		name   string
+		policy EvictionPolicy
+	}{
+		{"LRU", EvictionLRU},
+		{"LFU", EvictionLFU},
+		{"TinyLFU", EvictionTinyLFU},
+	}
+	for _, p := range policies {
+		b.Run(p.name, func(b *testing.B) {
+			var ratio float64
+			for i := 0; i < b.N; i++ {
+				ratio = replayTrace(GetShardedMapStore(SetCapacity(1000), SetEvictionPolicy(p.policy)), trace)
+			}
+			b.ReportMetric(ratio*100, "hit%")
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Tx is the view of a transaction on its keys, see Store.Txn. Only the keys given to Txn can be used. The writes
+// are buffered, and applied all at once when the function given to Txn returns nil.
+type Tx interface {
+	Get(key string) (interface{}, error)
+	// Version return the version of key when the transaction started, 0 if it didn't exist. Unlike Get, it works
+	// on the keys holding a list or a hash.
+	Version(key string) (uint64, error)
+	// Set write key with a timeout. A timeout of 0 means no timeout.
+	Set(key string, value interface{}, timeout time.Duration) error
+	// SetWithDefaultTimeout write key with the default timeout of the store, like Store.Set
+	SetWithDefaultTimeout(key string, value interface{}) error
+	Delete(key string) error
+	// IncrBy is Store.IncrBy within the transaction
+	IncrBy(key string, delta int64) (int64, error)
+}
+
+// txn implement Tx over the keys a store locked for it
+type txn struct {
+	keys   map[string]bool
+	writes map[string]*txnWrite
+	order  []string // the keys written, in the order of their first write
+	// load read the version of a key from the store, which holds its lock. decode return its value, so a key the
+	// transaction can't decode, like a list or a hash, still has a version.
+	load func(key string) (version uint64, found bool, decode func() (interface{}, error))
+}
+
+type txnWrite struct {
+	value          interface{}
+	timeout        time.Duration
+	keepTTL        bool // keep the timeout of the entry, or use the default timeout if there is none
+	defaultTimeout bool // use the default timeout
+	deleted        bool
+}
+
+func newTxn(keys []string, load func(key string) (uint64, bool, func() (interface{}, error))) *txn {
+	tx := &txn{
+		keys:   make(map[string]bool, len(keys)),
+		writes: make(map[string]*txnWrite),
+		load:   load,
+	}
+	for _, key := range keys {
+		tx.keys[key] = true
+	}
+	return tx
+}
+
+func (tx *txn) Get(key string) (interface{}, error) {
+	if !tx.keys[key] {
+		return nil, keyError("txn get", key, ErrNotInTxn)
+	}
+	if w, ok := tx.writes[key]; ok {
+		if w.deleted {
+			return nil, keyError("txn get", key, ErrKeyNotFound)
+		}
+		return w.value, nil
+	}
+	_, found, decode := tx.load(key)
+	if !found {
+		return nil, keyError("txn get", key, ErrKeyNotFound)
+	}
+	value, err := decode()
+	if err != nil {
+		return nil, keyError("txn get", key, err)
+	}
+	return value, nil
+}
+
+func (tx *txn) Version(key string) (uint64, error) {
+	if !tx.keys[key] {
+		return 0, keyError("txn version", key, ErrNotInTxn)
+	}
+	version, _, _ := tx.load(key)
+	return version, nil
+}
+
+func (tx *txn) Set(key string, value interface{}, timeout time.Duration) error {
+	if !tx.keys[key] {
+		return keyError("txn set", key, ErrNotInTxn)
+	}
+	tx.put(key, &txnWrite{value: value, timeout: timeout})
+	return nil
+}
+
+func (tx *txn) SetWithDefaultTimeout(key string, value interface{}) error {
+	if !tx.keys[key] {
+		return keyError("txn set", key, ErrNotInTxn)
+	}
+	tx.put(key, &txnWrite{value: value, defaultTimeout: true})
+	return nil
+}
+
+func (tx *txn) Delete(key string) error {
+	if !tx.keys[key] {
+		return keyError("txn delete", key, ErrNotInTxn)
+	}
+	tx.put(key, &txnWrite{deleted: true})
+	return nil
+}
+
+func (tx *txn) IncrBy(key string, delta int64) (int64, error) {
+	current, err := tx.Get(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		tx.put(key, &txnWrite{value: int(delta), keepTTL: true})
+		return delta, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	value, n, err := incrementBy(current, delta)
+	if err != nil {
+		return 0, keyError("txn incr by", key, err)
+	}
+	if w, ok := tx.writes[key]; ok {
+		// keep the timeout of the earlier write
+		w.value = value
+		return n, nil
+	}
+	tx.put(key, &txnWrite{value: value, keepTTL: true})
+	return n, nil
+}
+
+func (tx *txn) put(key string, w *txnWrite) {
+	if _, ok := tx.writes[key]; !ok {
+		tx.order = append(tx.order, key)
+	}
+	tx.writes[key] = w
+}
+
+// Txn run fn with the shards of keys locked, in the order of the shards so concurrent transactions can't deadlock.
+// The writes of fn are applied all at once if it returns nil, and discarded otherwise, and its error is returned.
+// fn must only use tx, the store itself may wait for the locks the transaction holds.
+func (s *shardedMapStore) Txn(ctx context.Context, keys []string, fn func(tx Tx) error) error {
+	batches := groupByShard(keys, s.shardIndex)
+	for n, b := range batches {
+		if err := s.shardedMaps[b.shard].lock(ctx); err != nil {
+			s.unlockBatches(batches[:n])
+			return keyError("txn", keys[b.items[0]], err)
+		}
+	}
+
+	var tx *txn
+	err := func() error {
+		defer s.unlockBatches(batches)
+		now := s.now()
+		tx = newTxn(keys, func(key string) (uint64, bool, func() (interface{}, error)) {
+			sm := s.selectSharedMap(key)
+			data, _, ok := s.read(sm, key, now, nil)
+			if !ok {
+				return 0, false, nil
+			}
+			return sm.m[key].version, true, func() (interface{}, error) {
+				return s.valueOf(data)
+			}
+		})
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return s.commit(ctx, tx, now)
+	}()
+	if err != nil {
+		return err
+	}
+
+	if len(tx.order) > 0 {
+		s.evictIfNeeded(tx.order[len(tx.order)-1])
+	}
+	return nil
+}
+
+// commit apply the writes of tx. Nothing is applied if one of them fails to be prepared or written to the backend,
+// and the writes which reached the backend are undone, see persistAll. The caller must hold the locks of the shards
+// of the keys.
+func (s *shardedMapStore) commit(ctx context.Context, tx *txn, now int64) error {
+	prepared := make([]preparedValue, len(tx.order))
+	for i, key := range tx.order {
+		w := tx.writes[key]
+		if w.deleted {
+			continue
+		}
+		timeout := w.timeout
+		if w.keepTTL || w.defaultTimeout {
+			timeout = s.defaultTimeout
+		}
+		p, err := s.prepare(key, w.value, s.staleAfter, timeout, s.slidingExpiration)
+		if err != nil {
+			return keyError("txn", key, err)
+		}
+		if e, ok := s.selectSharedMap(key).m[key]; w.keepTTL && ok && now <= e.deadline {
+			p.deadline, p.softDeadline, p.timeout, p.sliding = e.deadline, e.softDeadline, time.Duration(e.timeout), e.sliding
+			if e.sliding {
+				p.deadline = now + e.timeout
+			}
+		}
+		prepared[i] = p
+	}
+
+	persisted := make([]backendWrite, len(tx.order))
+	for i, key := range tx.order {
+		persisted[i] = backendWrite{key: key, value: prepared[i].value, deleted: tx.writes[key].deleted}
+	}
+	if err := s.persistAll(ctx, "txn", persisted); err != nil {
+		return err
+	}
+	for i, key := range tx.order {
+		sm := s.selectSharedMap(key)
+		if !tx.writes[key].deleted {
+			s.write(sm, &prepared[i])
+		} else {
+			if e, ok := sm.m[key]; ok {
+				s.removeEntry(sm, key, e)
+			}
+			s.markDeleted(sm)
+		}
+	}
+	return nil
+}
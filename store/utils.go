@@ -9,4 +9,15 @@ func fnv32(key string) uint32 {
 		hash ^= uint32(key[i])
 	}
 	return hash
-}
\ No newline at end of file
+}
+
+const prime64 = uint64(1099511628211)
+
+func fnv64(key string) uint64 {
+	hash := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		hash *= prime64
+		hash ^= uint64(key[i])
+	}
+	return hash
+}